@@ -1,6 +1,7 @@
 package main
 
 import (
+    "time"
     "net/http"
 
     "github.com/go-chi/chi"
@@ -36,6 +37,7 @@ func V1Router() http.Handler {
     opts := storage.PrefixedStoragesOpts(STANDALONE_WS)
 
     opts.DefaultStorageType = storage_ifaces.StorageHashedFilesystem
+    opts.ReaperInterval = time.Minute
 
     return storage_server.InitializeServer(r, opts, nil)
 }
@@ -94,9 +94,24 @@ func (bm *BuffersManager) Append(bid string, source io.Reader) (int, error) {
     }
     defer f.Close()
 
+    fi, err := f.Stat()
+    if err != nil {
+        rerr := fmt.Errorf("Unable to stat storage file for buffer {id: %s}. Err: %w", bid, err)
+        buffersLog.Print(rerr.Error())
+        return 0, rerr
+    }
+
     copied, err := io.Copy(f, source)
+    if err != nil {
+        // Append is all or nothing, so partially appended data is dropped.
+        buffersLog.Printf("Append to buffer {id: %s} error: %s. Drop %d bytes of data.", bid, err, copied)
+        if terr := f.Truncate(fi.Size()); terr != nil {
+            buffersLog.Panicf("Unable to truncate storage file for buffer {id: %s}. Err: %s", bid, terr)
+        }
+        return 0, err
+    }
 
     buffersLog.Printf("Appended %d bytes of data to buffer {id: %s}", copied, bid)
 
-    return int(copied), err
+    return int(copied), nil
 }
@@ -3,6 +3,7 @@ package filesystem_utils
 import (
     "os"
     "fmt"
+    "path/filepath"
 )
 
 
@@ -20,3 +21,19 @@ func EnsureDir(path string, mode os.FileMode) error {
 
     return nil
 }
+
+
+// Remove empty directories starting from path and going up to the
+// root (exclusive). Stops on first non empty directory.
+func RemoveEmptyDirs(root string, path string) {
+
+    root = filepath.Clean(root)
+
+    for dir := filepath.Clean(path); dir != root && len(dir) > len(root); dir = filepath.Dir(dir) {
+        if err := os.Remove(dir); err != nil {
+            break
+        }
+
+        fsUtilsLog.Printf("Removed empty directory: %s", dir)
+    }
+}
@@ -1,16 +1,86 @@
 package hashed_filesystem_storage
 
 import (
+    "fmt"
     "sync"
+    "time"
     "encoding/json"
 
     "../../ifaces"
 )
 
 type asset struct {
-    Path    storage_ifaces.Path             `json:"path"`
-    Object  string                          `json:"object"`
-    Opts    storage_ifaces.StorageAssetOpts `json:"opts"`
+    Path        storage_ifaces.Path             `json:"path"`
+    Object      string                          `json:"object"`
+    Opts        storage_ifaces.StorageAssetOpts `json:"opts"`
+    Size        int64                           `json:"size"`
+
+    // Secondary content digests, see StoragesManagerOpts.SecondaryDigests
+    Digests     storage_ifaces.StorageAssetDigests `json:"digests,omitempty"`
+    Created     time.Time                       `json:"created"`
+    Modified    time.Time                       `json:"modified"`
+}
+
+
+func (a *asset) Info() *storage_ifaces.StorageAssetInfo {
+    return &storage_ifaces.StorageAssetInfo{
+        StorageAssetOpts:   a.Opts,
+        Path:               a.Path,
+        Size:               a.Size,
+        ETag:               a.ETag(),
+        Digests:            a.AllDigests(),
+        Created:            a.Created,
+        Modified:           a.Modified,
+    }
+}
+
+
+// Returns true if the asset content is stored in the vault. Symlinks and
+// directories have no vault objects.
+func (a *asset) HasObject() bool {
+    return len(a.Object) > 0
+}
+
+
+// Content sha256 if it is known.
+func (a *asset) Sha256() (string, bool) {
+    if algorithm, digest, ok := storage_ifaces.ParseVaultObject(a.Object); ok && algorithm == storage_ifaces.DigestSha256 {
+        return digest, true
+    }
+
+    digest, ok := a.Digests[storage_ifaces.DigestSha256]
+    return digest, ok
+}
+
+
+// All known content digests: the vault object one and the secondary ones.
+func (a *asset) AllDigests() storage_ifaces.StorageAssetDigests {
+    algorithm, digest, ok := storage_ifaces.ParseVaultObject(a.Object)
+    if !ok {
+        return nil
+    }
+
+    digests := storage_ifaces.StorageAssetDigests{algorithm: digest}
+    for name, value := range a.Digests {
+        digests[name] = value
+    }
+
+    return digests
+}
+
+
+//  Asset ETag. The content sha256 for files if it is known (so ETags are
+// the same for all storages types), the vault object id otherwise.
+func (a *asset) ETag() string {
+    if !a.HasObject() {
+        return a.Opts.KindChecksum()
+    }
+
+    if digest, ok := a.Sha256(); ok {
+        return digest
+    }
+
+    return a.Object
 }
 
 
@@ -26,6 +96,7 @@ type assetsMap struct {
     sync.Mutex
 
     values map[storage_ifaces.Path]*asset
+    index  storage_ifaces.PathIndex
 }
 
 
@@ -41,6 +112,7 @@ func (m *assetsMap) Store(path storage_ifaces.Path, asset *asset) {
     defer m.Unlock()
 
     m.values[path] = asset
+    m.index.Insert(path)
 }
 
 
@@ -57,6 +129,49 @@ func (m *assetsMap) Load(path storage_ifaces.Path) (*asset, bool) {
 }
 
 
+func (m *assetsMap) Delete(path storage_ifaces.Path) (*asset, bool) {
+    m.Lock()
+    defer m.Unlock()
+
+    a, ok := m.values[path]
+    if !ok {
+        return nil, false
+    }
+
+    delete(m.values, path)
+    m.index.Delete(path)
+
+    return a, true
+}
+
+
+//  Atomically move asset from one path to another. Returns moved asset
+// or error wrapping ASSET_NOT_EXIST/ASSET_EXIST.
+func (m *assetsMap) Move(from storage_ifaces.Path, to storage_ifaces.Path) (*asset, error) {
+    m.Lock()
+    defer m.Unlock()
+
+    a, ok := m.values[from]
+    if !ok {
+        return nil, fmt.Errorf("Attempt to move non existing asset: %s. Err: %w", from, storage_ifaces.ASSET_NOT_EXIST)
+    }
+
+    if _, ok := m.values[to]; ok {
+        return nil, fmt.Errorf("Attempt to move asset: %s to existing asset: %s. Err: %w", from, to, storage_ifaces.ASSET_EXIST)
+    }
+
+    moved := *a
+    moved.Path = to
+
+    delete(m.values, from)
+    m.values[to] = &moved
+    m.index.Delete(from)
+    m.index.Insert(to)
+
+    return &moved, nil
+}
+
+
 func (m *assetsMap) Range(callback func(path storage_ifaces.Path, asset *asset) bool) {
     m.Lock()
     defer m.Unlock()
@@ -69,15 +184,55 @@ func (m *assetsMap) Range(callback func(path storage_ifaces.Path, asset *asset)
 }
 
 
+// Get assets in range sorted by path.
+func (m *assetsMap) Sorted(opts storage_ifaces.StorageRangeOpts) []*asset {
+    m.Lock()
+    defer m.Unlock()
+
+    paths := m.index.Next(opts, 0)
+
+    assets := make([]*asset, 0, len(paths))
+    for _, p := range paths {
+        assets = append(assets, m.values[p])
+    }
+
+    return assets
+}
+
+
+//  Enumerate assets in range sorted by path. The map is not locked while
+// the callback runs.
+func (m *assetsMap) RangeFrom(opts storage_ifaces.StorageRangeOpts, callback func(path storage_ifaces.Path, asset *asset) bool) {
+    m.index.Range(opts, func(path storage_ifaces.Path) bool {
+        a, ok := m.Load(path)
+        if !ok {
+            return true
+        }
+
+        return callback(path, a)
+    })
+}
+
+
 func (m *assetsMap) UnmarshalJSON(b []byte) (err error) {
     m.Lock()
     defer m.Unlock()
 
-    return json.Unmarshal(b, &m.values)
+    if err := json.Unmarshal(b, &m.values); err != nil {
+        return err
+    }
+
+    paths := make([]storage_ifaces.Path, 0, len(m.values))
+    for p := range m.values {
+        paths = append(paths, p)
+    }
+    m.index.Reset(paths)
+
+    return nil
 }
 
 
-func (m assetsMap) MarshalJSON() ([]byte, error) {
+func (m *assetsMap) MarshalJSON() ([]byte, error) {
     m.Lock()
     defer m.Unlock()
 
@@ -4,30 +4,73 @@ import (
     "io"
     "hash"
     "fmt"
-    "crypto/sha256"
+
+    "../../ifaces"
 )
 
 type CalcChecksumsWriter struct {
     io.Writer
 
-    // Selected checksum builder
+    // Selected checksum algorithm, defines the vault object id
+    algorithm   string
     checksum    hash.Hash
+
+    // Secondary checksums calculated in the same pass
+    secondary   map[string]hash.Hash
 }
 
 
-func NewCalcChecksumsWriter(dst io.Writer) *CalcChecksumsWriter {
+func NewCalcChecksumsWriter(dst io.Writer, algorithm string, secondary ...string) (*CalcChecksumsWriter, error) {
+
+    checksum, err := storage_ifaces.NewDigestHash(algorithm)
+    if err != nil {
+        return nil, err
+    }
 
-    checksum    := sha256.New()
+    writers := []io.Writer{dst, checksum}
 
     w := &CalcChecksumsWriter{
-        Writer      : io.MultiWriter(dst, checksum),
+        algorithm   : algorithm,
         checksum    : checksum,
+        secondary   : make(map[string]hash.Hash),
+    }
+
+    for _, name := range secondary {
+        if _, ok := w.secondary[name]; ok || name == algorithm {
+            continue
+        }
+
+        h, err := storage_ifaces.NewDigestHash(name)
+        if err != nil {
+            return nil, err
+        }
+
+        w.secondary[name] = h
+        writers = append(writers, h)
     }
 
-    return w
+    w.Writer = io.MultiWriter(writers...)
+
+    return w, nil
 }
 
 
+// Vault object id of the written data.
 func (w *CalcChecksumsWriter) String() string {
-    return fmt.Sprintf("%x", w.checksum.Sum(nil))
+    return storage_ifaces.MakeVaultObject(w.algorithm, fmt.Sprintf("%x", w.checksum.Sum(nil)))
+}
+
+
+// Secondary digests of the written data.
+func (w *CalcChecksumsWriter) Digests() storage_ifaces.StorageAssetDigests {
+    if len(w.secondary) == 0 {
+        return nil
+    }
+
+    digests := make(storage_ifaces.StorageAssetDigests)
+    for name, h := range w.secondary {
+        digests[name] = fmt.Sprintf("%x", h.Sum(nil))
+    }
+
+    return digests
 }
@@ -6,6 +6,10 @@ import (
     "io/ioutil"
     "bufio"
     "fmt"
+    "strings"
+    "crypto/sha256"
+    "sync"
+    "time"
     "path/filepath"
 
     filesystem_utils ".."
@@ -14,6 +18,11 @@ import (
 
 
 type HashedFilesystemStorage struct {
+    //  Serializes assets modifications. Readers hold it while the vault
+    // object is opened, so the object of the replaced asset is not removed
+    // before that.
+    lock        sync.RWMutex
+
     root        storage_ifaces.Path
     metadata    storage_ifaces.Path
     assets      assetsMap
@@ -24,7 +33,7 @@ func (hfs *HashedFilesystemStorage) Initialize(s *storage_ifaces.Storage) error
 
     hfsLog.Printf("%s: Initialize storage.", s.Name())
 
-    hfs.root = filepath.Join(s.Parent.Opts().StoragesRoot, s.Id.String())
+    hfs.root = filepath.Join(s.Parent.Opts().StoragesRoot, s.RootName())
 
     hfsLog.Printf("%s: Ensure root: %s", s.Name(), hfs.root)
 
@@ -50,10 +59,7 @@ func (hfs *HashedFilesystemStorage) Destroy(s *storage_ifaces.Storage) error {
 
     hfs.assets.Range(func(path storage_ifaces.Path, asset *asset) bool {
 
-        hfsLog.Printf("%s: Unreference vault object: %s referenced by: %s", s.Name(), asset.Object, asset.Path)
-
-        // Unreference vault object
-        s.Parent.Vault().Unref(s, asset.VaultAsset())
+        hfs.unref(s, asset)
 
         return true
     })
@@ -69,43 +75,68 @@ func (hfs *HashedFilesystemStorage) CreateAsset(s *storage_ifaces.Storage, path
     hfsLog.Printf("%s: Create asset: %s opts: %s", s.Name(), path, r.Opts.String())
 
     if _, ok := hfs.assets.Load(path); ok {
+        err := fmt.Errorf("Asset: '%s' already exist! Err: %w", path, storage_ifaces.ASSET_EXIST)
+        hfsLog.Printf("%s: Create asset error: %s", s.Name(), err)
+        return err
+    }
 
-        err := fmt.Errorf("Asset: '%s' already exist!", path)
+    _, err := hfs.WriteAsset(s, path, r, storage_ifaces.StorageAssetCond{IfNoneMatch: "*"})
+    return err
+}
 
-        hfsLog.Printf("%s: Create asset error: %s", s.Name(), err)
 
-        return err
+func (hfs *HashedFilesystemStorage) WriteAsset(s *storage_ifaces.Storage, path storage_ifaces.Path, r *storage_ifaces.StorageAssetReader, cond storage_ifaces.StorageAssetCond) (string, error) {
+
+    hfsLog.Printf("%s: Write asset: %s opts: %s cond: %s", s.Name(), path, r.Opts.String(), cond.String())
+
+    // Fail fast, before the data will be received.
+    if err := hfs.checkCond(path, cond); err != nil {
+        hfsLog.Printf("%s: Write asset error: %s", s.Name(), err)
+        return "", err
     }
 
+    if !r.Opts.IsFile() {
+        return hfs.writeSpecialAsset(s, path, r.Opts, cond)
+    }
 
     f, err := ioutil.TempFile(s.Parent.Opts().TempDir, s.Parent.Opts().TempPattern)
     if err != nil {
         hfsLog.Printf("%s: Can't create temp file! Error: %s", s.Name(), err)
-        return err
+        return "", err
     }
 
     fw      := bufio.NewWriter(f)
 
-    writer  := NewCalcChecksumsWriter(fw)
+    opts    := s.Parent.Opts()
+
+    writer, err := NewCalcChecksumsWriter(fw, opts.VaultDigestAlgorithm(), opts.SecondaryDigests...)
+    if err != nil {
+        hfsLog.Printf("%s: Checksums writer error: %s", s.Name(), err)
+        f.Close()
+        os.Remove(f.Name())
+        return "", err
+    }
+
+    var size int64
 
     writeProc := func() error {
         defer f.Close()
 
-        _, err  = io.Copy(writer, r)
+        size, err  = io.Copy(writer, r)
         if err != nil {
-            hfsLog.Printf("%s: Create asset copy error: %s", s.Name(), err)
+            hfsLog.Printf("%s: Write asset copy error: %s", s.Name(), err)
             return err
         }
 
         err = fw.Flush()
         if err != nil {
-            hfsLog.Printf("%s: Create asset flush error: %s", s.Name(), err)
+            hfsLog.Printf("%s: Write asset flush error: %s", s.Name(), err)
             return err
         }
 
         err = f.Chmod(os.FileMode(s.Parent.Opts().VaultMode))
         if err != nil {
-            hfsLog.Printf("%s: Create asset chmod error: %s", s.Name(), err)
+            hfsLog.Printf("%s: Write asset chmod error: %s", s.Name(), err)
             return err
         }
 
@@ -119,21 +150,55 @@ func (hfs *HashedFilesystemStorage) CreateAsset(s *storage_ifaces.Storage, path
             hfsLog.Panicf("%s: Remove temp file error: %s", s.Name(), rmErr)
         }
 
-        hfsLog.Panicf("%s: Write proc error: %s", s.Name(), err)
+        hfsLog.Printf("%s: Write proc error: %s", s.Name(), err)
+        return "", err
     }
 
     asset := &asset{
-        Opts:   r.Opts,
-        Path:   path,
-        Object: writer.String(),
+        Opts:       r.Opts,
+        Path:       path,
+        Object:     writer.String(),
+        Size:       size,
+        Digests:    writer.Digests(),
+        Modified:   time.Now().UTC(),
+    }
+
+    asset.Created = asset.Modified
+
+    hfs.lock.Lock()
+    defer hfs.lock.Unlock()
+
+    // The asset can be changed while the data was received.
+    if err := hfs.checkCond(path, cond); err != nil {
+        hfsLog.Printf("%s: Write asset error: %s", s.Name(), err)
+
+        hfsLog.Printf("%s: Remove temp file: %s", s.Name(), f.Name())
+        if rmErr := os.Remove(f.Name()); rmErr != nil {
+            hfsLog.Panicf("%s: Remove temp file error: %s", s.Name(), rmErr)
+        }
+
+        return "", err
+    }
+
+    old, exist := hfs.assets.Load(path)
+    if exist {
+        asset.Created = old.Created
     }
 
     hfsLog.Printf("%s: Put object to vault as: %s referenced by asset: %s", s.Name(), asset.Object, asset.Path)
 
+    // The replaced asset is kept referenced until the new object is put,
+    // so the failed put leaves the storage intact.
     err = s.Parent.Vault().Put(s, asset.VaultAsset(), f.Name())
     if err != nil {
-        hfsLog.Printf("%s: Put object to vault error: %s", s.Name())
-        return err
+        hfsLog.Printf("%s: Put object to vault error: %s", s.Name(), err)
+        os.Remove(f.Name())
+        return "", err
+    }
+
+    // The same object reference is shared by the new and the replaced asset.
+    if exist && old.Object != asset.Object {
+        hfs.unref(s, old)
     }
 
     hfsLog.Printf("%s: Register asset: %s", s.Name(), path)
@@ -141,7 +206,191 @@ func (hfs *HashedFilesystemStorage) CreateAsset(s *storage_ifaces.Storage, path
 
     hfs.storeMetadata(s)
 
-    return err
+    return asset.ETag(), nil
+}
+
+
+//  Write symlink or directory asset. Such assets have no content, so
+// nothing is stored in the vault.
+func (hfs *HashedFilesystemStorage) writeSpecialAsset(s *storage_ifaces.Storage, path storage_ifaces.Path, opts storage_ifaces.StorageAssetOpts, cond storage_ifaces.StorageAssetCond) (string, error) {
+
+    asset := &asset{
+        Opts:       opts,
+        Path:       path,
+        Modified:   time.Now().UTC(),
+    }
+
+    asset.Created = asset.Modified
+
+    hfs.lock.Lock()
+    defer hfs.lock.Unlock()
+
+    if err := hfs.checkCond(path, cond); err != nil {
+        hfsLog.Printf("%s: Write asset error: %s", s.Name(), err)
+        return "", err
+    }
+
+    if old, ok := hfs.assets.Load(path); ok {
+        asset.Created = old.Created
+        hfs.unref(s, old)
+    }
+
+    hfsLog.Printf("%s: Register %s asset: %s", s.Name(), opts.Kind, path)
+    hfs.assets.Store(path, asset)
+
+    hfs.storeMetadata(s)
+
+    return asset.ETag(), nil
+}
+
+
+//  Create or replace asset referencing existing vault object. Nothing is
+// copied, only the new reference is added. The object is read only to
+// compute the secondary digests, if any.
+func (hfs *HashedFilesystemStorage) LinkAsset(s *storage_ifaces.Storage, path storage_ifaces.Path, object string, opts storage_ifaces.StorageAssetOpts, cond storage_ifaces.StorageAssetCond) (string, error) {
+
+    hfsLog.Printf("%s: Link asset: %s to object: %s opts: %s cond: %s", s.Name(), path, object, opts.String(), cond.String())
+
+    asset := &asset{
+        Opts:       opts,
+        Path:       path,
+        Object:     object,
+        Modified:   time.Now().UTC(),
+    }
+
+    asset.Created = asset.Modified
+
+    digests, err := hfs.objectDigests(s, asset.VaultAsset())
+    if err != nil {
+        hfsLog.Printf("%s: Link asset error: %s", s.Name(), err)
+        return "", err
+    }
+    asset.Digests = digests
+
+    hfs.lock.Lock()
+    defer hfs.lock.Unlock()
+
+    if err := hfs.checkCond(path, cond); err != nil {
+        hfsLog.Printf("%s: Link asset error: %s", s.Name(), err)
+        return "", err
+    }
+
+    old, exist := hfs.assets.Load(path)
+    if exist {
+        asset.Created = old.Created
+    }
+
+    // Stat before link, so nothing is to be rolled back on failure.
+    fi, err := s.Parent.Vault().StatObject(asset.VaultAsset())
+    if err != nil {
+        hfsLog.Printf("%s: Stat object error: %s", s.Name(), err)
+        return "", fmt.Errorf("Can't stat object: %s. Err: %w", object, storage_ifaces.OBJECT_NOT_EXIST)
+    }
+    asset.Size = fi.Size()
+
+    // The replaced asset may already reference the same object, so the
+    // reference is kept as is.
+    if !exist || old.Object != object {
+        if err := s.Parent.Vault().Link(s, asset.VaultAsset()); err != nil {
+            hfsLog.Printf("%s: Link object error: %s", s.Name(), err)
+            return "", fmt.Errorf("Can't link object: %s. Err: %w", object, storage_ifaces.OBJECT_NOT_EXIST)
+        }
+
+        if exist {
+            hfs.unref(s, old)
+        }
+    }
+
+    hfsLog.Printf("%s: Register asset: %s", s.Name(), path)
+    hfs.assets.Store(path, asset)
+
+    hfs.storeMetadata(s)
+
+    return asset.ETag(), nil
+}
+
+
+//  Compute secondary digests of the vault object. The vault keeps no
+// digests, so the linked asset gets them the same way as the written one
+// (see StoragesManagerOpts.SecondaryDigests) and has the same ETag.
+func (hfs *HashedFilesystemStorage) objectDigests(s *storage_ifaces.Storage, vaultAsset storage_ifaces.VaultAsset) (storage_ifaces.StorageAssetDigests, error) {
+
+    secondary := s.Parent.Opts().SecondaryDigests
+    if len(secondary) == 0 {
+        return nil, nil
+    }
+
+    algorithm, _, ok := storage_ifaces.ParseVaultObject(vaultAsset.Object)
+    if !ok {
+        return nil, fmt.Errorf("Bad vault object id: '%s'! Err: %w", vaultAsset.Object, storage_ifaces.BAD_ASSET_OPTS)
+    }
+
+    f, err := s.Parent.Vault().OpenObject(vaultAsset)
+    if err != nil {
+        return nil, fmt.Errorf("Can't open object: %s. Err: %w", vaultAsset.Object, storage_ifaces.OBJECT_NOT_EXIST)
+    }
+    defer f.Close()
+
+    writer, err := NewCalcChecksumsWriter(ioutil.Discard, algorithm, secondary...)
+    if err != nil {
+        return nil, err
+    }
+
+    if _, err := io.Copy(writer, f.File); err != nil {
+        return nil, err
+    }
+
+    if writer.String() != vaultAsset.Object {
+        return nil, fmt.Errorf("Vault object: %s content is %s! Err: %w", vaultAsset.Object, writer.String(), storage_ifaces.DIGEST_MISMATCH)
+    }
+
+    return writer.Digests(), nil
+}
+
+
+// Unreference asset vault object if any.
+func (hfs *HashedFilesystemStorage) unref(s *storage_ifaces.Storage, asset *asset) {
+
+    if !asset.HasObject() {
+        return
+    }
+
+    hfsLog.Printf("%s: Unreference vault object: %s referenced by: %s", s.Name(), asset.Object, asset.Path)
+
+    // The object will be removed by vault when it will be unreferenced
+    // and closed by all readers.
+    s.Parent.Vault().Unref(s, asset.VaultAsset())
+}
+
+
+//  Get vault objects references of the assets. The storage modifications
+// are blocked until the returned function is called.
+func (hfs *HashedFilesystemStorage) LockVaultAssets(s *storage_ifaces.Storage) ([]storage_ifaces.VaultAsset, func()) {
+
+    hfs.lock.Lock()
+
+    assets := make([]storage_ifaces.VaultAsset, 0, 100)
+
+    hfs.assets.Range(func(path storage_ifaces.Path, asset *asset) bool {
+        if asset.HasObject() {
+            assets = append(assets, asset.VaultAsset())
+        }
+        return true
+    })
+
+    return assets, hfs.lock.Unlock
+}
+
+
+// Check write preconditions against the registered asset. The vault
+// object id is used as the file asset ETag.
+func (hfs *HashedFilesystemStorage) checkCond(path storage_ifaces.Path, cond storage_ifaces.StorageAssetCond) error {
+
+    if asset, ok := hfs.assets.Load(path); ok {
+        return cond.Check(path, true, asset.ETag())
+    }
+
+    return cond.Check(path, false, "")
 }
 
 
@@ -149,9 +398,18 @@ func (hfs *HashedFilesystemStorage) ReadAsset(s *storage_ifaces.Storage, path st
 
     hfsLog.Printf("%s: Read asset: %s", s.Name(), path)
 
+    hfs.lock.RLock()
+    defer hfs.lock.RUnlock()
+
     asset, ok := hfs.assets.Load(path)
     if !ok {
-        return nil, fmt.Errorf("Attempt to read non existing asset: %s", path)
+        return nil, fmt.Errorf("Attempt to read non existing asset: %s. Err: %w", path, storage_ifaces.ASSET_NOT_EXIST)
+    }
+
+    if !asset.HasObject() {
+        return &storage_ifaces.StorageAssetReader{
+            Reader: strings.NewReader(""),
+            Opts: asset.Opts}, nil
     }
 
     f, err := s.Parent.Vault().OpenObject(asset.VaultAsset())
@@ -161,14 +419,160 @@ func (hfs *HashedFilesystemStorage) ReadAsset(s *storage_ifaces.Storage, path st
     }
 
     return &storage_ifaces.StorageAssetReader{
-        Reader: bufio.NewReader(f.File),
+        Reader: f.File,
         Closer: f,
         Opts: asset.Opts}, nil
 }
 
 
+func (hfs *HashedFilesystemStorage) StatAsset(s *storage_ifaces.Storage, path storage_ifaces.Path) (*storage_ifaces.StorageAssetInfo, error) {
+
+    hfsLog.Printf("%s: Stat asset: %s", s.Name(), path)
+
+    asset, ok := hfs.assets.Load(path)
+    if !ok {
+        return nil, fmt.Errorf("Attempt to stat non existing asset: %s. Err: %w", path, storage_ifaces.ASSET_NOT_EXIST)
+    }
+
+    info := asset.Info()
+
+    // Metadata stored by previous versions has no size and timestamps,
+    // so take them from the vault object.
+    if info.Modified.IsZero() && asset.HasObject() {
+        fi, err := s.Parent.Vault().StatObject(asset.VaultAsset())
+        if err != nil {
+            hfsLog.Printf("%s: Stat object error: %s", s.Name(), err)
+            return nil, err
+        }
+
+        info.Size       = fi.Size()
+        info.Created    = fi.ModTime().UTC()
+        info.Modified   = fi.ModTime().UTC()
+    }
+
+    return info, nil
+}
+
+
+func (hfs *HashedFilesystemStorage) DeleteAsset(s *storage_ifaces.Storage, path storage_ifaces.Path) error {
+
+    hfsLog.Printf("%s: Delete asset: %s", s.Name(), path)
+
+    hfs.lock.Lock()
+    defer hfs.lock.Unlock()
+
+    asset, ok := hfs.assets.Delete(path)
+    if !ok {
+        return fmt.Errorf("Attempt to delete non existing asset: %s. Err: %w", path, storage_ifaces.ASSET_NOT_EXIST)
+    }
+
+    hfs.storeMetadata(s)
+
+    hfs.unref(s, asset)
+
+    return nil
+}
+
+
+func (hfs *HashedFilesystemStorage) MoveAsset(s *storage_ifaces.Storage, from storage_ifaces.Path, to storage_ifaces.Path) error {
+
+    hfsLog.Printf("%s: Move asset: %s to: %s", s.Name(), from, to)
+
+    hfs.lock.Lock()
+    defer hfs.lock.Unlock()
+
+    asset, err := hfs.assets.Move(from, to)
+    if err != nil {
+        hfsLog.Printf("%s: Move asset error: %s", s.Name(), err)
+        return err
+    }
+
+    if asset.HasObject() {
+        hfsLog.Printf("%s: Rename vault object: %s reference from: %s to: %s", s.Name(), asset.Object, from, to)
+
+        // Same object, new path.
+        err = s.Parent.Vault().Rename(s, storage_ifaces.VaultAsset{Object: asset.Object, Path: from}, to)
+        if err != nil {
+            hfsLog.Printf("%s: Rename vault object reference error: %s", s.Name(), err)
+
+            // Keep the asset where its reference is.
+            if _, moveErr := hfs.assets.Move(to, from); moveErr != nil {
+                hfsLog.Printf("%s: Move asset back error: %s", s.Name(), moveErr)
+            }
+            return err
+        }
+    }
+
+    hfs.storeMetadata(s)
+
+    return nil
+}
+
+
 func (hfs *HashedFilesystemStorage) Range(s *storage_ifaces.Storage, callback storage_ifaces.StorageOpsCallback) {
     hfs.assets.Range(func(path storage_ifaces.Path, asset *asset) bool {
         return callback(path, asset.Opts)
     })
 }
+
+
+func (hfs *HashedFilesystemStorage) RangeFrom(s *storage_ifaces.Storage, opts storage_ifaces.StorageRangeOpts, callback storage_ifaces.StorageOpsCallback) {
+    hfs.assets.RangeFrom(opts, func(path storage_ifaces.Path, asset *asset) bool {
+        return callback(path, asset.Opts)
+    })
+}
+
+
+func (hfs *HashedFilesystemStorage) Stats(s *storage_ifaces.Storage) (storage_ifaces.StorageStats, error) {
+    stats := storage_ifaces.StorageStats{}
+
+    objects := make(map[string]int64)
+
+    for _, asset := range hfs.assets.Sorted(storage_ifaces.StorageRangeOpts{}) {
+        if !asset.HasObject() {
+            stats.Assets++
+            continue
+        }
+
+        size, ok := objects[asset.Object]
+        if !ok {
+            fi, err := s.Parent.Vault().StatObject(asset.VaultAsset())
+            if err != nil {
+                hfsLog.Printf("%s: Stat object error: %s", s.Name(), err)
+                return stats, err
+            }
+
+            size = fi.Size()
+            objects[asset.Object] = size
+
+            stats.VaultSize += size
+        }
+
+        stats.Assets++
+        stats.Size += size
+    }
+
+    return stats, nil
+}
+
+
+//  Compute storage root digest. The digest is sha256 over the assets list
+// sorted by path, where every asset is represented by the line:
+// "<path>\0<etag>\0<mode in octal>\n". Symlinks and directories have
+// no vault objects, so "<kind>:<kind checksum>" is used instead.
+func (hfs *HashedFilesystemStorage) Digest(s *storage_ifaces.Storage) (string, error) {
+    digest := sha256.New()
+
+    for _, asset := range hfs.assets.Sorted(storage_ifaces.StorageRangeOpts{}) {
+        object := asset.ETag()
+        if !asset.HasObject() {
+            object = asset.Opts.Kind + ":" + asset.ETag()
+        }
+
+        if _, err := fmt.Fprintf(digest, "%s\x00%s\x00%o\n", asset.Path, object, asset.Opts.Mode); err != nil {
+            return "", err
+        }
+    }
+
+    return fmt.Sprintf("%x", digest.Sum(nil)), nil
+}
@@ -29,6 +29,14 @@ func (hfs *HashedFilesystemStorage) loadMetadata(s *storage_ifaces.Storage) {
     if err != nil {
         hfsLog.Panicf("Decoder error: %s", err)
     }
+
+    // Previous metadata versions have bare hex object ids.
+    hfs.assets.Range(func(path storage_ifaces.Path, asset *asset) bool {
+        if asset.HasObject() {
+            asset.Object = storage_ifaces.CanonicalVaultObject(asset.Object)
+        }
+        return true
+    })
 }
 
 
@@ -46,7 +54,7 @@ func (hfs *HashedFilesystemStorage) storeMetadata(s *storage_ifaces.Storage) {
     writeProc := func() error {
         defer f.Close()
 
-        err := json.NewEncoder(writer).Encode(hfs.assets)
+        err := json.NewEncoder(writer).Encode(&hfs.assets)
         if err != nil {
             hfsLog.Printf("%s: Encoder error: %s", s.Name(), err)
             return err
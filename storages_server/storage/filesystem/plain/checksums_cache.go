@@ -0,0 +1,68 @@
+package plain_filesystem_storage
+
+import (
+    "os"
+    "sync"
+
+    "../../ifaces"
+)
+
+
+//  Cached file checksum. The checksum is valid while the file version is
+// not changed. Modification time can't be used for the version, because
+// it is set by clients.
+type cachedChecksum struct {
+    version     fileVersion
+    value       string
+}
+
+
+type checksumsCache struct {
+    sync.Mutex
+
+    values map[storage_ifaces.Path]cachedChecksum
+}
+
+
+func makeChecksumsCache() checksumsCache {
+    return checksumsCache{
+        values: make(map[storage_ifaces.Path]cachedChecksum),
+    }
+}
+
+
+func (c *checksumsCache) Store(path storage_ifaces.Path, fi os.FileInfo, value string) {
+    c.Lock()
+    defer c.Unlock()
+
+    c.values[path] = cachedChecksum{
+        version:    makeFileVersion(fi),
+        value:      value,
+    }
+}
+
+
+func (c *checksumsCache) Load(path storage_ifaces.Path, fi os.FileInfo) (string, bool) {
+    c.Lock()
+    defer c.Unlock()
+
+    cached, ok := c.values[path]
+    if !ok {
+        return "", false
+    }
+
+    if cached.version != makeFileVersion(fi) {
+        delete(c.values, path)
+        return "", false
+    }
+
+    return cached.value, true
+}
+
+
+func (c *checksumsCache) Delete(path storage_ifaces.Path) {
+    c.Lock()
+    defer c.Unlock()
+
+    delete(c.values, path)
+}
@@ -0,0 +1,27 @@
+package plain_filesystem_storage
+
+import (
+    "os"
+    "syscall"
+)
+
+
+//  File version identity. Assets are written to the temp file and renamed,
+// so every write gets new inode. In place modifications change the status
+// change time, which can't be set by clients.
+type fileVersion struct {
+    size        int64
+    inode       uint64
+    ctime       syscall.Timespec
+}
+
+
+func makeFileVersion(fi os.FileInfo) fileVersion {
+    v := fileVersion{size: fi.Size()}
+
+    if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+        v.inode, v.ctime = st.Ino, st.Ctim
+    }
+
+    return v
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package plain_filesystem_storage
+
+import (
+    "os"
+    "time"
+)
+
+
+//  File version identity. Inode and status change time are not portable,
+// so the modification time is used on other platforms.
+type fileVersion struct {
+    size        int64
+    modTime     time.Time
+}
+
+
+func makeFileVersion(fi os.FileInfo) fileVersion {
+    return fileVersion{size: fi.Size(), modTime: fi.ModTime()}
+}
@@ -8,6 +8,9 @@ import (
     "fmt"
     "strings"
     "errors"
+    "sort"
+    "sync"
+    "crypto/sha256"
     "path/filepath"
 
     filesystem_utils ".."
@@ -16,7 +19,13 @@ import (
 
 
 type PlainFilesystemStorage struct {
+    // Serializes assets modifications
+    lock sync.Mutex
+
     root storage_ifaces.Path
+    meta storage_ifaces.Path
+
+    checksums checksumsCache
 }
 
 
@@ -24,7 +33,8 @@ func (pfs *PlainFilesystemStorage) Initialize(s *storage_ifaces.Storage) error {
 
     pfsLog.Printf("%s: Initialize storage.", s.Name())
 
-    pfs.root = filepath.Join(s.Parent.Opts().StoragesRoot, s.Id.Id)
+    pfs.root = filepath.Join(s.Parent.Opts().StoragesRoot, s.RootName())
+    pfs.meta = filepath.Join(s.Parent.Opts().StoragesRoot, s.RootName() + ".meta")
 
     pfsLog.Printf("%s: Ensure root: %s", s.Name(), pfs.root)
 
@@ -44,6 +54,10 @@ func (pfs *PlainFilesystemStorage) Destroy(s *storage_ifaces.Storage) error {
 
     pfsLog.Printf("%s: Remove root: %s", s.Name(), pfs.root)
 
+    if err := os.RemoveAll(pfs.meta); err != nil {
+        return err
+    }
+
     return os.RemoveAll(pfs.root)
 }
 
@@ -52,48 +66,77 @@ func (pfs *PlainFilesystemStorage) CreateAsset(s *storage_ifaces.Storage, path s
 
     pfsLog.Printf("%s: Create asset: %s opts: %s", s.Name(), path, r.Opts.String())
 
-    assetPath := filepath.Join(pfs.root, path)
-
-    if _, ok := os.Stat(assetPath); !os.IsNotExist(ok) {
-        err := fmt.Errorf("Asset: '%s' already exist!", path)
+    // Intermediate directories are not assets, so they can be marked as
+    // directory assets.
+    _, _, err := pfs.lstatAsset(s, path)
+    if !errors.Is(err, storage_ifaces.ASSET_NOT_EXIST) {
+        if err == nil {
+            err = fmt.Errorf("Asset: '%s' already exist! Err: %w", path, storage_ifaces.ASSET_EXIST)
+        }
 
         pfsLog.Printf("%s: Create asset error: %s", s.Name(), err)
 
         return err
     }
 
-    err := filesystem_utils.EnsureDir(filepath.Dir(assetPath), os.FileMode(s.Parent.Opts().DirsMode))
+    _, err = pfs.WriteAsset(s, path, r, storage_ifaces.StorageAssetCond{IfNoneMatch: "*"})
+    return err
+}
+
+
+func (pfs *PlainFilesystemStorage) WriteAsset(s *storage_ifaces.Storage, path storage_ifaces.Path, r *storage_ifaces.StorageAssetReader, cond storage_ifaces.StorageAssetCond) (string, error) {
+
+    pfsLog.Printf("%s: Write asset: %s opts: %s cond: %s", s.Name(), path, r.Opts.String(), cond.String())
+
+    assetPath, err := pfs.assetPath(path)
     if err != nil {
-        pfsLog.Printf("%s: Ensure asset parent dir error: %s", s.Name(), err)
-        return err
+        return "", err
+    }
+
+    if !r.Opts.IsFile() {
+        pfs.lock.Lock()
+        defer pfs.lock.Unlock()
+
+        err = pfs.checkCond(s, path, r.Opts, cond)
+        if err == nil {
+            err = pfs.writeSpecialAsset(s, path, assetPath, r.Opts)
+        }
+        if err != nil {
+            pfsLog.Printf("%s: Write asset error: %s", s.Name(), err)
+            return "", err
+        }
+
+        return r.Opts.KindChecksum(), nil
     }
 
     f, err := ioutil.TempFile(s.Parent.Opts().TempDir, s.Parent.Opts().TempPattern)
     if err != nil {
         pfsLog.Printf("%s: Can't create temp file! Error: %s", s.Name(), err)
-        return nil
+        return "", err
     }
 
+    checksum := sha256.New()
+
     writeProc := func() error {
         defer f.Close()
 
         writer := bufio.NewWriter(f)
 
-        _, err  = io.Copy(writer, r)
+        _, err  = io.Copy(io.MultiWriter(writer, checksum), r)
         if err != nil {
-            pfsLog.Printf("%s: Create asset copy error: %s", s.Name(), err)
+            pfsLog.Printf("%s: Write asset copy error: %s", s.Name(), err)
             return err
         }
 
         err = writer.Flush()
         if err != nil {
-            pfsLog.Printf("%s: Create asset flush error: %s", s.Name(), err)
+            pfsLog.Printf("%s: Write asset flush error: %s", s.Name(), err)
             return err
         }
 
-        err = f.Chmod(os.FileMode(r.Opts.Mode))
+        err = applyAttrs(s, f.Name(), r.Opts)
         if err != nil {
-            pfsLog.Printf("%s: Create asset chmod error: %s", s.Name(), err)
+            pfsLog.Printf("%s: Write asset attributes error: %s", s.Name(), err)
             return err
         }
 
@@ -107,14 +150,116 @@ func (pfs *PlainFilesystemStorage) CreateAsset(s *storage_ifaces.Storage, path s
             pfsLog.Panicf("%s: Remove temp file error: %s", s.Name(), rmErr)
         }
 
-        pfsLog.Panicf("%s: Write proc error: %s", s.Name(), err)
+        pfsLog.Printf("%s: Write proc error: %s", s.Name(), err)
+        return "", err
+    }
+
+    pfs.lock.Lock()
+    defer pfs.lock.Unlock()
+
+    err = pfs.checkCond(s, path, r.Opts, cond)
+    if err == nil {
+        err = filesystem_utils.EnsureDir(filepath.Dir(assetPath), os.FileMode(s.Parent.Opts().DirsMode))
+    }
+    if err != nil {
+        pfsLog.Printf("%s: Write asset error: %s", s.Name(), err)
+
+        pfsLog.Printf("%s: Remove temp file: %s", s.Name(), f.Name())
+        if rmErr := os.Remove(f.Name()); rmErr != nil {
+            pfsLog.Panicf("%s: Remove temp file error: %s", s.Name(), rmErr)
+        }
+
+        return "", err
     }
 
     if err := os.Rename(f.Name(), assetPath); err != nil {
         pfsLog.Panicf("%s: File rename error: %s", s.Name(), err)
     }
 
-    return err
+    if err := pfs.storeSidecar(s, path, makeSidecar(r.Opts)); err != nil {
+        pfsLog.Printf("%s: Store sidecar error: %s", s.Name(), err)
+        return "", err
+    }
+
+    etag := fmt.Sprintf("%x", checksum.Sum(nil))
+
+    if fi, err := os.Lstat(assetPath); err == nil {
+        pfs.checksums.Store(assetPath, fi, etag)
+    } else {
+        pfs.checksums.Delete(assetPath)
+    }
+
+    return etag, nil
+}
+
+
+// Check write preconditions against the existing asset file. Must be
+// called under pfs.lock.
+func (pfs *PlainFilesystemStorage) checkCond(s *storage_ifaces.Storage, path storage_ifaces.Path, opts storage_ifaces.StorageAssetOpts, cond storage_ifaces.StorageAssetCond) error {
+
+    assetPath := filepath.Join(pfs.root, path)
+
+    fi, err := os.Lstat(assetPath)
+    if err != nil && !os.IsNotExist(err) {
+        return err
+    }
+
+    exist := err == nil
+    if exist && fi.IsDir() {
+        // Only directory asset can replace the directory.
+        if opts.Kind != storage_ifaces.AssetKindDir {
+            return fmt.Errorf("Asset: '%s' is a directory!", path)
+        }
+        exist = pfs.isAsset(s, path, fi)
+    }
+
+    etag := ""
+    if exist && cond.NeedsETag() {
+        etag, err = pfs.etag(s, path, assetPath, fi)
+        if err != nil {
+            pfsLog.Printf("%s: Checksum error: %s", s.Name(), err)
+            return err
+        }
+    }
+
+    return cond.Check(path, exist, etag)
+}
+
+
+// Get cached file checksum or calculate and cache it.
+func (pfs *PlainFilesystemStorage) checksum(assetPath string, fi os.FileInfo) (string, error) {
+
+    if value, ok := pfs.checksums.Load(assetPath, fi); ok {
+        return value, nil
+    }
+
+    value, err := fileChecksum(assetPath)
+    if err != nil {
+        return "", err
+    }
+
+    pfs.checksums.Store(assetPath, fi, value)
+
+    return value, nil
+}
+
+
+// Calculate sha256 checksum of the file content.
+func fileChecksum(path string) (string, error) {
+
+    f, err := os.Open(path)
+    if err != nil {
+        return "", err
+    }
+    defer f.Close()
+
+    checksum := sha256.New()
+
+    if _, err := io.Copy(checksum, bufio.NewReader(f)); err != nil {
+        return "", err
+    }
+
+    return fmt.Sprintf("%x", checksum.Sum(nil)), nil
 }
 
 
@@ -122,18 +267,23 @@ func (pfs *PlainFilesystemStorage) ReadAsset(s *storage_ifaces.Storage, path sto
 
     pfsLog.Printf("%s: Read asset: %s", s.Name(), path)
 
-    assetPath := filepath.Join(pfs.root, path)
-
-    if _, ok := os.Stat(assetPath); os.IsNotExist(ok) {
-        return nil, fmt.Errorf("Attempt to read non existing asset: %s", path)
+    assetPath, fi, err := pfs.lstatAsset(s, path)
+    if err != nil {
+        return nil, fmt.Errorf("Attempt to read non existing asset: %s. Err: %w", path, err)
     }
 
-    fi, err := os.Lstat(assetPath)
+    opts, err := pfs.assetOpts(s, path, fi)
     if err != nil {
-        pfsLog.Printf("%s: Lstat error: %s", s.Name(), err)
         return nil, err
     }
 
+    // Symlinks and directories have no content.
+    if !fi.Mode().IsRegular() {
+        return &storage_ifaces.StorageAssetReader{
+            Reader: strings.NewReader(""),
+            Opts: opts}, nil
+    }
+
     f, err := os.Open(assetPath)
     if err != nil {
         pfsLog.Printf("%s: Open file error: %s", s.Name(), err)
@@ -141,22 +291,260 @@ func (pfs *PlainFilesystemStorage) ReadAsset(s *storage_ifaces.Storage, path sto
     }
 
     return &storage_ifaces.StorageAssetReader{
-        Reader: bufio.NewReader(f),
+        Reader: f,
         Closer: f,
-        Opts: storage_ifaces.StorageAssetOpts{Mode: int(fi.Mode().Perm())}}, nil
+        Opts: opts}, nil
+}
+
+
+func (pfs *PlainFilesystemStorage) StatAsset(s *storage_ifaces.Storage, path storage_ifaces.Path) (*storage_ifaces.StorageAssetInfo, error) {
+
+    pfsLog.Printf("%s: Stat asset: %s", s.Name(), path)
+
+    assetPath, fi, err := pfs.lstatAsset(s, path)
+    if err != nil {
+        return nil, fmt.Errorf("Attempt to stat non existing asset: %s. Err: %w", path, err)
+    }
+
+    checksum, err := pfs.etag(s, path, assetPath, fi)
+    if err != nil {
+        pfsLog.Printf("%s: Checksum error: %s", s.Name(), err)
+        return nil, err
+    }
+
+    opts, modified, err := pfs.assetMeta(s, path, fi)
+    if err != nil {
+        return nil, err
+    }
+
+    // The creation time is not tracked by filesystem, so the
+    // modification time is used instead.
+    info := &storage_ifaces.StorageAssetInfo{
+        StorageAssetOpts:   opts,
+        Path:               path,
+        ETag:               checksum,
+        Created:            modified,
+        Modified:           modified,
+    }
+
+    // File ETag is the content sha256.
+    if fi.Mode().IsRegular() {
+        info.Size = fi.Size()
+        info.Digests = storage_ifaces.StorageAssetDigests{storage_ifaces.DigestSha256: checksum}
+    }
+
+    return info, nil
+}
+
+
+// Get asset size without the content checksum.
+func (pfs *PlainFilesystemStorage) AssetSize(s *storage_ifaces.Storage, path storage_ifaces.Path) (int64, error) {
+
+    _, fi, err := pfs.lstatAsset(s, path)
+    if err != nil {
+        return 0, err
+    }
+
+    if !fi.Mode().IsRegular() {
+        return 0, nil
+    }
+
+    return fi.Size(), nil
+}
+
+
+func (pfs *PlainFilesystemStorage) DeleteAsset(s *storage_ifaces.Storage, path storage_ifaces.Path) error {
+
+    pfsLog.Printf("%s: Delete asset: %s", s.Name(), path)
+
+    pfs.lock.Lock()
+    defer pfs.lock.Unlock()
+
+    assetPath, fi, err := pfs.lstatAsset(s, path)
+    if err != nil {
+        return fmt.Errorf("Attempt to delete non existing asset: %s. Err: %w", path, err)
+    }
+
+    // Directory asset is removed with its sidecar if it is empty.
+    if !fi.IsDir() {
+        if err := os.Remove(assetPath); err != nil {
+            pfsLog.Printf("%s: Remove file error: %s", s.Name(), err)
+            return err
+        }
+
+        pfs.checksums.Delete(assetPath)
+
+        assetPath = filepath.Dir(assetPath)
+    }
+
+    if err := pfs.removeSidecar(s, path); err != nil {
+        return err
+    }
+
+    pfs.removeEmptyDirs(s, assetPath)
+
+    return nil
+}
+
+
+func (pfs *PlainFilesystemStorage) MoveAsset(s *storage_ifaces.Storage, from storage_ifaces.Path, to storage_ifaces.Path) error {
+
+    pfsLog.Printf("%s: Move asset: %s to: %s", s.Name(), from, to)
+
+    pfs.lock.Lock()
+    defer pfs.lock.Unlock()
+
+    fromPath, fi, err := pfs.lstatAsset(s, from)
+    if err != nil {
+        return fmt.Errorf("Attempt to move non existing asset: %s. Err: %w", from, err)
+    }
+
+    toPath, err := pfs.assetPath(to)
+    if err != nil {
+        return err
+    }
+
+    if _, ok := os.Lstat(toPath); !os.IsNotExist(ok) {
+        return fmt.Errorf("Attempt to move asset: %s to existing asset: %s. Err: %w", from, to, storage_ifaces.ASSET_EXIST)
+    }
+
+    // Directory content is not the part of directory asset.
+    if fi.IsDir() {
+        files, err := ioutil.ReadDir(fromPath)
+        if err != nil {
+            return err
+        }
+        if len(files) > 0 {
+            return fmt.Errorf("Attempt to move non empty directory asset: %s!", from)
+        }
+    }
+
+    err = filesystem_utils.EnsureDir(filepath.Dir(toPath), os.FileMode(s.Parent.Opts().DirsMode))
+    if err != nil {
+        pfsLog.Printf("%s: Ensure asset parent dir error: %s", s.Name(), err)
+        return err
+    }
+
+    if err := os.Rename(fromPath, toPath); err != nil {
+        pfsLog.Printf("%s: File rename error: %s", s.Name(), err)
+        return err
+    }
+
+    pfs.checksums.Delete(fromPath)
+
+    if err := pfs.moveSidecar(s, from, to); err != nil {
+        return err
+    }
+
+    pfs.removeEmptyDirs(s, filepath.Dir(fromPath))
+
+    return nil
 }
 
 
 func (pfs *PlainFilesystemStorage) Range(s *storage_ifaces.Storage, callback storage_ifaces.StorageOpsCallback) {
     filepath.Walk(pfs.root, func(path string, info os.FileInfo, err error) error {
-        if info.IsDir() {
+        if err != nil || path == pfs.root {
+            return nil
+        }
+
+        assetPath := filepath.ToSlash(strings.TrimPrefix(path, pfs.root + string(filepath.Separator)))
+
+        if !pfs.isAsset(s, assetPath, info) {
             return nil
         }
 
-        assetPath := strings.TrimPrefix(path, pfs.root + string(filepath.Separator))
-        if !callback(assetPath, storage_ifaces.StorageAssetOpts{Mode: int(info.Mode().Perm())}) {
+        opts, err := pfs.assetOpts(s, assetPath, info)
+        if err != nil {
+            pfsLog.Printf("%s: Asset options error: %s", s.Name(), err)
+        }
+
+        if !callback(assetPath, opts) {
             return errors.New("Stop enumeration!")
         }
         return nil
     })
 }
+
+
+func (pfs *PlainFilesystemStorage) RangeFrom(s *storage_ifaces.Storage, opts storage_ifaces.StorageRangeOpts, callback storage_ifaces.StorageOpsCallback) {
+
+    type file struct {
+        path storage_ifaces.Path
+        info os.FileInfo
+    }
+
+    files := make([]file, 0, 100)
+
+    filepath.Walk(pfs.root, func(path string, info os.FileInfo, err error) error {
+        if err != nil {
+            pfsLog.Printf("%s: Walk error: %s", s.Name(), err)
+            return nil
+        }
+
+        if path == pfs.root {
+            return nil
+        }
+
+        assetPath := filepath.ToSlash(strings.TrimPrefix(path, pfs.root + string(filepath.Separator)))
+
+        if info.IsDir() {
+            // Skip directories what can't contain assets with the prefix.
+            dirPath := assetPath + "/"
+            if !strings.HasPrefix(dirPath, opts.Prefix) && !strings.HasPrefix(opts.Prefix, dirPath) {
+                return filepath.SkipDir
+            }
+        }
+
+        if opts.Contains(assetPath) && pfs.isAsset(s, assetPath, info) {
+            files = append(files, file{path: assetPath, info: info})
+        }
+
+        return nil
+    })
+
+    sort.Slice(files, func(i, j int) bool {
+        return files[i].path < files[j].path
+    })
+
+    for _, f := range files {
+        assetOpts, err := pfs.assetOpts(s, f.path, f.info)
+        if err != nil {
+            pfsLog.Printf("%s: Asset options error: %s", s.Name(), err)
+        }
+
+        if !callback(f.path, assetOpts) {
+            break
+        }
+    }
+}
+
+
+func (pfs *PlainFilesystemStorage) Stats(s *storage_ifaces.Storage) (storage_ifaces.StorageStats, error) {
+    stats := storage_ifaces.StorageStats{}
+
+    err := filepath.Walk(pfs.root, func(path string, info os.FileInfo, err error) error {
+        if err != nil {
+            return err
+        }
+
+        if path == pfs.root {
+            return nil
+        }
+
+        assetPath := filepath.ToSlash(strings.TrimPrefix(path, pfs.root + string(filepath.Separator)))
+
+        if !pfs.isAsset(s, assetPath, info) {
+            return nil
+        }
+
+        stats.Assets++
+        if info.Mode().IsRegular() {
+            stats.Size += info.Size()
+        }
+
+        return nil
+    })
+
+    return stats, err
+}
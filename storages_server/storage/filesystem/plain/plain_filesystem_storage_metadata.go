@@ -0,0 +1,280 @@
+package plain_filesystem_storage
+
+import (
+    "os"
+    "errors"
+    "io/ioutil"
+    "bufio"
+    "time"
+    "encoding/json"
+    "path/filepath"
+
+    filesystem_utils ".."
+    "../../ifaces"
+)
+
+
+// Asset sidecar metadata. Stored in the separate directory tree, so the
+// storage root contains only assets.
+type sidecar struct {
+    Properties storage_ifaces.StorageAssetProperties `json:"properties,omitempty"`
+
+    // Directories are assets only if they are marked by the kind.
+    Kind string `json:"kind,omitempty"`
+
+    // Origin attributes as they were provided by client. Applied to the
+    // asset file also, but file system may not keep them (e.g. directory
+    // mtime is changed by the content modifications).
+    Mtime *time.Time `json:"mtime,omitempty"`
+    Uid   *int       `json:"uid,omitempty"`
+    Gid   *int       `json:"gid,omitempty"`
+
+    // Server side modification time. File mtime is set by the client, so
+    // it can't be reported as the asset modification time.
+    Modified *time.Time `json:"modified,omitempty"`
+}
+
+
+func makeSidecar(opts storage_ifaces.StorageAssetOpts) *sidecar {
+    modified := time.Now().UTC()
+
+    sc := &sidecar{
+        Properties: opts.Properties,
+        Mtime:      opts.Mtime,
+        Uid:        opts.Uid,
+        Gid:        opts.Gid,
+        Modified:   &modified,
+    }
+
+    if opts.Kind == storage_ifaces.AssetKindDir {
+        sc.Kind = opts.Kind
+    }
+
+    return sc
+}
+
+
+//  Sidecar is empty if it has no asset attributes. The modification time
+// is not kept without attributes: the file mtime is not changed by the
+// client in this case.
+func (sc *sidecar) Empty() bool {
+    return len(sc.Properties) == 0 && len(sc.Kind) == 0 && sc.Mtime == nil && sc.Uid == nil && sc.Gid == nil
+}
+
+
+func (pfs *PlainFilesystemStorage) sidecarPath(path storage_ifaces.Path) string {
+    return filepath.Join(pfs.meta, path + ".json")
+}
+
+
+// Load asset sidecar. Returns empty sidecar if there is no file.
+func (pfs *PlainFilesystemStorage) loadSidecar(s *storage_ifaces.Storage, path storage_ifaces.Path) (*sidecar, error) {
+
+    sc := &sidecar{}
+
+    f, err := os.Open(pfs.sidecarPath(path))
+    if os.IsNotExist(err) {
+        return sc, nil
+    }
+    if err != nil {
+        pfsLog.Printf("%s: Sidecar open error: %s", s.Name(), err)
+        return nil, err
+    }
+    defer f.Close()
+
+    if err := json.NewDecoder(f).Decode(sc); err != nil {
+        pfsLog.Printf("%s: Sidecar decode error: %s", s.Name(), err)
+        return nil, err
+    }
+
+    return sc, nil
+}
+
+
+// Store asset sidecar. Empty sidecar is removed.
+func (pfs *PlainFilesystemStorage) storeSidecar(s *storage_ifaces.Storage, path storage_ifaces.Path, sc *sidecar) error {
+
+    if sc.Empty() {
+        return pfs.removeSidecar(s, path)
+    }
+
+    sidecarPath := pfs.sidecarPath(path)
+
+    pfsLog.Printf("%s: Store sidecar to: %s", s.Name(), sidecarPath)
+
+    err := filesystem_utils.EnsureDir(filepath.Dir(sidecarPath), os.FileMode(s.Parent.Opts().DirsMode))
+    if err != nil {
+        pfsLog.Printf("%s: Ensure sidecar parent dir error: %s", s.Name(), err)
+        return err
+    }
+
+    f, err := ioutil.TempFile(s.Parent.Opts().TempDir, s.Parent.Opts().TempPattern)
+    if err != nil {
+        pfsLog.Printf("%s: Can't create temp file! Error: %s", s.Name(), err)
+        return err
+    }
+
+    writer := bufio.NewWriter(f)
+
+    writeProc := func() error {
+        defer f.Close()
+
+        err := json.NewEncoder(writer).Encode(sc)
+        if err != nil {
+            pfsLog.Printf("%s: Encoder error: %s", s.Name(), err)
+            return err
+        }
+
+        err = writer.Flush()
+        if err != nil {
+            pfsLog.Printf("%s: Writer flush error: %s", s.Name(), err)
+            return err
+        }
+
+        err = f.Chmod(os.FileMode(s.Parent.Opts().VaultMode))
+        if err != nil {
+            pfsLog.Printf("%s: File chmod error: %s", s.Name(), err)
+            return err
+        }
+
+        return nil
+    }
+
+    err = writeProc()
+    if err != nil {
+        pfsLog.Printf("%s: Remove temp file: %s", s.Name(), f.Name())
+        if rmErr := os.Remove(f.Name()); rmErr != nil {
+            pfsLog.Panicf("%s: Remove temp file error: %s", s.Name(), rmErr)
+        }
+
+        return err
+    }
+
+    return os.Rename(f.Name(), sidecarPath)
+}
+
+
+func (pfs *PlainFilesystemStorage) removeSidecar(s *storage_ifaces.Storage, path storage_ifaces.Path) error {
+
+    sidecarPath := pfs.sidecarPath(path)
+
+    if err := os.Remove(sidecarPath); err != nil && !os.IsNotExist(err) {
+        pfsLog.Printf("%s: Remove sidecar error: %s", s.Name(), err)
+        return err
+    }
+
+    filesystem_utils.RemoveEmptyDirs(pfs.meta, filepath.Dir(sidecarPath))
+
+    return nil
+}
+
+
+func (pfs *PlainFilesystemStorage) moveSidecar(s *storage_ifaces.Storage, from storage_ifaces.Path, to storage_ifaces.Path) error {
+
+    fromPath    := pfs.sidecarPath(from)
+    toPath      := pfs.sidecarPath(to)
+
+    if _, err := os.Stat(fromPath); os.IsNotExist(err) {
+        return nil
+    }
+
+    err := filesystem_utils.EnsureDir(filepath.Dir(toPath), os.FileMode(s.Parent.Opts().DirsMode))
+    if err != nil {
+        pfsLog.Printf("%s: Ensure sidecar parent dir error: %s", s.Name(), err)
+        return err
+    }
+
+    if err := os.Rename(fromPath, toPath); err != nil {
+        pfsLog.Printf("%s: Sidecar rename error: %s", s.Name(), err)
+        return err
+    }
+
+    filesystem_utils.RemoveEmptyDirs(pfs.meta, filepath.Dir(fromPath))
+
+    return nil
+}
+
+
+// Make asset options from file info and sidecar.
+func (pfs *PlainFilesystemStorage) assetOpts(s *storage_ifaces.Storage, path storage_ifaces.Path, fi os.FileInfo) (storage_ifaces.StorageAssetOpts, error) {
+
+    opts, _, err := pfs.assetMeta(s, path, fi)
+    return opts, err
+}
+
+
+//  Make asset options and get modification time from file info and sidecar.
+// The file mtime is used if sidecar has no modification time.
+func (pfs *PlainFilesystemStorage) assetMeta(s *storage_ifaces.Storage, path storage_ifaces.Path, fi os.FileInfo) (storage_ifaces.StorageAssetOpts, time.Time, error) {
+
+    opts := storage_ifaces.StorageAssetOpts{Mode: storage_ifaces.AssetMode(fi.Mode())}
+    modified := fi.ModTime().UTC()
+
+    sc, err := pfs.loadSidecar(s, path)
+    if err != nil {
+        return opts, modified, err
+    }
+
+    if sc.Modified != nil {
+        modified = sc.Modified.UTC()
+    }
+
+    opts.Properties = sc.Properties
+    opts.Mtime, opts.Uid, opts.Gid = sc.Mtime, sc.Uid, sc.Gid
+
+    switch {
+    case fi.Mode() & os.ModeSymlink != 0:
+        target, err := os.Readlink(filepath.Join(pfs.root, path))
+        if err != nil {
+            return opts, modified, err
+        }
+        opts.Kind, opts.Target, opts.Mode = storage_ifaces.AssetKindSymlink, target, 0o777
+    case fi.IsDir():
+        opts.Kind = storage_ifaces.AssetKindDir
+    }
+
+    return opts, modified, nil
+}
+
+
+//  Apply asset mode, ownership and mtime to the file. Ownership is changed
+// first, because chown clears setuid and setgid bits. Symlinks get only
+// ownership. Chown is best effort: unprivileged servers can't give files
+// away, the ownership is kept by the sidecar anyway.
+func applyAttrs(s *storage_ifaces.Storage, filePath string, opts storage_ifaces.StorageAssetOpts) error {
+
+    if opts.Uid != nil || opts.Gid != nil {
+        uid, gid := -1, -1
+        if opts.Uid != nil {
+            uid = *opts.Uid
+        }
+        if opts.Gid != nil {
+            gid = *opts.Gid
+        }
+
+        if err := os.Lchown(filePath, uid, gid); err != nil {
+            pfsLog.Printf("%s: Chown error: %s", s.Name(), err)
+            if !errors.Is(err, os.ErrPermission) {
+                return err
+            }
+        }
+    }
+
+    if opts.Kind == storage_ifaces.AssetKindSymlink {
+        return nil
+    }
+
+    if err := os.Chmod(filePath, opts.FileMode()); err != nil {
+        pfsLog.Printf("%s: Chmod error: %s", s.Name(), err)
+        return err
+    }
+
+    if opts.Mtime != nil {
+        if err := os.Chtimes(filePath, *opts.Mtime, *opts.Mtime); err != nil {
+            pfsLog.Printf("%s: Chtimes error: %s", s.Name(), err)
+            return err
+        }
+    }
+
+    return nil
+}
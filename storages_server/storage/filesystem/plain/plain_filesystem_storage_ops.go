@@ -5,5 +5,7 @@ import (
 )
 
 func NewStorageOps(opts storage_ifaces.StoragesManagerOpts) storage_ifaces.StorageOps {
-    return new(PlainFilesystemStorage)
+    return &PlainFilesystemStorage{
+        checksums: makeChecksumsCache(),
+    }
 }
@@ -0,0 +1,157 @@
+package plain_filesystem_storage
+
+import (
+    "os"
+    "fmt"
+    "strings"
+    "path/filepath"
+
+    filesystem_utils ".."
+    "../../ifaces"
+)
+
+
+//  Get asset file path. Returns error wrapping BAD_PATH if any parent
+// directory of the asset is a symlink, so symlink assets can't be used
+// to access files outside of the storage root.
+func (pfs *PlainFilesystemStorage) assetPath(path storage_ifaces.Path) (string, error) {
+
+    elements := strings.Split(path, "/")
+
+    dir := pfs.root
+    for _, name := range elements[:len(elements) - 1] {
+        dir = filepath.Join(dir, name)
+
+        fi, err := os.Lstat(dir)
+        if os.IsNotExist(err) {
+            break
+        }
+        if err != nil {
+            return "", err
+        }
+
+        if fi.Mode() & os.ModeSymlink != 0 {
+            return "", fmt.Errorf("Asset: '%s' parent is a symlink! Err: %w", path, storage_ifaces.BAD_PATH)
+        }
+    }
+
+    return filepath.Join(pfs.root, path), nil
+}
+
+
+//  Check if the file is an asset. Regular files and symlinks are assets,
+// directories are assets only if they are marked by sidecar.
+func (pfs *PlainFilesystemStorage) isAsset(s *storage_ifaces.Storage, path storage_ifaces.Path, fi os.FileInfo) bool {
+
+    switch {
+    case fi.Mode().IsRegular(), fi.Mode() & os.ModeSymlink != 0:
+        return true
+    case fi.IsDir():
+        sc, err := pfs.loadSidecar(s, path)
+        return err == nil && sc.Kind == storage_ifaces.AssetKindDir
+    }
+
+    return false
+}
+
+
+// Get existing asset file path and info. Returns error wrapping
+// ASSET_NOT_EXIST if there is no such asset.
+func (pfs *PlainFilesystemStorage) lstatAsset(s *storage_ifaces.Storage, path storage_ifaces.Path) (string, os.FileInfo, error) {
+
+    assetPath, err := pfs.assetPath(path)
+    if err != nil {
+        return "", nil, err
+    }
+
+    fi, err := os.Lstat(assetPath)
+    if os.IsNotExist(err) || (err == nil && !pfs.isAsset(s, path, fi)) {
+        return "", nil, fmt.Errorf("Asset: %s not exist. Err: %w", path, storage_ifaces.ASSET_NOT_EXIST)
+    }
+    if err != nil {
+        pfsLog.Printf("%s: Lstat error: %s", s.Name(), err)
+        return "", nil, err
+    }
+
+    return assetPath, fi, nil
+}
+
+
+// Get asset ETag. Content checksum for files, kind checksum for others.
+func (pfs *PlainFilesystemStorage) etag(s *storage_ifaces.Storage, path storage_ifaces.Path, assetPath string, fi os.FileInfo) (string, error) {
+
+    if fi.Mode().IsRegular() {
+        return pfs.checksum(assetPath, fi)
+    }
+
+    opts, err := pfs.assetOpts(s, path, fi)
+    if err != nil {
+        return "", err
+    }
+
+    return opts.KindChecksum(), nil
+}
+
+
+// Create symlink or directory asset. Must be called under pfs.lock.
+func (pfs *PlainFilesystemStorage) writeSpecialAsset(s *storage_ifaces.Storage, path storage_ifaces.Path, assetPath string, opts storage_ifaces.StorageAssetOpts) error {
+
+    err := filesystem_utils.EnsureDir(filepath.Dir(assetPath), os.FileMode(s.Parent.Opts().DirsMode))
+    if err != nil {
+        pfsLog.Printf("%s: Ensure asset parent dir error: %s", s.Name(), err)
+        return err
+    }
+
+    fi, err := os.Lstat(assetPath)
+    if err != nil && !os.IsNotExist(err) {
+        pfsLog.Printf("%s: Lstat error: %s", s.Name(), err)
+        return err
+    }
+
+    // Replace existing file or symlink
+    if err == nil && !fi.IsDir() {
+        if err := os.Remove(assetPath); err != nil {
+            pfsLog.Printf("%s: Remove file error: %s", s.Name(), err)
+            return err
+        }
+        pfs.checksums.Delete(assetPath)
+    }
+
+    switch opts.Kind {
+    case storage_ifaces.AssetKindSymlink:
+        err = os.Symlink(opts.Target, assetPath)
+    case storage_ifaces.AssetKindDir:
+        err = filesystem_utils.EnsureDir(assetPath, opts.FileMode().Perm())
+    }
+    if err == nil {
+        err = applyAttrs(s, assetPath, opts)
+    }
+    if err != nil {
+        pfsLog.Printf("%s: Create %s error: %s", s.Name(), opts.Kind, err)
+        return err
+    }
+
+    return pfs.storeSidecar(s, path, makeSidecar(opts))
+}
+
+
+//  Remove empty directories starting from dir and going up to the root
+// (exclusive). Stops on first non empty directory or directory asset.
+func (pfs *PlainFilesystemStorage) removeEmptyDirs(s *storage_ifaces.Storage, dir string) {
+
+    root := filepath.Clean(pfs.root)
+
+    for dir = filepath.Clean(dir); dir != root && len(dir) > len(root); dir = filepath.Dir(dir) {
+        path := filepath.ToSlash(strings.TrimPrefix(dir, root + string(filepath.Separator)))
+
+        if sc, err := pfs.loadSidecar(s, path); err != nil || sc.Kind == storage_ifaces.AssetKindDir {
+            break
+        }
+
+        if err := os.Remove(dir); err != nil {
+            break
+        }
+
+        pfsLog.Printf("%s: Removed empty directory: %s", s.Name(), dir)
+    }
+}
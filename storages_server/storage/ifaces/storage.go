@@ -1,29 +1,292 @@
 package storage_ifaces
 
-import "fmt"
+import (
+    "encoding/json"
+    "fmt"
+    "sync"
+    "time"
+)
 
 type Storage struct {
     Id      StorageId
     Type    StorageType
+    Created time.Time
+
+    // Unique human readable name and movable aliases.
+    UniqueName  string      `json:"Name,omitempty"`
+    Aliases     []string    `json:",omitempty"`
+
+    // Storage expiration time. Nil means that the storage never expires.
+    Expires     *time.Time  `json:",omitempty"`
+
+    // Storage quota. Nil means unlimited storage.
+    Quota       *StorageQuota   `json:",omitempty"`
+
+    // Storage seal. Sealed storage is read-only.
+    Sealed      *StorageSeal    `json:",omitempty"`
+
+    //  Storage root name under the storages root directory. Empty means
+    // that storage id is used.
+    Root        string          `json:",omitempty"`
 
     Parent  StoragesManager `json:"-"`
     Ops     StorageOps      `json:"-"`
+
+    usage       quotaUsage
+
+    //  Guards the seal and the storage implementation (Ops, Type and
+    // Root), which is replaced by the conversion.
+    sealLock    sync.RWMutex
+
+    // Guards aliases, expiration and quota, see storage_meta.go.
+    metaLock    sync.RWMutex
 }
 
 
-func (s Storage) Name() string {
+func (s *Storage) Name() string {
     return fmt.Sprintf("%s(%d)", s.Id.String(), int(s.Type))
 }
 
+// Get storage root name under the storages root directory.
+func (s *Storage) RootName() string {
+    if len(s.Root) > 0 {
+        return s.Root
+    }
+    return s.Id.Id
+}
+
+// Check if storage is expired at the given time.
+func (s *Storage) Expired(now time.Time) bool {
+    expires := s.GetExpires()
+    return expires != nil && !now.Before(*expires)
+}
+
+// Make asset reader with normalized asset options.
+func normalizeAssetReader(r *StorageAssetReader) (*StorageAssetReader, error) {
+    opts, err := r.Opts.Normalize()
+    if err != nil {
+        return nil, err
+    }
+
+    return &StorageAssetReader{Reader: r.Reader, Closer: r.Closer, Opts: opts}, nil
+}
+
 func (s *Storage) CreateAsset(path Path, r *StorageAssetReader) error {
-    return s.Ops.CreateAsset(s, path, r)
+    path, err := CleanPath(path)
+    if err != nil {
+        return err
+    }
+
+    r, err = normalizeAssetReader(r)
+    if err != nil {
+        return err
+    }
+
+    unlock, err := s.lockModify()
+    if err != nil {
+        return err
+    }
+    defer unlock()
+
+    r, release, err := s.reserveQuota(path, r)
+    if err != nil {
+        return err
+    }
+
+    err = s.Ops.CreateAsset(s, path, r)
+    release(err == nil)
+
+    return err
+}
+
+func (s *Storage) WriteAsset(path Path, r *StorageAssetReader, cond StorageAssetCond) (string, error) {
+    path, err := CleanPath(path)
+    if err != nil {
+        return "", err
+    }
+
+    r, err = normalizeAssetReader(r)
+    if err != nil {
+        return "", err
+    }
+
+    unlock, err := s.lockModify()
+    if err != nil {
+        return "", err
+    }
+    defer unlock()
+
+    r, release, err := s.reserveQuota(path, r)
+    if err != nil {
+        return "", err
+    }
+
+    etag, err := s.Ops.WriteAsset(s, path, r, cond)
+    release(err == nil)
+
+    return etag, err
 }
 
 func (s *Storage) ReadAsset(path Path) (*StorageAssetReader, error) {
+    path, err := CleanPath(path)
+    if err != nil {
+        return nil, err
+    }
+
+    unlock := s.lockRead()
+    defer unlock()
+
     r, err := s.Ops.ReadAsset(s, path)
     return r, err
 }
 
+// Attempts to open the asset matching its info before giving up.
+const openAssetAttempts = 3
+
+//  Get asset reader together with the info describing the same asset
+// version. The asset can be replaced between the stat and the read, so
+// the info is checked again after the reader is opened.
+func (s *Storage) OpenAsset(path Path) (*StorageAssetInfo, *StorageAssetReader, error) {
+    info, err := s.StatAsset(path)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    for i := 0; i < openAssetAttempts; i++ {
+        r, err := s.ReadAsset(path)
+        if err != nil {
+            return nil, nil, err
+        }
+
+        opened, err := s.StatAsset(path)
+        if err != nil {
+            r.Close()
+            return nil, nil, err
+        }
+
+        if opened.ETag == info.ETag && opened.Modified.Equal(info.Modified) {
+            return opened, r, nil
+        }
+
+        r.Close()
+        info = opened
+    }
+
+    return nil, nil, fmt.Errorf("Asset: %s is modified while opening", path)
+}
+
+func (s *Storage) StatAsset(path Path) (*StorageAssetInfo, error) {
+    path, err := CleanPath(path)
+    if err != nil {
+        return nil, err
+    }
+
+    unlock := s.lockRead()
+    defer unlock()
+
+    return s.Ops.StatAsset(s, path)
+}
+
+func (s *Storage) DeleteAsset(path Path) error {
+    path, err := CleanPath(path)
+    if err != nil {
+        return err
+    }
+
+    unlock, err := s.lockModify()
+    if err != nil {
+        return err
+    }
+    defer unlock()
+
+    release := s.releaseQuota(path)
+
+    err = s.Ops.DeleteAsset(s, path)
+    release(err == nil)
+
+    return err
+}
+
+func (s *Storage) MoveAsset(from Path, to Path) error {
+    from, err := CleanPath(from)
+    if err != nil {
+        return err
+    }
+
+    to, err = CleanPath(to)
+    if err != nil {
+        return err
+    }
+
+    unlock, err := s.lockModify()
+    if err != nil {
+        return err
+    }
+    defer unlock()
+
+    return s.Ops.MoveAsset(s, from, to)
+}
+
+func (s *Storage) Stats() (StorageStats, error) {
+    unlock := s.lockRead()
+    defer unlock()
+
+    return s.Ops.Stats(s)
+}
+
+func (s *Storage) Summary() (StorageSummary, error) {
+    unlock := s.lockRead()
+    defer unlock()
+
+    stats, err := s.Ops.Stats(s)
+    return StorageSummary{
+        StorageId:      s.Id,
+        Name:           s.UniqueName,
+        Aliases:        s.GetAliases(),
+        Type:           StorageType_toString(s.Type),
+        Created:        s.Created,
+        Expires:        s.GetExpires(),
+        Quota:          s.GetQuota(),
+        Sealed:         s.Sealed,
+        StorageStats:   stats,
+    }, err
+}
+
+//  Enumerate assets. The storage can't be converted nor sealed until the
+// enumeration is finished, so the callback must not call the storage
+// methods.
 func (s *Storage) Range(callback StorageOpsCallback) {
+    unlock := s.lockRead()
+    defer unlock()
+
     s.Ops.Range(s, callback)
 }
+
+// Enumerate assets in lexical path order, see Range.
+func (s *Storage) RangeFrom(opts StorageRangeOpts, callback StorageOpsCallback) {
+    unlock := s.lockRead()
+    defer unlock()
+
+    s.Ops.RangeFrom(s, opts, callback)
+}
+
+// Get storage implementation. It is replaced by the conversion.
+func (s *Storage) Implementation() StorageOps {
+    unlock := s.lockRead()
+    defer unlock()
+
+    return s.Ops
+}
+
+//  Encode storage under the locks, the storage implementation, seal and
+// metadata are changed concurrently.
+func (s *Storage) MarshalJSON() ([]byte, error) {
+    unlock := s.lockRead()
+    defer unlock()
+
+    s.metaLock.RLock()
+    defer s.metaLock.RUnlock()
+
+    type storage Storage
+    return json.Marshal((*storage)(s))
+}
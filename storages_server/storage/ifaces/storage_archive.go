@@ -0,0 +1,307 @@
+package storage_ifaces
+
+import (
+    "archive/tar"
+    "archive/zip"
+    "compress/gzip"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "sort"
+    "strings"
+    "time"
+)
+
+
+// Supported archive formats.
+const (
+    ArchiveTar      = "tar"
+    ArchiveTarGz    = "tar.gz"
+    ArchiveZip      = "zip"
+)
+
+
+// Zip archive entries compression methods.
+const (
+    ArchiveDeflate  = "deflate"
+    ArchiveStore    = "store"
+)
+
+
+// PAX records prefix for the asset properties.
+const archivePropertyPrefix = "STORAGES.property."
+
+
+// Archive options.
+type StorageArchiveOpts struct {
+    // Archive format. Archives are written as ArchiveTar and imported
+    // according to the content if the format is empty.
+    Format  string
+
+    // Only assets with the prefix are archived. Imported assets are
+    // created under the prefix.
+    Prefix  Path
+
+    // Zip entries compression method, ArchiveDeflate if empty.
+    Compression string
+
+    // If set, used as the modification time of all archive entries,
+    // so the archive depends only on the assets content and attributes.
+    Mtime   *time.Time
+}
+
+
+// Check archive options. Returns error wrapping BAD_ARCHIVE if the format
+// or compression is unknown.
+func (o StorageArchiveOpts) Validate() error {
+    switch o.Format {
+    case "", ArchiveTar, ArchiveTarGz, ArchiveZip:
+    default:
+        return fmt.Errorf("Unknown archive format: '%s'! Err: %w", o.Format, BAD_ARCHIVE)
+    }
+
+    switch o.Compression {
+    case "":
+    case ArchiveDeflate, ArchiveStore:
+        if o.Format != ArchiveZip {
+            return fmt.Errorf("Compression: '%s' is supported only for zip archives! Err: %w", o.Compression, BAD_ARCHIVE)
+        }
+    default:
+        return fmt.Errorf("Unknown compression: '%s'! Err: %w", o.Compression, BAD_ARCHIVE)
+    }
+
+    return nil
+}
+
+
+// Get paths of the assets to be archived.
+func (s *Storage) archivePaths(prefix Path) []Path {
+    paths := make([]Path, 0, 100)
+
+    s.RangeFrom(StorageRangeOpts{Prefix: prefix}, func(path Path, opts StorageAssetOpts) bool {
+        paths = append(paths, path)
+        return true
+    })
+
+    // Keep entries order stable regardless of the backend.
+    sort.Strings(paths)
+
+    return paths
+}
+
+
+// Get archive entry modification time of the asset.
+func archiveMtime(info *StorageAssetInfo, opts StorageArchiveOpts) time.Time {
+    switch {
+    case opts.Mtime != nil:
+        return opts.Mtime.UTC()
+    case info.Mtime != nil:
+        return info.Mtime.UTC()
+    }
+    return info.Modified.UTC()
+}
+
+
+// Make tar header for the asset.
+func tarHeader(info *StorageAssetInfo, opts StorageArchiveOpts) *tar.Header {
+    hdr := &tar.Header{
+        Name:       info.Path,
+        Mode:       int64(info.Mode),
+        ModTime:    archiveMtime(info, opts),
+        Typeflag:   tar.TypeReg,
+        Size:       info.Size,
+    }
+
+    switch info.Kind {
+    case AssetKindSymlink:
+        hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, info.Target, 0
+    case AssetKindDir:
+        hdr.Typeflag, hdr.Name, hdr.Size = tar.TypeDir, info.Path + "/", 0
+    }
+
+    if info.Uid != nil {
+        hdr.Uid = *info.Uid
+    }
+    if info.Gid != nil {
+        hdr.Gid = *info.Gid
+    }
+
+    if len(info.Properties) > 0 {
+        hdr.Format = tar.FormatPAX
+        hdr.PAXRecords = make(map[string]string)
+        for k, v := range info.Properties {
+            hdr.PAXRecords[archivePropertyPrefix + k] = v
+        }
+    }
+
+    return hdr
+}
+
+
+//  Write asset to tar archive. The header and content are of the same
+// asset version. Assets removed after listing are skipped.
+func (s *Storage) writeTarAsset(tw *tar.Writer, path Path, opts StorageArchiveOpts) error {
+    info, r, err := s.OpenAsset(path)
+    if errors.Is(err, ASSET_NOT_EXIST) {
+        return nil
+    }
+    if err != nil {
+        return fmt.Errorf("Open asset: %s error: %w", path, err)
+    }
+    defer r.Close()
+
+    if err := tw.WriteHeader(tarHeader(info, opts)); err != nil {
+        return err
+    }
+
+    if !info.IsFile() {
+        return nil
+    }
+
+    if _, err := io.Copy(tw, r); err != nil {
+        return fmt.Errorf("Archive asset: %s error: %w", path, err)
+    }
+
+    return nil
+}
+
+
+//  Make zip header for the asset. Only the fields what depend on the asset
+// are set, so the same assets always give the same headers. Directories
+// and symlinks are always stored.
+func zipHeader(info *StorageAssetInfo, opts StorageArchiveOpts) *zip.FileHeader {
+    hdr := &zip.FileHeader{
+        Name:       info.Path,
+        Method:     zip.Deflate,
+        Modified:   archiveMtime(info, opts),
+    }
+
+    if opts.Compression == ArchiveStore {
+        hdr.Method = zip.Store
+    }
+
+    switch info.Kind {
+    case AssetKindSymlink:
+        hdr.Method = zip.Store
+        hdr.SetMode(os.ModeSymlink | info.FileMode())
+    case AssetKindDir:
+        hdr.Name, hdr.Method = info.Path + "/", zip.Store
+        hdr.SetMode(os.ModeDir | info.FileMode())
+    default:
+        hdr.SetMode(info.FileMode())
+    }
+
+    return hdr
+}
+
+
+//  Write asset to zip archive, symlink target is the entry content.
+// Assets removed after listing are skipped.
+func (s *Storage) writeZipAsset(zw *zip.Writer, path Path, opts StorageArchiveOpts) error {
+    info, r, err := s.OpenAsset(path)
+    if errors.Is(err, ASSET_NOT_EXIST) {
+        return nil
+    }
+    if err != nil {
+        return fmt.Errorf("Open asset: %s error: %w", path, err)
+    }
+    defer r.Close()
+
+    fw, err := zw.CreateHeader(zipHeader(info, opts))
+    if err != nil {
+        return err
+    }
+
+    switch {
+    case info.Kind == AssetKindSymlink:
+        _, err = io.WriteString(fw, info.Target)
+    case info.IsFile():
+        _, err = io.Copy(fw, r)
+    }
+    if err != nil {
+        return fmt.Errorf("Archive asset: %s error: %w", path, err)
+    }
+
+    return nil
+}
+
+
+//  Stream zip archive of the assets. Entries are sorted by path and have
+// normalized headers, so archives of the same assets are byte identical.
+func (s *Storage) writeZipArchive(w io.Writer, prefix Path, opts StorageArchiveOpts) error {
+    zw := zip.NewWriter(w)
+
+    for _, path := range s.archivePaths(prefix) {
+        if err := s.writeZipAsset(zw, path, opts); err != nil {
+            return err
+        }
+    }
+
+    return zw.Close()
+}
+
+
+//  Stream archive of the storage assets to the writer. Assets are read
+// one by one, so the archive is never staged and is not a snapshot: the
+// asset modified during archiving gets its old or new version, the
+// removed one is skipped. The entry header always matches its content
+// (see OpenAsset). Asset properties are kept only by tar archives.
+func (s *Storage) WriteArchive(w io.Writer, opts StorageArchiveOpts) error {
+    if err := opts.Validate(); err != nil {
+        return err
+    }
+
+    prefix, err := cleanPrefix(opts.Prefix)
+    if err != nil {
+        return err
+    }
+
+    if opts.Format == ArchiveZip {
+        return s.writeZipArchive(w, prefix, opts)
+    }
+
+    var gw *gzip.Writer
+    if opts.Format == ArchiveTarGz {
+        gw = gzip.NewWriter(w)
+        w = gw
+    }
+
+    tw := tar.NewWriter(w)
+
+    for _, path := range s.archivePaths(prefix) {
+        if err := s.writeTarAsset(tw, path, opts); err != nil {
+            return err
+        }
+    }
+
+    if err := tw.Close(); err != nil {
+        return err
+    }
+
+    if gw != nil {
+        return gw.Close()
+    }
+
+    return nil
+}
+
+
+// Check archived subtree prefix. Empty prefix means whole storage.
+func cleanPrefix(prefix Path) (Path, error) {
+    if len(prefix) == 0 {
+        return prefix, nil
+    }
+
+    cleaned, err := CleanPath(prefix)
+    if err != nil {
+        return "", err
+    }
+
+    // Keep the trailing slash, so 'dir/' doesn't match 'dir2/asset'.
+    if strings.HasSuffix(prefix, "/") {
+        cleaned += "/"
+    }
+
+    return cleaned, nil
+}
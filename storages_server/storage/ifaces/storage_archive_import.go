@@ -0,0 +1,337 @@
+package storage_ifaces
+
+import (
+    "archive/tar"
+    "archive/zip"
+    "bufio"
+    "bytes"
+    "compress/gzip"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "strings"
+)
+
+
+// Archive import entry result.
+type StorageImportEntry struct {
+    Path    Path    `json:"path"`
+    Kind    string  `json:"kind,omitempty"`
+    Size    int64   `json:"size"`
+    ETag    string  `json:"etag,omitempty"`
+
+    // Entries of the unsupported types (hard links, devices, fifos) are
+    // skipped.
+    Skipped bool    `json:"skipped,omitempty"`
+    Error   string  `json:"error,omitempty"`
+}
+
+
+// Max symlink target length in zip archives.
+const maxZipSymlinkTarget = 4096
+
+
+//  Reader of the archive entry content. Counts the entry size and marks
+// the read errors by BAD_ARCHIVE, so corrupted archives are distinguished
+// from the storage errors.
+type archiveEntryReader struct {
+    reader  io.Reader
+    size    int64
+}
+
+
+func (r *archiveEntryReader) Read(p []byte) (int, error) {
+    n, err := r.reader.Read(p)
+    r.size += int64(n)
+    if err != nil && err != io.EOF {
+        err = fmt.Errorf("Archive read error: %s. Err: %w", err, BAD_ARCHIVE)
+    }
+    return n, err
+}
+
+
+// Asset import state, keeps created assets for the rollback.
+type archiveImport struct {
+    storage *Storage
+    prefix  Path
+    entries []StorageImportEntry
+    created []Path
+}
+
+
+//  Get archive entry name relative to the archive root. Archives of the
+// directory content ('tar -C dir -cf x.tar .') have names starting with
+// './' and the entry of the root directory itself, which is empty.
+func archiveEntryName(name string) string {
+    for strings.HasPrefix(name, "./") {
+        name = strings.TrimLeft(strings.TrimPrefix(name, "./"), "/")
+    }
+    if name == "." {
+        return ""
+    }
+    return strings.TrimSuffix(name, "/")
+}
+
+
+//  Create asset from the archive entry. Existing assets are never
+// replaced, the conflict is reported by error wrapping ASSET_EXIST.
+// The archive root entry is ignored.
+func (ai *archiveImport) add(name string, opts StorageAssetOpts, r io.Reader) error {
+    name = archiveEntryName(name)
+    if len(name) == 0 {
+        return nil
+    }
+
+    entry := StorageImportEntry{Path: ai.prefix + name, Kind: opts.Kind}
+
+    err := ai.create(&entry, opts, r)
+    if err != nil {
+        entry.Error = err.Error()
+    }
+
+    ai.entries = append(ai.entries, entry)
+
+    return err
+}
+
+
+func (ai *archiveImport) create(entry *StorageImportEntry, opts StorageAssetOpts, r io.Reader) error {
+    path, err := CleanPath(entry.Path)
+    if err != nil {
+        return err
+    }
+    entry.Path = path
+
+    er := &archiveEntryReader{reader: r}
+
+    etag, err := ai.storage.WriteAsset(path, &StorageAssetReader{Reader: er, Opts: opts}, StorageAssetCond{IfNoneMatch: "*"})
+    if errors.Is(err, PRECONDITION_FAILED) {
+        return fmt.Errorf("Asset: %s already exist! Err: %w", path, ASSET_EXIST)
+    }
+    if err != nil {
+        return err
+    }
+
+    ai.created = append(ai.created, path)
+
+    entry.Size, entry.ETag = er.size, etag
+
+    return nil
+}
+
+
+func (ai *archiveImport) skip(name string) {
+    ai.entries = append(ai.entries, StorageImportEntry{Path: ai.prefix + archiveEntryName(name), Skipped: true})
+}
+
+
+//  Remove created assets in the reverse order, so the directory assets
+// are removed after their content.
+func (ai *archiveImport) rollback(cause error) error {
+    for i := len(ai.created) - 1; i >= 0; i-- {
+        if err := ai.storage.DeleteAsset(ai.created[i]); err != nil && !errors.Is(err, ASSET_NOT_EXIST) {
+            return fmt.Errorf("%w. Rollback error: %s", cause, err)
+        }
+    }
+    return cause
+}
+
+
+func (ai *archiveImport) importTar(r io.Reader) error {
+    tr := tar.NewReader(r)
+
+    for {
+        hdr, err := tr.Next()
+        if err == io.EOF {
+            return nil
+        }
+        if err != nil {
+            return fmt.Errorf("Tar read error: %s. Err: %w", err, BAD_ARCHIVE)
+        }
+
+        opts := StorageAssetOpts{Mode: int(hdr.Mode) & AssetModeMask}
+
+        mtime := hdr.ModTime.UTC()
+        opts.Mtime = &mtime
+
+        for k, v := range hdr.PAXRecords {
+            if strings.HasPrefix(k, archivePropertyPrefix) {
+                if opts.Properties == nil {
+                    opts.Properties = make(StorageAssetProperties)
+                }
+                opts.Properties[strings.TrimPrefix(k, archivePropertyPrefix)] = v
+            }
+        }
+
+        switch hdr.Typeflag {
+        case tar.TypeReg:
+        case tar.TypeSymlink:
+            opts.Kind, opts.Target = AssetKindSymlink, hdr.Linkname
+        case tar.TypeDir:
+            opts.Kind = AssetKindDir
+        case tar.TypeXGlobalHeader:
+            continue
+        default:
+            ai.skip(hdr.Name)
+            continue
+        }
+
+        if err := ai.add(hdr.Name, opts, tr); err != nil {
+            return err
+        }
+    }
+}
+
+
+func (ai *archiveImport) importZip(r io.ReaderAt, size int64) error {
+    zr, err := zip.NewReader(r, size)
+    if err != nil {
+        return fmt.Errorf("Zip read error: %s. Err: %w", err, BAD_ARCHIVE)
+    }
+
+    for _, f := range zr.File {
+        fi := f.FileInfo()
+
+        mtime := f.Modified.UTC()
+        opts := StorageAssetOpts{Mode: AssetMode(fi.Mode()), Mtime: &mtime}
+
+        err := func() error {
+            fr, err := f.Open()
+            if err != nil {
+                return fmt.Errorf("Zip entry: %s open error: %s. Err: %w", f.Name, err, BAD_ARCHIVE)
+            }
+            defer fr.Close()
+
+            var content io.Reader = fr
+
+            switch {
+            case fi.Mode() & os.ModeSymlink != 0:
+                target, err := ioutil.ReadAll(&archiveEntryReader{reader: io.LimitReader(fr, maxZipSymlinkTarget)})
+                if err != nil {
+                    return err
+                }
+                opts.Kind, opts.Target, content = AssetKindSymlink, string(target), bytes.NewReader(nil)
+            case fi.IsDir():
+                opts.Kind = AssetKindDir
+            case !fi.Mode().IsRegular():
+                ai.skip(f.Name)
+                return nil
+            }
+
+            return ai.add(f.Name, opts, content)
+        }()
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+
+//  Get zip archive random access reader. The files are used as is, other
+// readers are staged to the temp file. Returned cleanup function must be
+// called when the archive is processed.
+func (s *Storage) zipReader(r io.Reader) (io.ReaderAt, int64, func(), error) {
+    if f, ok := r.(*os.File); ok {
+        if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+            return f, fi.Size(), func() {}, nil
+        }
+    }
+
+    f, err := ioutil.TempFile(s.Parent.Opts().TempDir, s.Parent.Opts().TempPattern)
+    if err != nil {
+        return nil, 0, nil, err
+    }
+
+    cleanup := func() {
+        f.Close()
+        os.Remove(f.Name())
+    }
+
+    size, err := io.Copy(f, r)
+    if err != nil {
+        cleanup()
+        return nil, 0, nil, err
+    }
+
+    return f, size, cleanup, nil
+}
+
+
+// Detect archive format by the content magic.
+func sniffArchiveFormat(r *bufio.Reader) string {
+    magic, _ := r.Peek(4)
+
+    switch {
+    case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+        return ArchiveTarGz
+    case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+        return ArchiveZip
+    }
+
+    return ArchiveTar
+}
+
+
+//  Expand tar, tar.gz or zip archive into the storage assets. Import is
+// all or nothing: on the first failed entry all already created assets
+// are removed. Existing assets are never replaced. Returns results of the
+// processed entries, the last one keeps the error if the import failed.
+// Malformed archives are reported by errors wrapping BAD_ARCHIVE.
+func (s *Storage) ImportArchive(r io.Reader, opts StorageArchiveOpts) ([]StorageImportEntry, error) {
+    if err := opts.Validate(); err != nil {
+        return nil, err
+    }
+
+    prefix, err := cleanPrefix(opts.Prefix)
+    if err != nil {
+        return nil, err
+    }
+
+    if len(prefix) > 0 && !strings.HasSuffix(prefix, "/") {
+        prefix += "/"
+    }
+
+    ai := &archiveImport{storage: s, prefix: prefix, entries: make([]StorageImportEntry, 0, 100)}
+
+    format := opts.Format
+    if len(format) == 0 {
+        br := bufio.NewReader(r)
+        format, r = sniffArchiveFormat(br), br
+    }
+
+    switch format {
+    case ArchiveTar:
+        err = ai.importTar(r)
+    case ArchiveTarGz:
+        var gr *gzip.Reader
+        gr, err = gzip.NewReader(r)
+        if err != nil {
+            err = fmt.Errorf("Gzip read error: %s. Err: %w", err, BAD_ARCHIVE)
+            break
+        }
+        err = ai.importTar(gr)
+        // Verify the gzip trailer checksum.
+        if err == nil {
+            if _, err = io.Copy(ioutil.Discard, gr); err != nil {
+                err = fmt.Errorf("Gzip read error: %s. Err: %w", err, BAD_ARCHIVE)
+            }
+        }
+    case ArchiveZip:
+        ra, size, cleanup, zerr := s.zipReader(r)
+        if zerr != nil {
+            return nil, zerr
+        }
+        defer cleanup()
+        err = ai.importZip(ra, size)
+    }
+
+    if err != nil {
+        return ai.entries, ai.rollback(err)
+    }
+
+    return ai.entries, nil
+}
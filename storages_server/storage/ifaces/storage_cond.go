@@ -0,0 +1,73 @@
+package storage_ifaces
+
+import (
+    "fmt"
+    "strings"
+)
+
+
+// Asset write preconditions. Semantics follows the HTTP 'If-Match' and
+// 'If-None-Match' headers: empty value means no condition, "*" matches
+// any existing asset, otherwise value is a comma separated ETags list.
+type StorageAssetCond struct {
+    IfMatch     string
+    IfNoneMatch string
+}
+
+
+func (c StorageAssetCond) String() string {
+    return fmt.Sprintf("{ if-match: '%s', if-none-match: '%s' }", c.IfMatch, c.IfNoneMatch)
+}
+
+
+// Returns true if the current asset ETag is needed to check the
+// preconditions.
+func (c StorageAssetCond) NeedsETag() bool {
+    return (len(c.IfMatch) > 0 && c.IfMatch != "*") ||
+        (len(c.IfNoneMatch) > 0 && c.IfNoneMatch != "*")
+}
+
+
+// Check preconditions against current asset state. Returns error wrapping
+// PRECONDITION_FAILED if asset can't be written.
+func (c StorageAssetCond) Check(path Path, exist bool, etag string) error {
+
+    if len(c.IfMatch) > 0 {
+        if !exist {
+            return fmt.Errorf("Asset: '%s' not exist! Err: %w", path, PRECONDITION_FAILED)
+        }
+        if c.IfMatch != "*" && !matchETag(c.IfMatch, etag) {
+            return fmt.Errorf("Asset: '%s' ETag mismatch! Err: %w", path, PRECONDITION_FAILED)
+        }
+    }
+
+    if len(c.IfNoneMatch) > 0 && exist {
+        if c.IfNoneMatch == "*" {
+            return fmt.Errorf("Asset: '%s' already exist! Err: %w", path, PRECONDITION_FAILED)
+        }
+        if matchETag(c.IfNoneMatch, etag) {
+            return fmt.Errorf("Asset: '%s' ETag match! Err: %w", path, PRECONDITION_FAILED)
+        }
+    }
+
+    return nil
+}
+
+
+// Strip weakness prefix and quotes from the ETag.
+func NormalizeETag(etag string) string {
+    etag = strings.TrimSpace(etag)
+    etag = strings.TrimPrefix(etag, "W/")
+    return strings.Trim(etag, "\"")
+}
+
+
+func matchETag(list string, etag string) bool {
+    for _, candidate := range strings.Split(list, ",") {
+        candidate = NormalizeETag(candidate)
+        if candidate == "*" || candidate == etag {
+            return true
+        }
+    }
+    return false
+}
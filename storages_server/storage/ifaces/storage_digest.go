@@ -0,0 +1,168 @@
+package storage_ifaces
+
+import (
+    "crypto/md5"
+    "crypto/sha1"
+    "crypto/sha256"
+    "crypto/sha512"
+    "fmt"
+    "hash"
+    "io"
+    "sort"
+    "strings"
+)
+
+
+// Supported digest algorithms.
+const (
+    DigestMd5       = "md5"
+    DigestSha1      = "sha1"
+    DigestSha256    = "sha256"
+    DigestSha512    = "sha512"
+)
+
+
+var digestHashes = map[string]func() hash.Hash{
+    DigestMd5:      md5.New,
+    DigestSha1:     sha1.New,
+    DigestSha256:   sha256.New,
+    DigestSha512:   sha512.New,
+}
+
+
+//  Register additional digest algorithm (e.g. blake2b). Must be called
+// before the storages manager creation, the registry is not synchronized.
+// Algorithm names must not contain ':'.
+func RegisterDigestAlgorithm(algorithm string, newHash func() hash.Hash) {
+    if strings.ContainsRune(algorithm, ':') {
+        panic(fmt.Errorf("Bad digest algorithm name: %s", algorithm))
+    }
+    digestHashes[algorithm] = newHash
+}
+
+
+// Check if the digest algorithm is known.
+func IsDigestAlgorithm(algorithm string) bool {
+    _, ok := digestHashes[algorithm]
+    return ok
+}
+
+
+//  Make hash for the digest algorithm. Returns error wrapping BAD_DIGEST if
+// the algorithm is unknown.
+func NewDigestHash(algorithm string) (hash.Hash, error) {
+    newHash, ok := digestHashes[algorithm]
+    if !ok {
+        return nil, fmt.Errorf("Unknown digest algorithm: %s! Err: %w", algorithm, BAD_DIGEST)
+    }
+    return newHash(), nil
+}
+
+
+// Check if the value is well formed hex encoded digest of the algorithm.
+func IsDigest(algorithm string, value string) bool {
+    newHash, ok := digestHashes[algorithm]
+    if !ok {
+        return false
+    }
+
+    return len(value) == newHash().Size() * 2 && strings.Trim(value, "0123456789abcdef") == ""
+}
+
+
+//  Expected asset content digests provided by client. Maps algorithm name
+// to the hex encoded digest value.
+type StorageAssetDigests map[string]string
+
+
+func (d StorageAssetDigests) String() string {
+    items := make([]string, 0, len(d))
+    for algorithm, value := range d {
+        items = append(items, algorithm + "=" + value)
+    }
+    sort.Strings(items)
+
+    return "{ " + strings.Join(items, ", ") + " }"
+}
+
+
+//  Add expected digest. Returns error wrapping BAD_DIGEST if the algorithm
+// is unknown, the value is malformed or conflicts with already added one.
+func (d StorageAssetDigests) Add(algorithm string, value string) error {
+    if !IsDigestAlgorithm(algorithm) {
+        return fmt.Errorf("Unknown digest algorithm: %s! Err: %w", algorithm, BAD_DIGEST)
+    }
+
+    value = strings.ToLower(value)
+
+    if !IsDigest(algorithm, value) {
+        return fmt.Errorf("Malformed %s digest: %s! Err: %w", algorithm, value, BAD_DIGEST)
+    }
+
+    if prev, ok := d[algorithm]; ok && prev != value {
+        return fmt.Errorf("Conflicting %s digests: %s and %s! Err: %w", algorithm, prev, value, BAD_DIGEST)
+    }
+
+    d[algorithm] = value
+
+    return nil
+}
+
+
+//  Reader calculating digests of the data read and verifying them against
+// the expected ones when the underlying reader is exhausted. On mismatch
+// returns error wrapping DIGEST_MISMATCH instead of io.EOF, so the asset
+// writers abort and drop the received data.
+type DigestReader struct {
+    reader  io.Reader
+    digests StorageAssetDigests
+    hashes  map[string]hash.Hash
+}
+
+
+// Wrap reader with the verifying one. Returns the reader itself if there
+// are no digests to verify.
+func NewDigestReader(r io.Reader, digests StorageAssetDigests) io.Reader {
+    if len(digests) == 0 {
+        return r
+    }
+
+    dr := &DigestReader{
+        reader:     r,
+        digests:    digests,
+        hashes:     make(map[string]hash.Hash),
+    }
+
+    for algorithm := range digests {
+        dr.hashes[algorithm] = digestHashes[algorithm]()
+    }
+
+    return dr
+}
+
+
+func (dr *DigestReader) Read(p []byte) (int, error) {
+    n, err := dr.reader.Read(p)
+
+    for _, h := range dr.hashes {
+        h.Write(p[:n])
+    }
+
+    if err == io.EOF {
+        if verr := dr.verify(); verr != nil {
+            return n, verr
+        }
+    }
+
+    return n, err
+}
+
+
+func (dr *DigestReader) verify() error {
+    for algorithm, h := range dr.hashes {
+        if actual := fmt.Sprintf("%x", h.Sum(nil)); actual != dr.digests[algorithm] {
+            return fmt.Errorf("Content %s digest: %s, expected: %s! Err: %w", algorithm, actual, dr.digests[algorithm], DIGEST_MISMATCH)
+        }
+    }
+    return nil
+}
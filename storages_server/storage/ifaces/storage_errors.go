@@ -0,0 +1,36 @@
+package storage_ifaces
+
+import "errors"
+
+
+// Declare errors
+var (
+    ASSET_NOT_EXIST = errors.New("Asset not exist!")
+    ASSET_EXIST     = errors.New("Asset already exist!")
+
+    PRECONDITION_FAILED = errors.New("Asset precondition failed!")
+
+    BAD_PATH        = errors.New("Bad asset path!")
+    BAD_ASSET_OPTS  = errors.New("Bad asset options!")
+
+    BAD_LIST_OPTS   = errors.New("Bad listing options!")
+
+    BAD_NAME        = errors.New("Bad storage name!")
+    NAME_EXIST      = errors.New("Storage name already exist!")
+    NAME_NOT_EXIST  = errors.New("Storage name not exist!")
+
+    QUOTA_EXCEEDED  = errors.New("Storage quota exceeded!")
+    ASSET_TOO_LARGE = errors.New("Asset exceeds storage quota!")
+
+    SEALED          = errors.New("Storage is sealed!")
+    CONVERTING      = errors.New("Storage conversion is in progress!")
+
+    OBJECT_NOT_EXIST = errors.New("Vault object not exist!")
+
+    BAD_DIGEST      = errors.New("Bad content digest!")
+    DIGEST_MISMATCH = errors.New("Content digest mismatch!")
+
+    BAD_ARCHIVE     = errors.New("Bad archive!")
+
+    BUFFER_NOT_EXIST = errors.New("Buffer not exist!")
+)
@@ -1,6 +1,8 @@
 package storage_ifaces
 
 import (
+    "fmt"
+    "regexp"
     "encoding/json"
     "github.com/google/uuid"
 )
@@ -29,3 +31,16 @@ func (sid *StorageId) Json() []byte {
     }
     return data
 }
+
+
+var storageNameRe = regexp.MustCompile(`^[0-9A-Za-z][0-9A-Za-z._-]*$`)
+
+
+// Check storage name or alias. Returns error wrapping BAD_NAME if the
+// name can't be used.
+func CheckStorageName(name string) error {
+    if len(name) > 255 || !storageNameRe.MatchString(name) {
+        return fmt.Errorf("Invalid storage name: '%s'! Err: %w", name, BAD_NAME)
+    }
+    return nil
+}
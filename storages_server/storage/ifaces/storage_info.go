@@ -0,0 +1,29 @@
+package storage_ifaces
+
+import (
+    "fmt"
+    "time"
+)
+
+
+// Asset information.
+type StorageAssetInfo struct {
+    StorageAssetOpts
+
+    Path        Path        `json:"path"`
+    Size        int64       `json:"size"`
+    //  Asset ETag. The content sha256 if it is known, the vault object id
+    // otherwise. Content digests by algorithm are in Digests.
+    ETag        string      `json:"etag"`
+
+    // Known content digests by algorithm, see StoragesManagerOpts.
+    Digests     StorageAssetDigests `json:"digests,omitempty"`
+    Created     time.Time   `json:"created"`
+    Modified    time.Time   `json:"modified"`
+}
+
+
+func (i StorageAssetInfo) String() string {
+    return fmt.Sprintf("{ path: %s, opts: %s, size: %d, etag: %s, created: %s, modified: %s }",
+        i.Path, i.StorageAssetOpts.String(), i.Size, i.ETag, i.Created, i.Modified)
+}
@@ -0,0 +1,89 @@
+package storage_ifaces
+
+import (
+    "fmt"
+    "os"
+)
+
+
+//  Optional StorageOps extension implemented by the storages keeping
+// assets content in the vault. Such storages can reference existing vault
+// object without copying it.
+type StorageLinker interface {
+    // Must create or replace asset referencing the vault object with the
+    // same preconditions semantics as StorageOps.WriteAsset. If the object
+    // is not exist, error wrapping OBJECT_NOT_EXIST must be returned.
+    LinkAsset(*Storage, Path, string, StorageAssetOpts, StorageAssetCond) (string, error)
+}
+
+
+//  Create or replace file asset with the content of existing vault object.
+// Storages not implementing StorageLinker get the copy of the object.
+// Returns error wrapping OBJECT_NOT_EXIST if the vault has no such object.
+func (s *Storage) LinkAsset(path Path, object string, opts StorageAssetOpts, cond StorageAssetCond) (string, error) {
+    path, err := CleanPath(path)
+    if err != nil {
+        return "", err
+    }
+
+    if !IsVaultObject(object) {
+        return "", fmt.Errorf("Bad vault object id: '%s'! Err: %w", object, BAD_ASSET_OPTS)
+    }
+    object = CanonicalVaultObject(object)
+
+    opts, err = opts.Normalize()
+    if err != nil {
+        return "", err
+    }
+
+    if !opts.IsFile() {
+        return "", fmt.Errorf("Only files can be linked to vault objects! Err: %w", BAD_ASSET_OPTS)
+    }
+
+    vault := s.Parent.Vault()
+    asset := VaultAsset{Object: object, Path: path}
+
+    fi, err := vault.StatObject(asset)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return "", fmt.Errorf("Unknown vault object: %s. Err: %w", object, OBJECT_NOT_EXIST)
+        }
+        return "", err
+    }
+
+    unlock, err := s.lockModify()
+    if err != nil {
+        return "", err
+    }
+
+    linker, ok := s.Ops.(StorageLinker)
+    if !ok {
+        unlock()
+
+        f, err := vault.OpenObject(asset)
+        if err != nil {
+            return "", fmt.Errorf("Can't open vault object: %s. Err: %w", object, OBJECT_NOT_EXIST)
+        }
+        defer f.Close()
+
+        return s.WriteAsset(path, &StorageAssetReader{Reader: f.File, Opts: opts}, cond)
+    }
+    defer unlock()
+
+    r, release, err := s.reserveQuota(path, &StorageAssetReader{Opts: opts})
+    if err != nil {
+        return "", err
+    }
+
+    if qr, ok := r.Reader.(*quotaReader); ok {
+        if err := qr.account(fi.Size()); err != nil {
+            release(false)
+            return "", err
+        }
+    }
+
+    etag, err := linker.LinkAsset(s, path, object, opts, cond)
+    release(err == nil)
+
+    return etag, err
+}
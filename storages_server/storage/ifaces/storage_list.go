@@ -1,25 +1,251 @@
 package storage_ifaces
 
 import (
+    "bytes"
+    "encoding/base64"
     "encoding/json"
     "fmt"
+    "io"
+    "path"
+    "strings"
+    "time"
 )
 
-type element struct {
-    Path   string               `json:"path"`
-    Props  map[string]string    `json:"properties"`
+
+// Listing entry. Contains either asset path and properties or
+// common prefix (for the listings with delimiter).
+type StorageListEntry struct {
+    Path    Path                `json:"path,omitempty"`
+    Props   map[string]string   `json:"properties,omitempty"`
+    Prefix  Path                `json:"prefix,omitempty"`
 }
 
-func (s *Storage) List() ([]byte, error) {
-    elements := make([]element, 0, 100)
 
-    s.Range(func(path Path, opts StorageAssetOpts) bool {
-        props := make(map[string]string)
-        props["mode"] = fmt.Sprintf("%d", opts.Mode)
-        elements = append(elements, element{Path: path, Props: props})
+// Key used to resume the listing after the entry.
+func (e StorageListEntry) key() Path {
+    if len(e.Prefix) > 0 {
+        return e.Prefix
+    }
+    return e.Path
+}
+
+
+// Listing options.
+type StorageListOpts struct {
+    // Only assets with the prefix will be listed.
+    Prefix      Path
+
+    // If not empty, assets having the delimiter after the prefix are
+    // rolled up to the single common prefix entry.
+    Delimiter   string
+
+    // Glob pattern (see path.Match) the asset path must match.
+    Pattern     string
+
+    // Properties the asset must have.
+    Filter      StorageAssetProperties
+
+    // Maximum entries count, 0 means no limit.
+    Limit       int
+
+    // Continuation token returned by previous listing.
+    Token       string
+}
+
+
+// Callback for the Storage.ListEntries method. Non 'nil' result stops
+// the listing.
+type StorageListCallback = func(StorageListEntry) error
+
+
+// Check listing options. Returns error wrapping BAD_LIST_OPTS if the
+// options are malformed.
+func (o StorageListOpts) Validate() error {
+    if len(o.Pattern) > 0 {
+        if _, err := path.Match(o.Pattern, ""); err != nil {
+            return fmt.Errorf("Malformed pattern: %s. Err: %w", o.Pattern, BAD_LIST_OPTS)
+        }
+    }
+
+    if o.Limit < 0 {
+        return fmt.Errorf("Negative limit: %d. Err: %w", o.Limit, BAD_LIST_OPTS)
+    }
+
+    if len(o.Token) > 0 {
+        if _, err := parseListToken(o.Token); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+
+func makeListToken(key Path) string {
+    return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+
+func parseListToken(token string) (Path, error) {
+    key, err := base64.RawURLEncoding.DecodeString(token)
+    if err != nil {
+        return "", fmt.Errorf("Malformed continuation token: %s. Err: %w", token, BAD_LIST_OPTS)
+    }
+    return Path(key), nil
+}
+
+
+//  Enumerates listing entries in lexical order. Returns continuation token
+// if listing is truncated by the limit, or empty string if all entries are
+// listed.
+func (s *Storage) ListEntries(opts StorageListOpts, callback StorageListCallback) (string, error) {
+
+    if err := opts.Validate(); err != nil {
+        return "", err
+    }
+
+    rangeOpts := StorageRangeOpts{Prefix: opts.Prefix}
+
+    // Entries rolled up to the common prefix are already listed.
+    skipPrefix := ""
+
+    if len(opts.Token) > 0 {
+        after, err := parseListToken(opts.Token)
+        if err != nil {
+            return "", err
+        }
+
+        rangeOpts.After = after
+
+        if len(opts.Delimiter) > 0 && strings.HasSuffix(after, opts.Delimiter) {
+            skipPrefix = after
+        }
+    }
+
+    var (
+        count   int
+        last    Path
+        token   string
+        err     error
+    )
+
+    s.RangeFrom(rangeOpts, func(p Path, assetOpts StorageAssetOpts) bool {
+
+        if len(skipPrefix) > 0 && strings.HasPrefix(p, skipPrefix) {
+            return true
+        }
+
+        if len(opts.Pattern) > 0 {
+            if ok, _ := path.Match(opts.Pattern, p); !ok {
+                return true
+            }
+        }
+
+        if !assetOpts.Match(opts.Filter) {
+            return true
+        }
+
+        entry := StorageListEntry{Path: p}
+
+        if len(opts.Delimiter) > 0 {
+            rest := strings.TrimPrefix(p, opts.Prefix)
+            if idx := strings.Index(rest, opts.Delimiter); idx >= 0 {
+                entry = StorageListEntry{Prefix: opts.Prefix + rest[:idx + len(opts.Delimiter)]}
+                skipPrefix = entry.Prefix
+            }
+        }
+
+        if opts.Limit > 0 && count == opts.Limit {
+            token = makeListToken(last)
+            return false
+        }
+
+        if len(entry.Path) > 0 {
+            entry.Props = make(map[string]string)
+            for k, v := range assetOpts.Properties {
+                entry.Props[k] = v
+            }
+            entry.Props["mode"] = fmt.Sprintf("%d", assetOpts.Mode)
+            if !assetOpts.IsFile() {
+                entry.Props["kind"] = assetOpts.Kind
+            }
+            if len(assetOpts.Target) > 0 {
+                entry.Props["target"] = assetOpts.Target
+            }
+            if assetOpts.Mtime != nil {
+                entry.Props["mtime"] = assetOpts.Mtime.UTC().Format(time.RFC3339Nano)
+            }
+            if assetOpts.Uid != nil {
+                entry.Props["uid"] = fmt.Sprintf("%d", *assetOpts.Uid)
+            }
+            if assetOpts.Gid != nil {
+                entry.Props["gid"] = fmt.Sprintf("%d", *assetOpts.Gid)
+            }
+        }
+
+        if err = callback(entry); err != nil {
+            return false
+        }
+
+        count++
+        last = entry.key()
+
         return true
     })
 
-    resp, err := json.Marshal(elements)
-    return resp, err
+    return token, err
+}
+
+
+func (s *Storage) List() ([]byte, error) {
+    return s.ListFiltered(nil)
+}
+
+
+// List assets having all properties from the filter.
+func (s *Storage) ListFiltered(filter StorageAssetProperties) ([]byte, error) {
+    var buf bytes.Buffer
+
+    err := s.ListArray(&buf, StorageListOpts{Filter: filter})
+
+    return buf.Bytes(), err
+}
+
+
+// Write JSON array of listing entries. The array is written entry by
+// entry, so whole listing is never kept in memory.
+func (s *Storage) ListArray(w io.Writer, opts StorageListOpts) error {
+    if err := opts.Validate(); err != nil {
+        return err
+    }
+
+    if _, err := w.Write([]byte("[")); err != nil {
+        return err
+    }
+
+    first := true
+
+    _, err := s.ListEntries(opts, func(entry StorageListEntry) error {
+        b, err := json.Marshal(entry)
+        if err != nil {
+            return err
+        }
+
+        if !first {
+            if _, err := w.Write([]byte(",")); err != nil {
+                return err
+            }
+        }
+        first = false
+
+        _, err = w.Write(b)
+        return err
+    })
+    if err != nil {
+        return err
+    }
+
+    _, err = w.Write([]byte("]"))
+    return err
 }
+
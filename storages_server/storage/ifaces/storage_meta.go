@@ -0,0 +1,75 @@
+package storage_ifaces
+
+import (
+    "time"
+)
+
+
+//  Storage aliases, expiration and quota are changed while the storage is
+// used, so these are accessed under the metadata lock. Pointed values are
+// never modified, only replaced.
+
+// Get storage aliases copy.
+func (s *Storage) GetAliases() []string {
+    s.metaLock.RLock()
+    defer s.metaLock.RUnlock()
+
+    return append([]string(nil), s.Aliases...)
+}
+
+
+func (s *Storage) AddAlias(alias string) {
+    s.metaLock.Lock()
+    defer s.metaLock.Unlock()
+
+    s.Aliases = append(append([]string(nil), s.Aliases...), alias)
+}
+
+
+func (s *Storage) RemoveAlias(alias string) {
+    s.metaLock.Lock()
+    defer s.metaLock.Unlock()
+
+    aliases := make([]string, 0, len(s.Aliases))
+    for _, a := range s.Aliases {
+        if a != alias {
+            aliases = append(aliases, a)
+        }
+    }
+
+    s.Aliases = aliases
+}
+
+
+func (s *Storage) GetExpires() *time.Time {
+    s.metaLock.RLock()
+    defer s.metaLock.RUnlock()
+
+    return s.Expires
+}
+
+
+// Set storage expiration time. Nil means that the storage never expires.
+func (s *Storage) SetExpires(expires *time.Time) {
+    s.metaLock.Lock()
+    defer s.metaLock.Unlock()
+
+    s.Expires = expires
+}
+
+
+func (s *Storage) GetQuota() *StorageQuota {
+    s.metaLock.RLock()
+    defer s.metaLock.RUnlock()
+
+    return s.Quota
+}
+
+
+// Set storage quota. Nil means unlimited storage.
+func (s *Storage) SetQuota(quota *StorageQuota) {
+    s.metaLock.Lock()
+    defer s.metaLock.Unlock()
+
+    s.Quota = quota
+}
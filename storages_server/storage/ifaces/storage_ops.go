@@ -2,26 +2,175 @@ package storage_ifaces
 
 import (
     "io"
+    "os"
     "fmt"
+    "time"
+    "strings"
+    "crypto/sha256"
 )
 
 type Path = string
 
 
+// User defined asset properties.
+type StorageAssetProperties = map[string]string
+
+
+// Asset kinds. Empty kind means regular file.
+const (
+    AssetKindFile       = "file"
+    AssetKindSymlink    = "symlink"
+    AssetKindDir        = "dir"
+)
+
+
+// Unix mode bits allowed for assets: permissions, setuid, setgid and
+// sticky bits.
+const AssetModeMask = 0o7777
+
+
 // Asset options.
 type StorageAssetOpts struct {
-    Mode int    `json:"mode"`
+    // Unix mode bits, see AssetModeMask.
+    Mode        int                     `json:"mode"`
+    Properties  StorageAssetProperties  `json:"properties,omitempty"`
+
+    // Asset kind and symlink target. Only regular files have content.
+    Kind        string                  `json:"kind,omitempty"`
+    Target      string                  `json:"target,omitempty"`
+
+    // Optional modification time and ownership of the asset origin.
+    Mtime       *time.Time              `json:"mtime,omitempty"`
+    Uid         *int                    `json:"uid,omitempty"`
+    Gid         *int                    `json:"gid,omitempty"`
 }
 
 
 func (o StorageAssetOpts) String() string {
-    return fmt.Sprintf("{ mode: 0%03o(%d) }", o.Mode, o.Mode)
+    owner := func(id *int) string {
+        if id == nil {
+            return "-"
+        }
+        return fmt.Sprintf("%d", *id)
+    }
+
+    mtime := "-"
+    if o.Mtime != nil {
+        mtime = o.Mtime.Format(time.RFC3339Nano)
+    }
+
+    return fmt.Sprintf("{ mode: 0%03o(%d), properties: %v, kind: '%s', target: '%s', mtime: %s, uid: %s, gid: %s }",
+        o.Mode, o.Mode, o.Properties, o.Kind, o.Target, mtime, owner(o.Uid), owner(o.Gid))
+}
+
+
+// Convert asset Unix mode bits to the os.FileMode.
+func (o StorageAssetOpts) FileMode() os.FileMode {
+    mode := os.FileMode(o.Mode).Perm()
+
+    if o.Mode & 0o4000 != 0 {
+        mode |= os.ModeSetuid
+    }
+    if o.Mode & 0o2000 != 0 {
+        mode |= os.ModeSetgid
+    }
+    if o.Mode & 0o1000 != 0 {
+        mode |= os.ModeSticky
+    }
+
+    return mode
+}
+
+
+// Convert os.FileMode to the asset Unix mode bits.
+func AssetMode(mode os.FileMode) int {
+    bits := int(mode.Perm())
+
+    if mode & os.ModeSetuid != 0 {
+        bits |= 0o4000
+    }
+    if mode & os.ModeSetgid != 0 {
+        bits |= 0o2000
+    }
+    if mode & os.ModeSticky != 0 {
+        bits |= 0o1000
+    }
+
+    return bits
+}
+
+
+// Returns true if the asset is a regular file.
+func (o StorageAssetOpts) IsFile() bool {
+    return len(o.Kind) == 0
+}
+
+
+//  Checksum of the non file asset. Used instead of the content checksum
+// for symlinks and directories.
+func (o StorageAssetOpts) KindChecksum() string {
+    return fmt.Sprintf("%x", sha256.Sum256([]byte(o.Kind + ":" + o.Target)))
+}
+
+
+//  Check and normalize asset options: 'file' kind is replaced by the
+// empty one, symlinks always have 0777 mode, mtime is converted to UTC.
+// Returns error wrapping BAD_ASSET_OPTS for the unknown kinds, the bad
+// symlink targets, mode bits or owner ids.
+func (o StorageAssetOpts) Normalize() (StorageAssetOpts, error) {
+
+    if o.Mode & ^AssetModeMask != 0 || o.Mode < 0 {
+        return o, fmt.Errorf("Bad mode: 0%o! Err: %w", o.Mode, BAD_ASSET_OPTS)
+    }
+
+    if (o.Uid != nil && *o.Uid < 0) || (o.Gid != nil && *o.Gid < 0) {
+        return o, fmt.Errorf("Negative owner id! Err: %w", BAD_ASSET_OPTS)
+    }
+
+    if o.Mtime != nil {
+        mtime := o.Mtime.UTC()
+        o.Mtime = &mtime
+    }
+
+    if o.Kind == AssetKindFile {
+        o.Kind = ""
+    }
+
+    switch o.Kind {
+    case "", AssetKindDir:
+        if len(o.Target) > 0 {
+            return o, fmt.Errorf("Target is allowed only for symlinks! Err: %w", BAD_ASSET_OPTS)
+        }
+    case AssetKindSymlink:
+        if len(o.Target) == 0 || strings.IndexByte(o.Target, 0) >= 0 {
+            return o, fmt.Errorf("Bad symlink target: %q! Err: %w", o.Target, BAD_ASSET_OPTS)
+        }
+        o.Mode = 0o777
+    default:
+        return o, fmt.Errorf("Unknown asset kind: '%s'! Err: %w", o.Kind, BAD_ASSET_OPTS)
+    }
+
+    return o, nil
+}
+
+
+// Returns true if the asset has all properties from the filter with
+// the same values.
+func (o StorageAssetOpts) Match(filter StorageAssetProperties) bool {
+    for k, v := range filter {
+        if value, ok := o.Properties[k]; !ok || value != v {
+            return false
+        }
+    }
+    return true
 }
 
 
 // Helper for reading storage asset. Wraps implementation
 // specific 'io.Reader' and 'io.Closer' instances. Also
-// provides assess to asset options.
+// provides assess to asset options. If the implementation
+// specific reader is an 'io.ReadSeeker', the asset can be
+// read partially.
 type StorageAssetReader struct {
     io.Reader
     io.Closer
@@ -29,6 +178,13 @@ type StorageAssetReader struct {
 }
 
 
+// Get seekable asset reader if it is supported by implementation.
+func (sar *StorageAssetReader) ReadSeeker() (io.ReadSeeker, bool) {
+    rs, ok := sar.Reader.(io.ReadSeeker)
+    return rs, ok
+}
+
+
 func (sar *StorageAssetReader) Close() error {
     if sar.Closer == nil {
         return nil
@@ -42,6 +198,22 @@ func (sar *StorageAssetReader) Close() error {
 type StorageOpsCallback = func(Path, StorageAssetOpts) bool
 
 
+// Options for the StorageOps.RangeFrom method.
+type StorageRangeOpts struct {
+    // Only assets with the prefix will be enumerated.
+    Prefix  Path
+
+    // Only assets with the path lexically greater will be enumerated.
+    After   Path
+}
+
+
+// Check if the path is in range.
+func (o StorageRangeOpts) Contains(path Path) bool {
+    return strings.HasPrefix(path, o.Prefix) && path > o.After
+}
+
+
 // Storage operations.
 type StorageOps interface {
 
@@ -61,9 +233,40 @@ type StorageOps interface {
     // what asset is not created.
     CreateAsset(*Storage, Path, *StorageAssetReader) error
 
+    // Must create or replace storage asset if the preconditions are
+    // satisfied. The precondition check and asset replacement must be
+    // atomic. If the preconditions are not satisfied, error wrapping
+    // PRECONDITION_FAILED must be returned. On success returns the new
+    // asset ETag (the content checksum).
+    WriteAsset(*Storage, Path, *StorageAssetReader, StorageAssetCond) (string, error)
+
     // Open and get reader for existing asset.
     ReadAsset(*Storage, Path) (*StorageAssetReader, error)
 
+    // Get existing asset information. If the asset is not exist, error
+    // wrapping ASSET_NOT_EXIST must be returned.
+    StatAsset(*Storage, Path) (*StorageAssetInfo, error)
+
+    // Must remove existing asset from storage and release all releated
+    // resources. If the asset is not exist, error wrapping ASSET_NOT_EXIST
+    // must be returned. Non 'nil' result means what asset is not removed.
+    DeleteAsset(*Storage, Path) error
+
+    // Must atomically move existing asset to the new Path inside the same
+    // storage. If the source asset is not exist, error wrapping
+    // ASSET_NOT_EXIST must be returned. If the destination asset already
+    // exist, error wrapping ASSET_EXIST must be returned.
+    MoveAsset(*Storage, Path, Path) error
+
     // Enumerates assets existing in storage.
     Range(*Storage, StorageOpsCallback)
+
+    // Calculate storage usage statistics.
+    Stats(*Storage) (StorageStats, error)
+
+    // Enumerates assets existing in storage in lexical path order,
+    // restricted by the options. Memory and hashed storages keep the
+    // sorted paths index (see PathIndex), so the enumeration stopped by
+    // the callback costs only the enumerated assets.
+    RangeFrom(*Storage, StorageRangeOpts, StorageOpsCallback)
 }
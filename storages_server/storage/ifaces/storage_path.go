@@ -0,0 +1,61 @@
+package storage_ifaces
+
+import (
+    "fmt"
+    "path"
+    "strings"
+    "unicode/utf8"
+)
+
+
+const (
+    // Max asset path length in bytes.
+    MaxPathLen          = 4096
+
+    // Max asset path element (file name) length in bytes.
+    MaxPathElementLen   = 255
+)
+
+
+//  Get canonical asset path. Backslashes are treated as separators,
+// empty and '.' elements are dropped and '..' elements are resolved.
+// Returns error wrapping BAD_PATH for absolute paths, paths escaping the
+// storage root, paths with NUL bytes, invalid UTF-8 or overlong names.
+func CleanPath(p Path) (Path, error) {
+
+    if strings.IndexByte(p, 0) >= 0 {
+        return "", fmt.Errorf("Asset path: %q contains NUL byte! Err: %w", p, BAD_PATH)
+    }
+
+    if !utf8.ValidString(p) {
+        return "", fmt.Errorf("Asset path: %q is not valid UTF-8! Err: %w", p, BAD_PATH)
+    }
+
+    p = strings.ReplaceAll(p, "\\", "/")
+
+    if strings.HasPrefix(p, "/") {
+        return "", fmt.Errorf("Asset path: %q is absolute! Err: %w", p, BAD_PATH)
+    }
+
+    cleaned := path.Clean(p)
+
+    if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+        return "", fmt.Errorf("Asset path: %q escapes storage root! Err: %w", p, BAD_PATH)
+    }
+
+    if cleaned == "." {
+        return "", fmt.Errorf("Empty asset path: %q! Err: %w", p, BAD_PATH)
+    }
+
+    if len(cleaned) > MaxPathLen {
+        return "", fmt.Errorf("Asset path: %q is too long! Err: %w", p, BAD_PATH)
+    }
+
+    for _, name := range strings.Split(cleaned, "/") {
+        if len(name) > MaxPathElementLen {
+            return "", fmt.Errorf("Asset path: %q element: %q is too long! Err: %w", p, name, BAD_PATH)
+        }
+    }
+
+    return cleaned, nil
+}
@@ -0,0 +1,108 @@
+package storage_ifaces
+
+import (
+    "sort"
+    "strings"
+    "sync"
+)
+
+
+// Max paths copied from the index at once while the range is enumerated.
+const pathIndexChunk = 256
+
+
+//  Sorted set of the asset paths. Lets the storages keeping assets in maps
+// enumerate the range in lexical order without sorting the whole storage.
+type PathIndex struct {
+    lock    sync.RWMutex
+    paths   []Path
+}
+
+
+// Replace the index content. Paths must be unique.
+func (x *PathIndex) Reset(paths []Path) {
+    sorted := append([]Path(nil), paths...)
+    sort.Strings(sorted)
+
+    x.lock.Lock()
+    defer x.lock.Unlock()
+
+    x.paths = sorted
+}
+
+
+func (x *PathIndex) Insert(path Path) {
+    x.lock.Lock()
+    defer x.lock.Unlock()
+
+    i := sort.SearchStrings(x.paths, path)
+    if i < len(x.paths) && x.paths[i] == path {
+        return
+    }
+
+    x.paths = append(x.paths, "")
+    copy(x.paths[i + 1:], x.paths[i:])
+    x.paths[i] = path
+}
+
+
+func (x *PathIndex) Delete(path Path) {
+    x.lock.Lock()
+    defer x.lock.Unlock()
+
+    i := sort.SearchStrings(x.paths, path)
+    if i == len(x.paths) || x.paths[i] != path {
+        return
+    }
+
+    x.paths = append(x.paths[:i], x.paths[i + 1:]...)
+}
+
+
+// Get up to n paths in range in lexical order. Zero n means no limit.
+func (x *PathIndex) Next(opts StorageRangeOpts, n int) []Path {
+    x.lock.RLock()
+    defer x.lock.RUnlock()
+
+    from := opts.Prefix
+    if opts.After > from {
+        from = opts.After
+    }
+
+    i := sort.SearchStrings(x.paths, from)
+    if i < len(x.paths) && x.paths[i] == opts.After {
+        i++
+    }
+
+    paths := make([]Path, 0, 16)
+    for ; i < len(x.paths) && (n <= 0 || len(paths) < n); i++ {
+        if !strings.HasPrefix(x.paths[i], opts.Prefix) {
+            break
+        }
+        paths = append(paths, x.paths[i])
+    }
+
+    return paths
+}
+
+
+//  Enumerate paths in range in lexical order. The index is not locked
+// while the callback runs, so the paths modified meanwhile may be
+// enumerated or not.
+func (x *PathIndex) Range(opts StorageRangeOpts, callback func(Path) bool) {
+    for {
+        paths := x.Next(opts, pathIndexChunk)
+
+        for _, path := range paths {
+            if !callback(path) {
+                return
+            }
+        }
+
+        if len(paths) < pathIndexChunk {
+            return
+        }
+
+        opts.After = paths[len(paths) - 1]
+    }
+}
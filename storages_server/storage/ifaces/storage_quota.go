@@ -0,0 +1,255 @@
+package storage_ifaces
+
+import (
+    "fmt"
+    "io"
+    "sync"
+)
+
+
+// Storage quota. Zero limit means no limit.
+type StorageQuota struct {
+    // Max sum of assets sizes.
+    MaxSize     int64   `json:"max_size,omitempty"`
+
+    // Max assets count.
+    MaxAssets   int64   `json:"max_assets,omitempty"`
+}
+
+
+func (q StorageQuota) String() string {
+    return fmt.Sprintf("{ max-size: %d, max-assets: %d }", q.MaxSize, q.MaxAssets)
+}
+
+
+func (q StorageQuota) Empty() bool {
+    return q.MaxSize <= 0 && q.MaxAssets <= 0
+}
+
+
+//  Storage usage. Committed usage is tracked once loaded (see TrackUsage),
+// reserved usage is the data being written to the storage, but not yet
+// committed.
+type quotaUsage struct {
+    sync.Mutex
+
+    size    int64
+    assets  int64
+
+    loaded          bool
+    committedSize   int64
+    committedAssets int64
+}
+
+
+// Reader aborting asset data streaming once the storage quota is exceeded.
+type quotaReader struct {
+    reader  io.Reader
+    storage *Storage
+    quota   *StorageQuota
+
+    // Size of the replaced asset, released on commit.
+    replaced    int64
+    read        int64
+    asset       bool
+}
+
+
+func (qr *quotaReader) Read(p []byte) (int, error) {
+    n, err := qr.reader.Read(p)
+    if n <= 0 {
+        return n, err
+    }
+
+    if qerr := qr.account(int64(n)); qerr != nil {
+        return n, qerr
+    }
+
+    return n, err
+}
+
+
+//  Account asset data of the given size. Used directly if the data is
+// not read through the reader (e.g. linked vault objects).
+func (qr *quotaReader) account(n int64) error {
+    usage := &qr.storage.usage
+
+    usage.Lock()
+    defer usage.Unlock()
+
+    usage.size += n
+    qr.read += n
+
+    quota := qr.quota
+    if quota == nil || quota.MaxSize <= 0 {
+        return nil
+    }
+
+    if qr.read > quota.MaxSize {
+        return fmt.Errorf("Asset size exceeds storage size quota: %d! Err: %w", quota.MaxSize, ASSET_TOO_LARGE)
+    }
+
+    if usage.committedSize + usage.size - qr.replaced > quota.MaxSize {
+        return fmt.Errorf("Storage size quota: %d exceeded! Err: %w", quota.MaxSize, QUOTA_EXCEEDED)
+    }
+
+    return nil
+}
+
+
+//  Release data accounted by the reader. If the write is committed, the
+// data is moved to the committed usage.
+func (qr *quotaReader) release(committed bool) {
+    usage := &qr.storage.usage
+
+    usage.Lock()
+    defer usage.Unlock()
+
+    usage.size -= qr.read
+    if qr.asset {
+        usage.assets--
+    }
+
+    if committed && usage.loaded {
+        usage.committedSize += qr.read - qr.replaced
+        if qr.asset {
+            usage.committedAssets++
+        }
+    }
+}
+
+
+//  Start tracking the committed storage usage, so the quota is checked
+// without computing the storage stats on every write. Waits for the in
+// progress modifications.
+func (s *Storage) TrackUsage() error {
+    s.sealLock.Lock()
+    defer s.sealLock.Unlock()
+
+    s.usage.Lock()
+    loaded := s.usage.loaded
+    s.usage.Unlock()
+
+    if loaded {
+        return nil
+    }
+
+    stats, err := s.Ops.Stats(s)
+    if err != nil {
+        return err
+    }
+
+    s.usage.Lock()
+    defer s.usage.Unlock()
+
+    s.usage.loaded          = true
+    s.usage.committedSize   = stats.Size
+    s.usage.committedAssets = stats.Assets
+
+    return nil
+}
+
+
+//  Optional StorageOps extension implemented by the storages computing
+// the asset ETag on stat. Quota accounting needs only the asset size.
+type StorageAssetSizer interface {
+    // Must return the asset content size, zero for assets without
+    // content, or error wrapping ASSET_NOT_EXIST.
+    AssetSize(*Storage, Path) (int64, error)
+}
+
+
+//  Get asset size without computing its ETag if the storage supports it.
+// Must be called under the seal lock.
+func (s *Storage) assetSize(path Path) (int64, error) {
+    if sizer, ok := s.Ops.(StorageAssetSizer); ok {
+        return sizer.AssetSize(s, path)
+    }
+
+    info, err := s.Ops.StatAsset(s, path)
+    if err != nil {
+        return 0, err
+    }
+
+    return info.Size, nil
+}
+
+
+//  Wrap asset reader with the quota checking one. Returned release
+// function must be called when the asset write is finished, with 'true'
+// if the write is committed. Concurrent writes of the same new asset may
+// be counted twice against the assets quota.
+func (s *Storage) reserveQuota(path Path, r *StorageAssetReader) (*StorageAssetReader, func(bool), error) {
+
+    quota := s.GetQuota()
+    if quota != nil && quota.Empty() {
+        quota = nil
+    }
+
+    s.usage.Lock()
+    loaded := s.usage.loaded
+    s.usage.Unlock()
+
+    if quota == nil && !loaded {
+        return r, func(bool) {}, nil
+    }
+
+    // Usage is tracked once the quota is set, so here is only the storage
+    // restored without the tracking.
+    if !loaded {
+        return nil, nil, fmt.Errorf("Storage: %s usage is not tracked!", s.Id.Id)
+    }
+
+    qr := &quotaReader{
+        reader:     r.Reader,
+        storage:    s,
+        quota:      quota,
+        asset:      true,
+    }
+
+    // Replaced asset size will be released
+    if size, err := s.assetSize(path); err == nil {
+        qr.replaced = size
+        qr.asset = false
+    }
+
+    s.usage.Lock()
+    defer s.usage.Unlock()
+
+    if qr.asset {
+        if quota != nil && quota.MaxAssets > 0 && s.usage.committedAssets + s.usage.assets >= quota.MaxAssets {
+            return nil, nil, fmt.Errorf("Storage assets quota: %d exceeded! Err: %w", quota.MaxAssets, QUOTA_EXCEEDED)
+        }
+        s.usage.assets++
+    }
+
+    return &StorageAssetReader{Reader: qr, Closer: r.Closer, Opts: r.Opts}, qr.release, nil
+}
+
+
+//  Get size of the asset to be deleted, if the usage is tracked. Returned
+// function must be called when the asset delete is finished, with 'true'
+// if the asset is deleted.
+func (s *Storage) releaseQuota(path Path) func(bool) {
+    s.usage.Lock()
+    loaded := s.usage.loaded
+    s.usage.Unlock()
+
+    if !loaded {
+        return func(bool) {}
+    }
+
+    size, _ := s.assetSize(path)
+
+    return func(deleted bool) {
+        if !deleted {
+            return
+        }
+
+        s.usage.Lock()
+        defer s.usage.Unlock()
+
+        s.usage.committedSize -= size
+        s.usage.committedAssets--
+    }
+}
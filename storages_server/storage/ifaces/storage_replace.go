@@ -0,0 +1,66 @@
+package storage_ifaces
+
+import (
+    "fmt"
+)
+
+
+//  Copy all assets with their opts to the target storage. Not thread
+// safe against the storage modifications.
+func (s *Storage) copyAssets(target *Storage) error {
+
+    paths := make([]Path, 0, 100)
+
+    s.Ops.RangeFrom(s, StorageRangeOpts{}, func(path Path, opts StorageAssetOpts) bool {
+        paths = append(paths, path)
+        return true
+    })
+
+    for _, path := range paths {
+        r, err := s.Ops.ReadAsset(s, path)
+        if err != nil {
+            return fmt.Errorf("Read asset: %s error: %w", path, err)
+        }
+
+        err = target.Ops.CreateAsset(target, path, r)
+        r.Close()
+
+        if err != nil {
+            return fmt.Errorf("Copy asset: %s error: %w", path, err)
+        }
+    }
+
+    return nil
+}
+
+
+//  Copy all assets to the initialized target storage and replace the
+// storage implementation by the target one. Storage modifications are
+// blocked while copying. Returns the replaced storage implementation
+// what must be destroyed by the caller. Sealed storages can't be replaced,
+// because the seal digest is computed by the replaced implementation, the
+// error wrapping SEALED is returned.
+func (s *Storage) Replace(target *Storage) (*Storage, error) {
+    s.sealLock.Lock()
+    defer s.sealLock.Unlock()
+
+    if s.Sealed != nil {
+        return nil, fmt.Errorf("Storage: %s is sealed! Err: %w", s.Id.Id, SEALED)
+    }
+
+    if err := s.copyAssets(target); err != nil {
+        return nil, err
+    }
+
+    replaced := &Storage{
+        Id:     s.Id,
+        Type:   s.Type,
+        Root:   s.Root,
+        Parent: s.Parent,
+        Ops:    s.Ops,
+    }
+
+    s.Ops, s.Type, s.Root = target.Ops, target.Type, target.Root
+
+    return replaced, nil
+}
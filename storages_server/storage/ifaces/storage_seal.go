@@ -0,0 +1,82 @@
+package storage_ifaces
+
+import (
+    "fmt"
+    "time"
+)
+
+
+// Storage seal record.
+type StorageSeal struct {
+    // Seal time.
+    Time    time.Time   `json:"time"`
+
+    // Storage root digest if supported by the storage implementation.
+    Digest  string      `json:"digest,omitempty"`
+}
+
+
+//  Optional StorageOps interface. Storage implementations what can
+// compute root digest over the storage content.
+type StorageDigester interface {
+    Digest(*Storage) (string, error)
+}
+
+
+// Check if storage is sealed.
+func (s *Storage) IsSealed() bool {
+    s.sealLock.RLock()
+    defer s.sealLock.RUnlock()
+
+    return s.Sealed != nil
+}
+
+
+//  Flip storage to read-only mode. Waits for the in progress
+// modifications and computes root digest if the storage implementation
+// supports it.
+func (s *Storage) Seal() (*StorageSeal, error) {
+    s.sealLock.Lock()
+    defer s.sealLock.Unlock()
+
+    if s.Sealed != nil {
+        return nil, fmt.Errorf("Storage: %s already sealed! Err: %w", s.Id.Id, SEALED)
+    }
+
+    seal := &StorageSeal{Time: time.Now().UTC()}
+
+    if digester, ok := s.Ops.(StorageDigester); ok {
+        digest, err := digester.Digest(s)
+        if err != nil {
+            return nil, err
+        }
+        seal.Digest = digest
+    }
+
+    s.Sealed = seal
+
+    return seal, nil
+}
+
+
+//  Lock storage implementation for reading. Returned unlock function
+// must be called when the storage implementation call is finished.
+func (s *Storage) lockRead() func() {
+    s.sealLock.RLock()
+    return s.sealLock.RUnlock
+}
+
+
+//  Lock storage for modification. Returns error wrapping SEALED if
+// the storage is sealed. Returned unlock function must be called when
+// the modification is finished.
+func (s *Storage) lockModify() (func(), error) {
+    s.sealLock.RLock()
+
+    if s.Sealed != nil {
+        s.sealLock.RUnlock()
+        return nil, fmt.Errorf("Storage: %s is sealed! Err: %w", s.Id.Id, SEALED)
+    }
+
+    return s.sealLock.RUnlock, nil
+}
@@ -0,0 +1,34 @@
+package storage_ifaces
+
+import "time"
+
+
+// Storage usage statistics.
+type StorageStats struct {
+    // Assets count.
+    Assets      int64   `json:"assets"`
+
+    // Sum of assets sizes.
+    Size        int64   `json:"size"`
+
+    // Sum of unique vault objects sizes referenced by the storage
+    // (HashedFilesystemStorage only).
+    VaultSize   int64   `json:"vault_size,omitempty"`
+}
+
+
+// Storage summary.
+type StorageSummary struct {
+    StorageId
+
+    Name        string      `json:"name,omitempty"`
+    Aliases     []string    `json:"aliases,omitempty"`
+
+    Type        string      `json:"type"`
+    Created     time.Time   `json:"created"`
+    Expires     *time.Time  `json:"expires,omitempty"`
+    Quota       *StorageQuota   `json:"quota,omitempty"`
+    Sealed      *StorageSeal    `json:"sealed,omitempty"`
+
+    StorageStats
+}
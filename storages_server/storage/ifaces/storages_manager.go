@@ -2,6 +2,7 @@ package storage_ifaces
 
 import (
     "encoding/json"
+    "time"
 )
 
 type StoragesManagerOpts struct {
@@ -20,9 +21,19 @@ type StoragesManagerOpts struct {
     VaultMode           int
     VaultDepth          int
 
+    // Vault objects digest algorithm, DefaultVaultAlgorithm if empty.
+    VaultAlgorithm      string
+
+    // Additional digests calculated for the hashed storages assets.
+    SecondaryDigests    []string
+
     // Buffers manager parameters
     BuffersRoot         Path
     BuffersMode         int
+
+    // Interval between expired storages checks. Zero disables
+    // expired storages reaping.
+    ReaperInterval      time.Duration
 }
 
 
@@ -35,6 +46,29 @@ func (o StoragesManagerOpts) String() string {
 }
 
 
+// Get vault objects digest algorithm.
+func (o StoragesManagerOpts) VaultDigestAlgorithm() string {
+    if len(o.VaultAlgorithm) == 0 {
+        return DefaultVaultAlgorithm
+    }
+    return o.VaultAlgorithm
+}
+
+
+// New storage options, see StoragesManager.CreateWithOpts.
+type StorageCreateOpts struct {
+    // Unique storage name. Empty name means unnamed storage.
+    Name    string
+
+    // Storage expiration time. Zero time means that the storage never
+    // expires.
+    Expires time.Time
+
+    // Storage quota. Empty quota means unlimited storage.
+    Quota   StorageQuota
+}
+
+
 type StoragesManager interface {
     Opts() StoragesManagerOpts
     Vault() Vault
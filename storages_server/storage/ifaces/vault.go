@@ -1,6 +1,9 @@
 package storage_ifaces
 
-import "os"
+import (
+    "os"
+    "strings"
+)
 
 
 type VaultAsset struct {
@@ -9,6 +12,67 @@ type VaultAsset struct {
 }
 
 
+// Reference to vault object from storage asset.
+type VaultRef struct {
+    StorageId   StorageId   `json:"sid"`
+    Path        Path        `json:"path"`
+}
+
+
+// Vault objects digest algorithm used by default and for the bare hex ids.
+const DefaultVaultAlgorithm = DigestSha256
+
+
+//  Make vault object id from the digest algorithm and hex encoded digest:
+// "<algorithm>:<digest>".
+func MakeVaultObject(algorithm string, digest string) string {
+    return algorithm + ":" + digest
+}
+
+
+//  Parse vault object id. Bare hex ids of the vaults created before the
+// algorithms became selectable are treated as sha256 ones.
+func ParseVaultObject(object string) (algorithm string, digest string, ok bool) {
+    algorithm, digest = DefaultVaultAlgorithm, object
+
+    if idx := strings.IndexByte(object, ':'); idx >= 0 {
+        algorithm, digest = object[:idx], object[idx + 1:]
+    }
+
+    return algorithm, digest, IsDigest(algorithm, digest)
+}
+
+
+// Check if the string is well formed vault object id.
+func IsVaultObject(object string) bool {
+    _, _, ok := ParseVaultObject(object)
+    return ok
+}
+
+
+// Get max length of the canonical vault object id over known algorithms.
+func MaxVaultObjectLen() int {
+    max := 0
+    for algorithm, newHash := range digestHashes {
+        if l := len(MakeVaultObject(algorithm, "")) + newHash().Size() * 2; l > max {
+            max = l
+        }
+    }
+    return max
+}
+
+
+//  Get canonical vault object id (with the algorithm prefix). Malformed
+// ids are returned as is.
+func CanonicalVaultObject(object string) string {
+    algorithm, digest, ok := ParseVaultObject(object)
+    if !ok {
+        return object
+    }
+    return MakeVaultObject(algorithm, digest)
+}
+
+
 type VaultFile struct {
     File *os.File
     Asset *VaultAsset
@@ -26,6 +90,16 @@ func (v* VaultFile) Close() error {
 type Vault interface {
     Unref(*Storage, VaultAsset)
     Put(*Storage, VaultAsset, Path) error
+    Link(*Storage, VaultAsset) error
+    Rename(*Storage, VaultAsset, Path) error
     OpenObject(VaultAsset) (*VaultFile, error)
+    StatObject(VaultAsset) (os.FileInfo, error)
     CloseObject(*VaultAsset, *VaultFile)
+
+    // Get object references sorted by storage id and path.
+    Refs(object string) []VaultRef
+
+    //  Check the vault objects and the refs database against the
+    // references of all storages assets.
+    Check([]VaultObjectRef, VaultCheckOpts) (*VaultCheckReport, error)
 }
@@ -0,0 +1,77 @@
+package storage_ifaces
+
+import (
+    "fmt"
+)
+
+
+// Vault check options.
+type VaultCheckOpts struct {
+    // Replace the refs database by the references rebuilt from the
+    // storages assets.
+    Repair  bool    `json:"repair"`
+
+    // Remove orphaned objects. Implies Repair, so the refs database never
+    // references removed objects.
+    Gc      bool    `json:"gc"`
+}
+
+
+// Reference to vault object from storage asset with the object id.
+type VaultObjectRef struct {
+    Object  string  `json:"object"`
+    VaultRef
+}
+
+
+func (r VaultObjectRef) String() string {
+    return fmt.Sprintf("{ object: %s, sid: %s, path: %s }", r.Object, r.StorageId.Id, r.Path)
+}
+
+
+// Vault check results.
+type VaultCheckReport struct {
+    // Count of the object files in the vault.
+    Objects     int                 `json:"objects"`
+
+    // Count of the storages assets references to the vault objects.
+    Refs        int                 `json:"refs"`
+
+    // Objects not referenced by any storage asset.
+    Orphaned    []string            `json:"orphaned"`
+
+    // Storages assets references to the objects without files.
+    Missing     []VaultObjectRef    `json:"missing"`
+
+    // Refs database references without the storages assets.
+    Dangling    []VaultObjectRef    `json:"dangling"`
+
+    // Storages assets references absent in the refs database.
+    Unindexed   []VaultObjectRef    `json:"unindexed"`
+
+    // Set if the refs database is replaced by the rebuilt one.
+    Repaired    bool                `json:"repaired"`
+
+    // Removed orphaned objects.
+    Removed     []string            `json:"removed"`
+
+    //  Orphaned objects opened by readers, these are removed when the
+    // last reader is closed.
+    Deferred    []string            `json:"deferred"`
+}
+
+
+// Returns true if no inconsistencies are found.
+func (r *VaultCheckReport) Clean() bool {
+    return len(r.Orphaned) == 0 && len(r.Missing) == 0 && len(r.Dangling) == 0 && len(r.Unindexed) == 0
+}
+
+
+//  Optional StorageOps extension implemented by the storages keeping
+// assets content in the vault, used to check the vault consistency.
+type StorageVaultUser interface {
+    //  Must return the vault objects references of all storage assets and
+    // block the storage modifications until the returned unlock function
+    // is called.
+    LockVaultAssets(*Storage) ([]VaultAsset, func())
+}
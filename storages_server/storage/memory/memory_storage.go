@@ -5,13 +5,19 @@ import (
     "bytes"
     "fmt"
     "sync"
+    "time"
+    "crypto/sha256"
 
     "../ifaces"
 )
 
 
 type MemoryStorage struct {
+    // Serializes assets modifications
+    lock   sync.Mutex
+
     assets sync.Map
+    index  storage_ifaces.PathIndex
 }
 
 
@@ -19,6 +25,29 @@ type asset struct {
     path    storage_ifaces.Path
     opts    storage_ifaces.StorageAssetOpts
     payload []byte
+    etag    string
+
+    created     time.Time
+    modified    time.Time
+}
+
+
+func (a *asset) info() *storage_ifaces.StorageAssetInfo {
+    info := &storage_ifaces.StorageAssetInfo{
+        StorageAssetOpts:   a.opts,
+        Path:               a.path,
+        Size:               int64(len(a.payload)),
+        ETag:               a.etag,
+        Created:            a.created,
+        Modified:           a.modified,
+    }
+
+    // File ETag is the content sha256.
+    if a.opts.IsFile() {
+        info.Digests = storage_ifaces.StorageAssetDigests{storage_ifaces.DigestSha256: a.etag}
+    }
+
+    return info
 }
 
 
@@ -43,29 +72,62 @@ func (ms *MemoryStorage) CreateAsset(s *storage_ifaces.Storage, path storage_ifa
     memoryLog.Printf("%s: Create asset: %s opts: %s", s.Name(), path, r.Opts.String())
 
     if _, ok := ms.assets.Load(path); ok {
-        return fmt.Errorf("Asset: '%s' already exist!", path)
+        return fmt.Errorf("Asset: '%s' already exist! Err: %w", path, storage_ifaces.ASSET_EXIST)
     }
 
-    asset := &asset {
+    _, err := ms.WriteAsset(s, path, r, storage_ifaces.StorageAssetCond{IfNoneMatch: "*"})
+    return err
+}
+
+
+func (ms *MemoryStorage) WriteAsset(s *storage_ifaces.Storage, path storage_ifaces.Path, r *storage_ifaces.StorageAssetReader, cond storage_ifaces.StorageAssetCond) (string, error) {
+
+    memoryLog.Printf("%s: Write asset: %s opts: %s cond: %s", s.Name(), path, r.Opts.String(), cond.String())
+
+    newAsset := &asset {
         path: path,
         opts: r.Opts,
     }
 
-    var data bytes.Buffer
+    if r.Opts.IsFile() {
+        var data bytes.Buffer
+
+        w := io.Writer(&data)
 
-    w := io.Writer(&data)
+        _, err := io.Copy(w, r)
+        if err != nil {
+            memoryLog.Printf("%s: Write asset copy error: %s", s.Name(), err)
+            return "", err
+        }
 
-    _, err := io.Copy(w, r)
-    if err != nil {
-        memoryLog.Printf("%s: Create asset copy error: %s", s.Name(), err)
-        return err
+        newAsset.payload = data.Bytes()
+        newAsset.etag    = fmt.Sprintf("%x", sha256.Sum256(newAsset.payload))
+    } else {
+        // Symlinks and directories have no content.
+        newAsset.etag    = r.Opts.KindChecksum()
     }
 
-    asset.payload = data.Bytes()
+    ms.lock.Lock()
+    defer ms.lock.Unlock()
 
-    ms.assets.Store(path, asset)
+    newAsset.modified = time.Now().UTC()
+    newAsset.created  = newAsset.modified
 
-    return nil
+    exist, etag := false, ""
+    if iasset, ok := ms.assets.Load(path); ok {
+        exist, etag = true, iasset.(*asset).etag
+        newAsset.created = iasset.(*asset).created
+    }
+
+    if err := cond.Check(path, exist, etag); err != nil {
+        memoryLog.Printf("%s: Write asset error: %s", s.Name(), err)
+        return "", err
+    }
+
+    ms.assets.Store(path, newAsset)
+    ms.index.Insert(path)
+
+    return newAsset.etag, nil
 }
 
 
@@ -75,7 +137,7 @@ func (ms *MemoryStorage) ReadAsset(s *storage_ifaces.Storage, path storage_iface
 
     iasset, ok := ms.assets.Load(path)
     if !ok {
-        return nil, fmt.Errorf("Attempt to read non existing asset: %s", path)
+        return nil, fmt.Errorf("Attempt to read non existing asset: %s. Err: %w", path, storage_ifaces.ASSET_NOT_EXIST)
     }
 
     asset, ok := iasset.(*asset)
@@ -90,6 +152,80 @@ func (ms *MemoryStorage) ReadAsset(s *storage_ifaces.Storage, path storage_iface
 }
 
 
+func (ms *MemoryStorage) StatAsset(s *storage_ifaces.Storage, path storage_ifaces.Path) (*storage_ifaces.StorageAssetInfo, error) {
+
+    memoryLog.Printf("%s: Stat asset: %s", s.Name(), path)
+
+    iasset, ok := ms.assets.Load(path)
+    if !ok {
+        return nil, fmt.Errorf("Attempt to stat non existing asset: %s. Err: %w", path, storage_ifaces.ASSET_NOT_EXIST)
+    }
+
+    asset, ok := iasset.(*asset)
+    if !ok {
+        memoryLog.Panic("Unexpected value in ms.assets!")
+    }
+
+    return asset.info(), nil
+}
+
+
+func (ms *MemoryStorage) DeleteAsset(s *storage_ifaces.Storage, path storage_ifaces.Path) error {
+
+    memoryLog.Printf("%s: Delete asset: %s", s.Name(), path)
+
+    ms.lock.Lock()
+    defer ms.lock.Unlock()
+
+    if _, ok := ms.assets.Load(path); !ok {
+        return fmt.Errorf("Attempt to delete non existing asset: %s. Err: %w", path, storage_ifaces.ASSET_NOT_EXIST)
+    }
+
+    ms.assets.Delete(path)
+    ms.index.Delete(path)
+
+    return nil
+}
+
+
+func (ms *MemoryStorage) MoveAsset(s *storage_ifaces.Storage, from storage_ifaces.Path, to storage_ifaces.Path) error {
+
+    memoryLog.Printf("%s: Move asset: %s to: %s", s.Name(), from, to)
+
+    ms.lock.Lock()
+    defer ms.lock.Unlock()
+
+    iasset, ok := ms.assets.Load(from)
+    if !ok {
+        return fmt.Errorf("Attempt to move non existing asset: %s. Err: %w", from, storage_ifaces.ASSET_NOT_EXIST)
+    }
+
+    src, ok := iasset.(*asset)
+    if !ok {
+        memoryLog.Panic("Unexpected value in ms.assets!")
+    }
+
+    dst := &asset {
+        path:       to,
+        opts:       src.opts,
+        payload:    src.payload,
+        etag:       src.etag,
+        created:    src.created,
+        modified:   src.modified,
+    }
+
+    if _, loaded := ms.assets.LoadOrStore(to, dst); loaded {
+        return fmt.Errorf("Attempt to move asset: %s to existing asset: %s. Err: %w", from, to, storage_ifaces.ASSET_EXIST)
+    }
+
+    ms.assets.Delete(from)
+    ms.index.Insert(to)
+    ms.index.Delete(from)
+
+    return nil
+}
+
+
 func (ms *MemoryStorage) Range(s *storage_ifaces.Storage, callback storage_ifaces.StorageOpsCallback) {
     ms.assets.Range(func(key, value interface{}) bool {
         path, ok := key.(storage_ifaces.Path)
@@ -105,3 +241,39 @@ func (ms *MemoryStorage) Range(s *storage_ifaces.Storage, callback storage_iface
         return callback(path, asset.opts)
     })
 }
+
+
+func (ms *MemoryStorage) RangeFrom(s *storage_ifaces.Storage, opts storage_ifaces.StorageRangeOpts, callback storage_ifaces.StorageOpsCallback) {
+    ms.index.Range(opts, func(path storage_ifaces.Path) bool {
+        value, ok := ms.assets.Load(path)
+        if !ok {
+            return true
+        }
+
+        asset, ok := value.(*asset)
+        if !ok {
+            memoryLog.Panic("Unexpected value type!")
+        }
+
+        return callback(path, asset.opts)
+    })
+}
+
+
+func (ms *MemoryStorage) Stats(s *storage_ifaces.Storage) (storage_ifaces.StorageStats, error) {
+    stats := storage_ifaces.StorageStats{}
+
+    ms.assets.Range(func(key, value interface{}) bool {
+        asset, ok := value.(*asset)
+        if !ok {
+            memoryLog.Panic("Unexpected value type!")
+        }
+
+        stats.Assets++
+        stats.Size += int64(len(asset.payload))
+
+        return true
+    })
+
+    return stats, nil
+}
@@ -3,6 +3,7 @@ package storage
 import (
     "./ifaces"
     "path/filepath"
+    "time"
 )
 
 func DefaultStoragesOpts() storage_ifaces.StoragesManagerOpts {
@@ -13,17 +14,23 @@ func DefaultStoragesOpts() storage_ifaces.StoragesManagerOpts {
         DirsMode            : 0o700,
         VaultRoot           : ".vault",
         VaultMode           : 0o600,
+        VaultAlgorithm      : storage_ifaces.DefaultVaultAlgorithm,
         BuffersMode         : 0o600,
         BuffersRoot         : ".buffers",
+        ReaperInterval      : time.Minute,
     }
 }
 
 
+//  Options for the storages kept under the prefix. The expired storages
+// reaper is disabled, so the storages are reaped explicitly or the reaper
+// interval is set by the caller.
 func PrefixedStoragesOpts(prefix storage_ifaces.Path) storage_ifaces.StoragesManagerOpts {
     return storage_ifaces.StoragesManagerOpts{
         DefaultStorageType  : storage_ifaces.StorageMemory,
         DirsMode            : 0o700,
         VaultMode           : 0o600,
+        VaultAlgorithm      : storage_ifaces.DefaultVaultAlgorithm,
         BuffersMode         : 0o600,
         Metadata            : filepath.Join(prefix, "storages.json"),
         VaultRoot           : filepath.Join(prefix, "vault"),
@@ -3,6 +3,11 @@ package storage
 import (
     "os"
     "fmt"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/google/uuid"
 
     "./ifaces"
     "./filesystem"
@@ -16,6 +21,18 @@ type StoragesManager struct {
     storages    storagesMap
     vault       *vault.Vault
     buffers     *buffers.BuffersManager
+
+    //  Storages creation, conversion and destruction change the set of
+    // the vault users, so these are blocked while the vault is checked.
+    maintenance sync.RWMutex
+
+    // Ids of the storages being converted or destroyed.
+    busy        sync.Map
+
+    // Reaper stop request and completion.
+    stop        chan struct{}
+    stopped     chan struct{}
+    closeOnce   sync.Once
 }
 
 
@@ -26,6 +43,8 @@ func NewStoragesManager(opts storage_ifaces.StoragesManagerOpts) *StoragesManage
     sm := &StoragesManager{
         opts        : opts,
         storages    : makeStoragesMap(),
+        stop        : make(chan struct{}),
+        stopped     : make(chan struct{}),
         vault       : vault.NewVault(opts),
         buffers     : buffers.NewBuffersManager(storage_ifaces.BuffersManagerOpts{
             StorageRoot     : opts.BuffersRoot,
@@ -42,6 +61,12 @@ func NewStoragesManager(opts storage_ifaces.StoragesManagerOpts) *StoragesManage
 
     sm.reattachToStorages()
 
+    if sm.opts.ReaperInterval > 0 {
+        go sm.reaper(sm.opts.ReaperInterval)
+    } else {
+        close(sm.stopped)
+    }
+
     return sm
 }
 
@@ -72,6 +97,38 @@ func (sm *StoragesManager) Get(id storage_ifaces.StorageId) *storage_ifaces.Stor
 }
 
 
+// Get summaries of all existing storages sorted by creation time.
+func (sm *StoragesManager) ListStorages() []storage_ifaces.StorageSummary {
+
+    storages := make([]*storage_ifaces.Storage, 0, 100)
+
+    sm.storages.Range(func(id storage_ifaces.StorageId, s *storage_ifaces.Storage) bool {
+        storages = append(storages, s)
+        return true
+    })
+
+    summaries := make([]storage_ifaces.StorageSummary, 0, len(storages))
+
+    for _, s := range storages {
+        summary, err := s.Summary()
+        if err != nil {
+            storagesLog.Printf("%s: Storage statistics error: %s", s.Name(), err)
+        }
+
+        summaries = append(summaries, summary)
+    }
+
+    sort.Slice(summaries, func(i, j int) bool {
+        if !summaries[i].Created.Equal(summaries[j].Created) {
+            return summaries[i].Created.Before(summaries[j].Created)
+        }
+        return summaries[i].Id < summaries[j].Id
+    })
+
+    return summaries
+}
+
+
 func (sm *StoragesManager) reattachToStorages() {
 
     // Init storages from metadata
@@ -97,6 +154,12 @@ func (sm *StoragesManager) reattachToStorages() {
             storagesLog.Panicf("Can't initialize storage! Error: %s", err)
         }
 
+        if s.GetQuota() != nil {
+            if err := s.TrackUsage(); err != nil {
+                storagesLog.Panicf("Can't get storage usage! Error: %s", err)
+            }
+        }
+
         return true
     })
 
@@ -113,6 +176,43 @@ func (sm *StoragesManager) reattachToStorages() {
 
 func (sm *StoragesManager) Create(storageType storage_ifaces.StorageType) *storage_ifaces.Storage {
 
+    s, err := sm.CreateNamed(storageType, "")
+    if err != nil {
+        storagesLog.Panicf("Storage creation error: %s", err)
+    }
+
+    return s
+}
+
+
+//  Create new storage with unique name. The storage can be addressed by
+// the name everywhere where storage id is expected. Empty name means
+// unnamed storage.
+func (sm *StoragesManager) CreateNamed(storageType storage_ifaces.StorageType, name string) (*storage_ifaces.Storage, error) {
+    return sm.CreateWithOpts(storageType, storage_ifaces.StorageCreateOpts{Name: name})
+}
+
+
+//  Create new storage with the name, expiration time and quota. Options
+// are applied before the storage is stored, so it is never visible (or
+// persisted) without them.
+func (sm *StoragesManager) CreateWithOpts(storageType storage_ifaces.StorageType, opts storage_ifaces.StorageCreateOpts) (*storage_ifaces.Storage, error) {
+
+    name := opts.Name
+
+    sm.maintenance.RLock()
+    defer sm.maintenance.RUnlock()
+
+    if len(name) > 0 {
+        if err := storage_ifaces.CheckStorageName(name); err != nil {
+            return nil, err
+        }
+
+        if _, ok := sm.storages.Load(storage_ifaces.MakeStorageId(name)); ok {
+            return nil, fmt.Errorf("Name: '%s' already used! Err: %w", name, storage_ifaces.NAME_EXIST)
+        }
+    }
+
     ops, sType := sm.createOps(storageType, sm.Opts())
 
     if ops == nil {
@@ -124,37 +224,338 @@ func (sm *StoragesManager) Create(storageType storage_ifaces.StorageType) *stora
         Type    : sType,
         Ops     : ops,
         Id      : storage_ifaces.MakeNewStorageId(),
+        Created : time.Now().UTC(),
+
+        UniqueName  : name,
     }
 
     storagesLog.Printf("Created new storage: %s", s.Name())
 
+    if !opts.Expires.IsZero() {
+        expires := opts.Expires.UTC()
+        s.SetExpires(&expires)
+        storagesLog.Printf("Storage: %s expires at: %s", s.Name(), expires)
+    }
+
+    if !opts.Quota.Empty() {
+        quota := opts.Quota
+        s.SetQuota(&quota)
+        storagesLog.Printf("Storage: %s quota: %s", s.Name(), quota.String())
+    }
+
     if err := s.Ops.Initialize(s); err != nil {
         storagesLog.Panicf("Storage initialization error: %s", err)
     }
 
-    sm.storages.Store(s.Id, s)
+    // New storage is empty, so the usage tracking is cheap.
+    if err := s.TrackUsage(); err != nil {
+        storagesLog.Panicf("Storage usage error: %s", err)
+    }
+
+    if err := sm.storages.StoreNamed(s.Id, s); err != nil {
+        storagesLog.Printf("%s: Store storage error: %s", s.Name(), err)
+
+        if destroyErr := s.Ops.Destroy(s); destroyErr != nil {
+            storagesLog.Panicf("Storage destroy error: %s", destroyErr)
+        }
+
+        return nil, err
+    }
 
     sm.storeMetadata()
 
-    return s
+    return s, nil
+}
+
+
+//  Attach alias to storage. If the alias is already attached to another
+// storage, it will be atomically moved to the given one.
+func (sm *StoragesManager) SetAlias(alias string, storageId storage_ifaces.StorageId) error {
+
+    if err := storage_ifaces.CheckStorageName(alias); err != nil {
+        return err
+    }
+
+    storage, ok := sm.storages.Load(storageId)
+    if !ok {
+        return fmt.Errorf("Attempt to alias non existing storage: %s!", storageId.Id)
+    }
+
+    storagesLog.Printf("Set alias: %s for storage: %s", alias, storage.Name())
+
+    if err := sm.storages.SetAlias(alias, storage.Id); err != nil {
+        return err
+    }
+
+    sm.storeMetadata()
+
+    return nil
+}
+
+
+// Detach alias from storage.
+func (sm *StoragesManager) DeleteAlias(alias string) error {
+
+    storagesLog.Printf("Delete alias: %s", alias)
+
+    if err := sm.storages.DeleteAlias(alias); err != nil {
+        return err
+    }
+
+    sm.storeMetadata()
+
+    return nil
+}
+
+
+//  Set storage expiration time. Expired storage will be destroyed by
+// the reaper. Zero time means that storage never expires.
+func (sm *StoragesManager) SetExpires(storageId storage_ifaces.StorageId, expires time.Time) error {
+
+    storage, ok := sm.storages.Load(storageId)
+    if !ok {
+        return fmt.Errorf("Attempt to expire non existing storage: %s!", storageId.Id)
+    }
+
+    var expiresPtr *time.Time
+    if !expires.IsZero() {
+        expires = expires.UTC()
+        expiresPtr = &expires
+        storagesLog.Printf("Storage: %s expires at: %s", storage.Name(), expires)
+    } else {
+        storagesLog.Printf("Storage: %s never expires", storage.Name())
+    }
+
+    if err := sm.storages.SetExpires(storage.Id, expiresPtr); err != nil {
+        return err
+    }
+
+    sm.storeMetadata()
+
+    return nil
+}
+
+
+// Set storage quota. Empty quota means unlimited storage.
+func (sm *StoragesManager) SetQuota(storageId storage_ifaces.StorageId, quota storage_ifaces.StorageQuota) error {
+
+    storage, ok := sm.storages.Load(storageId)
+    if !ok {
+        return fmt.Errorf("Attempt to set quota for non existing storage: %s!", storageId.Id)
+    }
+
+    storagesLog.Printf("Set storage: %s quota: %s", storage.Name(), quota.String())
+
+    var quotaPtr *storage_ifaces.StorageQuota
+    if !quota.Empty() {
+        quotaPtr = &quota
+    }
+
+    if quotaPtr != nil {
+        if err := storage.TrackUsage(); err != nil {
+            return err
+        }
+    }
+
+    if err := sm.storages.SetQuota(storage.Id, quotaPtr); err != nil {
+        return err
+    }
+
+    sm.storeMetadata()
+
+    return nil
+}
+
+
+//  Seal storage. All subsequent modifications of the sealed storage will
+// fail with error wrapping SEALED.
+func (sm *StoragesManager) Seal(storageId storage_ifaces.StorageId) (*storage_ifaces.StorageSeal, error) {
+
+    storage, ok := sm.storages.Load(storageId)
+    if !ok {
+        return nil, fmt.Errorf("Attempt to seal non existing storage: %s!", storageId.Id)
+    }
+
+    storagesLog.Printf("Seal storage: %s", storage.Name())
+
+    seal, err := storage.Seal()
+    if err != nil {
+        return nil, err
+    }
+
+    sm.storeMetadata()
+
+    return seal, nil
+}
+
+
+//  Convert storage to another storage type in place. All assets are
+// streamed to the new storage implementation preserving their opts, then
+// the storage implementation is replaced and the old one is destroyed.
+func (sm *StoragesManager) Convert(storageId storage_ifaces.StorageId, storageType storage_ifaces.StorageType) error {
+
+    sm.maintenance.RLock()
+    defer sm.maintenance.RUnlock()
+
+    storage, ok := sm.storages.Load(storageId)
+    if !ok {
+        return fmt.Errorf("Attempt to convert non existing storage: %s!", storageId.Id)
+    }
+
+    done, err := sm.lockBusy(storage)
+    if err != nil {
+        return err
+    }
+    defer done()
+
+    ops, sType := sm.createOps(storageType, sm.Opts())
+
+    if sType == storage.Type {
+        storagesLog.Printf("%s: Storage already has type: %s", storage.Name(), storage_ifaces.StorageType_toString(sType))
+        return nil
+    }
+
+    // Fail fast, the seal is checked again when the implementation is
+    // replaced.
+    if storage.IsSealed() {
+        return fmt.Errorf("Storage: %s is sealed! Err: %w", storage.Id.Id, storage_ifaces.SEALED)
+    }
+
+    //  New storage implementation can't share the root with the old one
+    // nor with the failed conversion attempts.
+    target := &storage_ifaces.Storage{
+        Parent  : sm,
+        Type    : sType,
+        Ops     : ops,
+        Id      : storage.Id,
+        Root    : fmt.Sprintf("%s.%s.%s", storage.Id.Id, storage_ifaces.StorageType_toString(sType), uuid.New().String()),
+    }
+
+    storagesLog.Printf("%s: Convert storage to: %s", storage.Name(), storage_ifaces.StorageType_toString(sType))
+
+    if err := target.Ops.Initialize(target); err != nil {
+        storagesLog.Printf("%s: Storage initialization error: %s", target.Name(), err)
+        return err
+    }
+
+    replaced, err := storage.Replace(target)
+    if err != nil {
+        storagesLog.Printf("%s: Storage conversion error: %s", storage.Name(), err)
+
+        if destroyErr := target.Ops.Destroy(target); destroyErr != nil {
+            storagesLog.Printf("%s: Storage destroy error: %s", target.Name(), destroyErr)
+        }
+
+        return err
+    }
+
+    sm.storeMetadata()
+
+    if err := replaced.Ops.Destroy(replaced); err != nil {
+        storagesLog.Printf("%s: Replaced storage destroy error: %s", replaced.Name(), err)
+    }
+
+    return nil
+}
+
+
+//  Mark storage as busy by the conversion or destruction. These can't be
+// done concurrently, so returns error wrapping CONVERTING if the storage
+// is already busy. Returned function must be called when the storage is
+// not busy anymore.
+func (sm *StoragesManager) lockBusy(storage *storage_ifaces.Storage) (func(), error) {
+    if _, busy := sm.busy.LoadOrStore(storage.Id.Id, true); busy {
+        return nil, fmt.Errorf("Storage: %s is being converted or destroyed! Err: %w", storage.Id.Id, storage_ifaces.CONVERTING)
+    }
+
+    done := func() {
+        sm.busy.Delete(storage.Id.Id)
+    }
+
+    // Storage can be destroyed before it is marked.
+    if s, ok := sm.storages.Load(storage.Id); !ok || s != storage {
+        done()
+        return nil, fmt.Errorf("Storage: %s is destroyed!", storage.Id.Id)
+    }
+
+    return done, nil
+}
+
+
+// Destroy all expired storages. Returns count of destroyed storages.
+func (sm *StoragesManager) Reap() int {
+
+    destroyed := 0
+
+    for _, id := range sm.storages.Expired(time.Now()) {
+        storagesLog.Printf("Storage: %s is expired", id.Id)
+
+        if err := sm.Destroy(id); err != nil {
+            storagesLog.Printf("Expired storage: %s destroy error: %s", id.Id, err)
+            continue
+        }
+
+        destroyed++
+    }
+
+    return destroyed
+}
+
+
+func (sm *StoragesManager) reaper(interval time.Duration) {
+
+    defer close(sm.stopped)
+
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        sm.Reap()
+
+        select {
+        case <-ticker.C:
+        case <-sm.stop:
+            return
+        }
+    }
+}
+
+
+//  Stop the expired storages reaper. Waits for the reaping in progress.
+// Storages are kept, so the manager may still be used.
+func (sm *StoragesManager) Close() {
+    sm.closeOnce.Do(func() {
+        close(sm.stop)
+    })
+
+    <-sm.stopped
 }
 
 
 func (sm *StoragesManager) Destroy(storageId storage_ifaces.StorageId) error {
 
+    sm.maintenance.RLock()
+    defer sm.maintenance.RUnlock()
+
     storage, ok := sm.storages.Load(storageId)
     if !ok {
         return fmt.Errorf("Attempt to destroy non existing storage: %s!", storageId.Id)
     }
 
+    done, err := sm.lockBusy(storage)
+    if err != nil {
+        return err
+    }
+    defer done()
+
     storagesLog.Printf("Destroy storage: %s", storage.Name())
 
-    err := storage.Ops.Destroy(storage)
+    err = storage.Ops.Destroy(storage)
     if err != nil {
         return err
     }
 
-    sm.storages.Delete(storageId)
+    sm.storages.Delete(storage.Id)
 
     sm.storeMetadata()
 
@@ -168,13 +569,27 @@ func (sm *StoragesManager) CreateStorageAssetFromBuffer(
     bufferId string,
     opts storage_ifaces.StorageAssetOpts) error {
 
+    return sm.CreateVerifiedStorageAssetFromBuffer(storageId, path, bufferId, opts, nil)
+}
+
+
+//  Create asset from buffer verifying the buffer content digests. On
+// mismatch returns error wrapping DIGEST_MISMATCH and the asset is not
+// created.
+func (sm *StoragesManager) CreateVerifiedStorageAssetFromBuffer(
+    storageId storage_ifaces.StorageId,
+    path storage_ifaces.Path,
+    bufferId string,
+    opts storage_ifaces.StorageAssetOpts,
+    digests storage_ifaces.StorageAssetDigests) error {
+
     storage, ok := sm.storages.Load(storageId)
     if !ok {
         return fmt.Errorf("Attempt to use non existing storage: %s!", storageId.Id)
     }
 
     if err := sm.Buffers().EnsureBuffer(bufferId); err != nil {
-        return fmt.Errorf("Attempt to use non existing buffer { id: %s }!", bufferId)
+        return fmt.Errorf("Attempt to use non existing buffer { id: %s }! Err: %w", bufferId, storage_ifaces.BUFFER_NOT_EXIST)
     }
 
     f, err := os.OpenFile(sm.Buffers().Abspath(bufferId), os.O_RDONLY, os.FileMode(0))
@@ -183,5 +598,85 @@ func (sm *StoragesManager) CreateStorageAssetFromBuffer(
     }
     defer f.Close()
 
-    return storage.CreateAsset(path, &storage_ifaces.StorageAssetReader{Reader: f, Opts: opts})
+    return storage.CreateAsset(path, &storage_ifaces.StorageAssetReader{Reader: storage_ifaces.NewDigestReader(f, digests), Opts: opts})
+}
+
+
+//  Expand archive from buffer into storage. See Storage.ImportArchive for
+// the import semantics.
+func (sm *StoragesManager) ImportArchiveFromBuffer(
+    storageId storage_ifaces.StorageId,
+    bufferId string,
+    opts storage_ifaces.StorageArchiveOpts) ([]storage_ifaces.StorageImportEntry, error) {
+
+    storage, ok := sm.storages.Load(storageId)
+    if !ok {
+        return nil, fmt.Errorf("Attempt to use non existing storage: %s!", storageId.Id)
+    }
+
+    if err := sm.Buffers().EnsureBuffer(bufferId); err != nil {
+        return nil, fmt.Errorf("Attempt to use non existing buffer { id: %s }! Err: %w", bufferId, storage_ifaces.BUFFER_NOT_EXIST)
+    }
+
+    f, err := os.OpenFile(sm.Buffers().Abspath(bufferId), os.O_RDONLY, os.FileMode(0))
+    if os.IsNotExist(err) {
+        return nil, fmt.Errorf("Attempt to use removed buffer { id: %s }! Err: %w", bufferId, storage_ifaces.BUFFER_NOT_EXIST)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("Can't open buffer { id: %s }: %s", bufferId, err)
+    }
+    defer f.Close()
+
+    return storage.ImportArchive(f, opts)
+}
+
+
+//  Check the vault consistency against the assets of all storages. The
+// references are taken from the storages keeping assets in the vault while
+// their modifications are blocked, and no storages are created meanwhile,
+// so every object put to the vault is either referenced by the collected
+// assets or put after the check. See VaultCheckOpts for the repair and
+// garbage collection modes.
+func (sm *StoragesManager) CheckVault(opts storage_ifaces.VaultCheckOpts) (*storage_ifaces.VaultCheckReport, error) {
+
+    sm.maintenance.Lock()
+    defer sm.maintenance.Unlock()
+
+    storagesLog.Printf("Check vault. Repair: %t gc: %t", opts.Repair, opts.Gc)
+
+    storages := make([]*storage_ifaces.Storage, 0, 100)
+
+    sm.storages.Range(func(id storage_ifaces.StorageId, s *storage_ifaces.Storage) bool {
+        storages = append(storages, s)
+        return true
+    })
+
+    refs    := make([]storage_ifaces.VaultObjectRef, 0, 100)
+    unlocks := make([]func(), 0, len(storages))
+
+    // Storages are kept locked until the vault is checked.
+    defer func() {
+        for _, unlock := range unlocks {
+            unlock()
+        }
+    }()
+
+    for _, s := range storages {
+        user, ok := s.Implementation().(storage_ifaces.StorageVaultUser)
+        if !ok {
+            continue
+        }
+
+        assets, unlock := user.LockVaultAssets(s)
+        unlocks = append(unlocks, unlock)
+
+        for _, asset := range assets {
+            refs = append(refs, storage_ifaces.VaultObjectRef{
+                Object:     asset.Object,
+                VaultRef:   storage_ifaces.VaultRef{StorageId: s.Id, Path: asset.Path},
+            })
+        }
+    }
+
+    return sm.vault.Check(refs, opts)
 }
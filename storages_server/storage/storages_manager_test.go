@@ -5,11 +5,24 @@ import (
     "./ifaces"
 
     "io"
+    "archive/tar"
+    "archive/zip"
+    "compress/gzip"
     "io/ioutil"
     "bytes"
     "strings"
+    "errors"
+    "fmt"
+    "crypto/md5"
+    "crypto/sha256"
+    "crypto/sha512"
+    "reflect"
     _ "io/ioutil"
     "log"
+    "os"
+    "path"
+    "path/filepath"
+    "time"
 )
 
 const (
@@ -21,8 +34,8 @@ func checkStorageOps_NewAsset(s *storage_ifaces.Storage, t *testing.T, path stri
     asset0          := path
 
     r0, err := s.ReadAsset(asset0)
-    if err == nil {
-        t.Fatal("Unexpected asset existance!")
+    if !errors.Is(err, storage_ifaces.ASSET_NOT_EXIST) {
+        t.Fatalf("Unexpected asset existance: %v", err)
     }
 
     asset0_opts     := storage_ifaces.StorageAssetOpts{Mode: mode}
@@ -30,6 +43,14 @@ func checkStorageOps_NewAsset(s *storage_ifaces.Storage, t *testing.T, path stri
     asset0_reader   := strings.NewReader(asset0_payload)
 
     err = s.CreateAsset(asset0, &storage_ifaces.StorageAssetReader{Reader: asset0_reader, Opts: asset0_opts})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = s.CreateAsset(asset0, &storage_ifaces.StorageAssetReader{Reader: strings.NewReader(asset0_payload), Opts: asset0_opts})
+    if !errors.Is(err, storage_ifaces.ASSET_EXIST) {
+        t.Fatalf("Unexpected error: %v", err)
+    }
 
     r0, err = s.ReadAsset(asset0)
     if err != nil {
@@ -48,7 +69,7 @@ func checkStorageOps_NewAsset(s *storage_ifaces.Storage, t *testing.T, path stri
 
     b0 := buf.Bytes()
 
-    if asset0_opts != r0.Opts {
+    if !reflect.DeepEqual(asset0_opts, r0.Opts) {
         t.Fatal("Unexpected asset opts!")
     }
 
@@ -64,6 +85,301 @@ func checkStorageOps_NewAsset(s *storage_ifaces.Storage, t *testing.T, path stri
 }
 
 
+func checkStorageOps_SeekAsset(s *storage_ifaces.Storage, t *testing.T, path string, payload string) {
+
+    r0, err := s.ReadAsset(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer r0.Close()
+
+    rs, ok := r0.ReadSeeker()
+    if !ok {
+        t.Fatal("Asset reader is not seekable!")
+    }
+
+    offset := int64(len(payload) / 2)
+
+    if _, err := rs.Seek(offset, io.SeekStart); err != nil {
+        t.Fatal(err)
+    }
+
+    b0, err := ioutil.ReadAll(rs)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if payload[offset:] != string(b0) {
+        t.Fatalf("Unexpected asset payload! Got: '%s' expected: '%s'", string(b0), payload[offset:])
+    }
+}
+
+
+func checkStorageOps_StatAsset(s *storage_ifaces.Storage, t *testing.T, path string, payload string, mode int) {
+
+    info, err := s.StatAsset(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if info.Path != path || info.Mode != mode {
+        t.Fatalf("Unexpected asset info: %s", info.String())
+    }
+
+    if info.Size != int64(len(payload)) {
+        t.Fatalf("Unexpected asset size. Got: %d expected: %d", info.Size, len(payload))
+    }
+
+    expected := fmt.Sprintf("%x", sha256.Sum256([]byte(payload)))
+    if info.ETag != expected {
+        t.Fatalf("Unexpected asset checksum. Got: %s expected: %s", info.ETag, expected)
+    }
+
+    if info.Modified.IsZero() || info.Created.IsZero() {
+        t.Fatalf("Unexpected asset timestamps: %s", info.String())
+    }
+
+    _, err = s.StatAsset(path + ".unknown")
+    if !errors.Is(err, storage_ifaces.ASSET_NOT_EXIST) {
+        t.Fatalf("Unexpected error: %s", err)
+    }
+}
+
+
+func checkStorageOps_DeleteAsset(s *storage_ifaces.Storage, t *testing.T, path string) {
+
+    r0, err := s.ReadAsset(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    r0.Close()
+
+    err = s.DeleteAsset(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    _, err = s.ReadAsset(path)
+    if err == nil {
+        t.Fatal("Unexpected asset existance!")
+    }
+
+    // Try to delete asset one more time
+    err = s.DeleteAsset(path)
+    if !errors.Is(err, storage_ifaces.ASSET_NOT_EXIST) {
+        t.Fatalf("Unexpected error: %s", err)
+    }
+}
+
+
+func checkStorageOps_MoveAsset(s *storage_ifaces.Storage, t *testing.T, from string, to string, payload string) {
+
+    err := s.MoveAsset(from, to)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    _, err = s.ReadAsset(from)
+    if err == nil {
+        t.Fatal("Unexpected asset existance!")
+    }
+
+    r0, err := s.ReadAsset(to)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer r0.Close()
+
+    b0, err := ioutil.ReadAll(r0)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if payload != string(b0) {
+        t.Fatal("Unexpected asset payload!")
+    }
+
+    // Try to move asset one more time
+    err = s.MoveAsset(from, to)
+    if !errors.Is(err, storage_ifaces.ASSET_NOT_EXIST) {
+        t.Fatalf("Unexpected error: %s", err)
+    }
+}
+
+
+func checkStorageOps_ReadPayload(s *storage_ifaces.Storage, t *testing.T, path string, payload string) {
+
+    r0, err := s.ReadAsset(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer r0.Close()
+
+    b0, err := ioutil.ReadAll(r0)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if payload != string(b0) {
+        t.Fatalf("Unexpected asset payload! Got: '%s' expected: '%s'", string(b0), payload)
+    }
+}
+
+
+func checkStorageOps_WriteAsset(s *storage_ifaces.Storage, t *testing.T, path string) {
+
+    write := func(payload string, cond storage_ifaces.StorageAssetCond) (string, error) {
+        return s.WriteAsset(path, &storage_ifaces.StorageAssetReader{
+            Reader: strings.NewReader(payload),
+            Opts: storage_ifaces.StorageAssetOpts{Mode: 0o644},
+        }, cond)
+    }
+
+    etag0, err := write("payload0", storage_ifaces.StorageAssetCond{IfNoneMatch: "*"})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    _, err = write("payload1", storage_ifaces.StorageAssetCond{IfNoneMatch: "*"})
+    if !errors.Is(err, storage_ifaces.PRECONDITION_FAILED) {
+        t.Fatalf("Unexpected error: %s", err)
+    }
+
+    _, err = write("payload1", storage_ifaces.StorageAssetCond{IfMatch: "\"unknown\""})
+    if !errors.Is(err, storage_ifaces.PRECONDITION_FAILED) {
+        t.Fatalf("Unexpected error: %s", err)
+    }
+
+    checkStorageOps_ReadPayload(s, t, path, "payload0")
+
+    etag1, err := write("payload1", storage_ifaces.StorageAssetCond{IfMatch: "\"" + etag0 + "\""})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if etag0 == etag1 {
+        t.Fatal("Unexpected ETag!")
+    }
+
+    checkStorageOps_ReadPayload(s, t, path, "payload1")
+
+    // Unconditional replace with the same content
+    etag2, err := write("payload1", storage_ifaces.StorageAssetCond{})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if etag1 != etag2 {
+        t.Fatal("Unexpected ETag!")
+    }
+
+    checkStorageOps_ReadPayload(s, t, path, "payload1")
+}
+
+
+func checkStorageOps_Properties(s *storage_ifaces.Storage, t *testing.T) {
+
+    opts := storage_ifaces.StorageAssetOpts{
+        Mode:       0o644,
+        Properties: storage_ifaces.StorageAssetProperties{"build_id": "42", "arch": "x86_64"},
+    }
+
+    err := s.CreateAsset("props/asset", &storage_ifaces.StorageAssetReader{Reader: strings.NewReader("props"), Opts: opts})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    info, err := s.StatAsset("props/asset")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if !reflect.DeepEqual(opts, info.StorageAssetOpts) {
+        t.Fatalf("Unexpected asset opts: %s", info.StorageAssetOpts.String())
+    }
+
+    resp, err := s.ListFiltered(storage_ifaces.StorageAssetProperties{"arch": "x86_64"})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    expected := `[{"path":"props/asset","properties":{"arch":"x86_64","build_id":"42","mode":"420"}}]`
+    if expected != string(resp) {
+        t.Fatalf("Unexpected ListFiltered() result!\nGot\t\t: %s\nExpected\t: %s", string(resp), expected)
+    }
+
+    if err := s.MoveAsset("props/asset", "props/moved"); err != nil {
+        t.Fatal(err)
+    }
+
+    info, err = s.StatAsset("props/moved")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if !reflect.DeepEqual(opts, info.StorageAssetOpts) {
+        t.Fatalf("Unexpected asset opts: %s", info.StorageAssetOpts.String())
+    }
+
+    if err := s.DeleteAsset("props/moved"); err != nil {
+        t.Fatal(err)
+    }
+}
+
+
+func checkStorageOps_ListEntries(s *storage_ifaces.Storage, t *testing.T) {
+
+    for _, path := range []string{"list/b/1", "list/a", "list/b/2", "list/c.txt", "list/d/1", "list-x"} {
+        checkStorageOps_NewAsset(s, t, path, path, 0o644)
+    }
+
+    list := func(opts storage_ifaces.StorageListOpts) ([]string, string) {
+        entries := make([]string, 0)
+        token, err := s.ListEntries(opts, func(entry storage_ifaces.StorageListEntry) error {
+            if len(entry.Prefix) > 0 {
+                entries = append(entries, "prefix:" + entry.Prefix)
+            } else {
+                entries = append(entries, entry.Path)
+            }
+            return nil
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+        return entries, token
+    }
+
+    entries, token := list(storage_ifaces.StorageListOpts{Prefix: "list/"})
+    expected := []string{"list/a", "list/b/1", "list/b/2", "list/c.txt", "list/d/1"}
+    if !reflect.DeepEqual(expected, entries) || len(token) > 0 {
+        t.Fatalf("Unexpected entries: %v token: %s", entries, token)
+    }
+
+    entries, token = list(storage_ifaces.StorageListOpts{Prefix: "list/", Delimiter: "/", Limit: 2})
+    expected = []string{"list/a", "prefix:list/b/"}
+    if !reflect.DeepEqual(expected, entries) || len(token) == 0 {
+        t.Fatalf("Unexpected entries: %v token: %s", entries, token)
+    }
+
+    entries, token = list(storage_ifaces.StorageListOpts{Prefix: "list/", Delimiter: "/", Limit: 2, Token: token})
+    expected = []string{"list/c.txt", "prefix:list/d/"}
+    if !reflect.DeepEqual(expected, entries) || len(token) > 0 {
+        t.Fatalf("Unexpected entries: %v token: %s", entries, token)
+    }
+
+    entries, token = list(storage_ifaces.StorageListOpts{Pattern: "list/*/1"})
+    expected = []string{"list/b/1", "list/d/1"}
+    if !reflect.DeepEqual(expected, entries) || len(token) > 0 {
+        t.Fatalf("Unexpected entries: %v token: %s", entries, token)
+    }
+
+    _, err := s.ListEntries(storage_ifaces.StorageListOpts{Pattern: "["}, func(storage_ifaces.StorageListEntry) error { return nil })
+    if !errors.Is(err, storage_ifaces.BAD_LIST_OPTS) {
+        t.Fatalf("Unexpected error: %s", err)
+    }
+}
+
+
 func checkStorageOps(s *storage_ifaces.Storage, t *testing.T) {
 
     checkStorageOps_NewAsset(s, t, "asset0", "payload0", 0o666)
@@ -73,6 +389,30 @@ func checkStorageOps(s *storage_ifaces.Storage, t *testing.T) {
     checkStorageOps_NewAsset(s, t, "asset4", "", 0o777)
     checkStorageOps_NewAsset(s, t, "asset5", "", 0o777)
     checkStorageOps_NewAsset(s, t, "asset6", "", 0o777)
+    checkStorageOps_NewAsset(s, t, "dir/asset7", "payload7", 0o644)
+
+    checkStorageOps_NewAsset(s, t, "dir/asset8", "payload8", 0o644)
+
+    checkStorageOps_StatAsset(s, t, "asset1", "payload1", 0o444)
+    checkStorageOps_StatAsset(s, t, "asset3", "", 0o777)
+
+    checkStorageOps_SeekAsset(s, t, "asset2", "payload2")
+
+    checkStorageOps_DeleteAsset(s, t, "asset6")
+    checkStorageOps_DeleteAsset(s, t, "dir/asset7")
+
+    checkStorageOps_MoveAsset(s, t, "dir/asset8", "release/asset8", "payload8")
+    checkStorageOps_StatAsset(s, t, "release/asset8", "payload8", 0o644)
+
+    if err := s.MoveAsset("asset0", "asset1"); !errors.Is(err, storage_ifaces.ASSET_EXIST) {
+        t.Fatalf("Unexpected error: %s", err)
+    }
+
+    checkStorageOps_WriteAsset(s, t, "dir/asset9")
+
+    checkStorageOps_Properties(s, t)
+
+    checkStorageOps_ListEntries(s, t)
 
     s.Range(func(path storage_ifaces.Path, opts storage_ifaces.StorageAssetOpts) bool {
         log.Printf("Asset: %s mode: %d", path, opts.Mode)
@@ -97,6 +437,7 @@ func TestCreateStorage(t *testing.T) {
     opts := PrefixedStoragesOpts(TESTING_WS)
 
     storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
 
     sm := storagesManager.Create(storage_ifaces.StorageDefault)
     if sm == nil {
@@ -132,11 +473,13 @@ func TestCreateStorages(t *testing.T) {
     opts := PrefixedStoragesOpts(TESTING_WS)
 
     storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
     storagesManager.Create(storage_ifaces.StorageDefault)
     storagesManager.Create(storage_ifaces.StorageDefault)
     storagesManager.Create(storage_ifaces.StorageDefault)
 
     storagesManager1 := NewStoragesManager(opts)
+    defer storagesManager1.Close()
     storagesManager1.Create(storage_ifaces.StorageDefault)
 }
 
@@ -146,6 +489,7 @@ func TestCreateBuffer(t *testing.T) {
     opts := PrefixedStoragesOpts(TESTING_WS)
 
     storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
 
     storage := storagesManager.Create(storage_ifaces.StorageDefault)
     if storage == nil {
@@ -184,6 +528,13 @@ func TestCreateBuffer(t *testing.T) {
         t.Fatal("Can't create storage asset!")
     }
 
+    if err := storagesManager.CreateStorageAssetFromBuffer(storage.Id, "unknown", "unknown-buffer", storage_ifaces.StorageAssetOpts{Mode: 0o666}); !errors.Is(err, storage_ifaces.BUFFER_NOT_EXIST) {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if _, err := storagesManager.ImportArchiveFromBuffer(storage.Id, "unknown-buffer", storage_ifaces.StorageArchiveOpts{}); !errors.Is(err, storage_ifaces.BUFFER_NOT_EXIST) {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+
     reader, err := storage.ReadAsset("test_1234567890")
     if err != nil {
         t.Fatal(err)
@@ -198,3 +549,2295 @@ func TestCreateBuffer(t *testing.T) {
         t.Fatalf("Unexpected buffer content. Got: '%s' expected: '%s'", string(b), "1234567890")
     }
 }
+
+
+func TestDeleteSharedAsset(t *testing.T) {
+
+    opts := PrefixedStoragesOpts(TESTING_WS)
+
+    storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
+
+    s0 := storagesManager.Create(storage_ifaces.StorageHashedFilesystem)
+    if s0 == nil {
+        t.Fatal("Can't create hashed storage on disk!")
+    }
+    defer storagesManager.Destroy(s0.Id)
+
+    s1 := storagesManager.Create(storage_ifaces.StorageHashedFilesystem)
+    if s1 == nil {
+        t.Fatal("Can't create hashed storage on disk!")
+    }
+    defer storagesManager.Destroy(s1.Id)
+
+    checkStorageOps_NewAsset(s0, t, "shared", "shared payload", 0o644)
+    checkStorageOps_NewAsset(s1, t, "shared", "shared payload", 0o644)
+
+    checkStorageOps_DeleteAsset(s0, t, "shared")
+
+    reader, err := s1.ReadAsset("shared")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer reader.Close()
+
+    b, err := ioutil.ReadAll(reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if string(b) != "shared payload" {
+        t.Fatalf("Unexpected asset content. Got: '%s' expected: '%s'", string(b), "shared payload")
+    }
+}
+
+
+func TestListStorages(t *testing.T) {
+
+    opts := PrefixedStoragesOpts(TESTING_WS)
+
+    storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
+
+    for _, st := range []storage_ifaces.StorageType{storage_ifaces.StorageMemory, storage_ifaces.StoragePlainFilesystem, storage_ifaces.StorageHashedFilesystem} {
+
+        s := storagesManager.Create(st)
+        if s == nil {
+            t.Fatal("Can't create storage!")
+        }
+        defer storagesManager.Destroy(s.Id)
+
+        checkStorageOps_NewAsset(s, t, "asset0", "payload", 0o644)
+        checkStorageOps_NewAsset(s, t, "dir/asset1", "payload", 0o644)
+        checkStorageOps_NewAsset(s, t, "dir/asset2", "payload2", 0o644)
+
+        found := false
+        for _, summary := range storagesManager.ListStorages() {
+            if summary.Id != s.Id.Id {
+                continue
+            }
+
+            found = true
+
+            if summary.Type != storage_ifaces.StorageType_toString(st) || summary.Created.IsZero() {
+                t.Fatalf("Unexpected storage summary: %v", summary)
+            }
+
+            if summary.Assets != 3 || summary.Size != 22 {
+                t.Fatalf("Unexpected storage statistics: %v", summary.StorageStats)
+            }
+
+            if st == storage_ifaces.StorageHashedFilesystem && summary.VaultSize != 15 {
+                t.Fatalf("Unexpected storage vault size: %d", summary.VaultSize)
+            }
+        }
+
+        if !found {
+            t.Fatalf("Storage %s is not listed!", s.Id.Id)
+        }
+    }
+}
+
+
+func TestStorageNames(t *testing.T) {
+
+    opts := PrefixedStoragesOpts(TESTING_WS)
+
+    storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
+
+    suffix := storage_ifaces.MakeNewStorageId().Id
+
+    s0, err := storagesManager.CreateNamed(storage_ifaces.StorageHashedFilesystem, "nightly-" + suffix)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer storagesManager.Destroy(s0.Id)
+
+    s1, err := storagesManager.CreateNamed(storage_ifaces.StoragePlainFilesystem, "release-" + suffix)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer storagesManager.Destroy(s1.Id)
+
+    if _, err := storagesManager.CreateNamed(storage_ifaces.StorageDefault, "nightly-" + suffix); !errors.Is(err, storage_ifaces.NAME_EXIST) {
+        t.Fatalf("Unexpected error: %s", err)
+    }
+
+    if _, err := storagesManager.CreateNamed(storage_ifaces.StorageDefault, "../bad"); !errors.Is(err, storage_ifaces.BAD_NAME) {
+        t.Fatalf("Unexpected error: %s", err)
+    }
+
+    if s := storagesManager.Get(storage_ifaces.MakeStorageId("nightly-" + suffix)); s != s0 {
+        t.Fatal("Can't get storage by name!")
+    }
+
+    alias := "latest-" + suffix
+
+    if err := storagesManager.SetAlias(alias, s0.Id); err != nil {
+        t.Fatal(err)
+    }
+
+    if s := storagesManager.Get(storage_ifaces.MakeStorageId(alias)); s != s0 {
+        t.Fatal("Can't get storage by alias!")
+    }
+
+    if err := storagesManager.SetAlias(alias, storage_ifaces.MakeStorageId("release-" + suffix)); err != nil {
+        t.Fatal(err)
+    }
+
+    if s := storagesManager.Get(storage_ifaces.MakeStorageId(alias)); s != s1 {
+        t.Fatal("Alias is not moved!")
+    }
+
+    if len(s0.Aliases) != 0 || !reflect.DeepEqual(s1.Aliases, []string{alias}) {
+        t.Fatalf("Unexpected aliases: %v %v", s0.Aliases, s1.Aliases)
+    }
+
+    if err := storagesManager.SetAlias("nightly-" + suffix, s1.Id); !errors.Is(err, storage_ifaces.NAME_EXIST) {
+        t.Fatalf("Unexpected error: %s", err)
+    }
+
+    // Names and aliases must survive restart
+    storagesManager1 := NewStoragesManager(opts)
+    defer storagesManager1.Close()
+
+    if s := storagesManager1.Get(storage_ifaces.MakeStorageId(alias)); s == nil || s.Id != s1.Id {
+        t.Fatal("Can't get storage by alias after restart!")
+    }
+
+    if s := storagesManager1.Get(storage_ifaces.MakeStorageId("nightly-" + suffix)); s == nil || s.Id != s0.Id {
+        t.Fatal("Can't get storage by name after restart!")
+    }
+
+    if err := storagesManager.DeleteAlias(alias); err != nil {
+        t.Fatal(err)
+    }
+
+    if s := storagesManager.Get(storage_ifaces.MakeStorageId(alias)); s != nil {
+        t.Fatal("Unexpected alias existance!")
+    }
+
+    if err := storagesManager.DeleteAlias(alias); !errors.Is(err, storage_ifaces.NAME_NOT_EXIST) {
+        t.Fatalf("Unexpected error: %s", err)
+    }
+}
+
+
+func TestExpireStorages(t *testing.T) {
+
+    opts := PrefixedStoragesOpts(TESTING_WS)
+
+    // Reap explicitly
+    opts.ReaperInterval = 0
+
+    storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
+
+    s0 := storagesManager.Create(storage_ifaces.StorageHashedFilesystem)
+    if s0 == nil {
+        t.Fatal("Can't create storage!")
+    }
+
+    s1 := storagesManager.Create(storage_ifaces.StorageHashedFilesystem)
+    if s1 == nil {
+        t.Fatal("Can't create storage!")
+    }
+    defer storagesManager.Destroy(s1.Id)
+
+    checkStorageOps_NewAsset(s0, t, "asset0", "expired payload", 0o644)
+    checkStorageOps_NewAsset(s1, t, "asset0", "expired payload", 0o644)
+
+    if err := storagesManager.SetExpires(s0.Id, time.Now().Add(-time.Second)); err != nil {
+        t.Fatal(err)
+    }
+
+    if err := storagesManager.SetExpires(s1.Id, time.Now().Add(time.Hour)); err != nil {
+        t.Fatal(err)
+    }
+
+    // Expiration must survive restart
+    storagesManager1 := NewStoragesManager(opts)
+    defer storagesManager1.Close()
+
+    if s := storagesManager1.Get(s1.Id); s == nil || s.Expires == nil || !s.Expires.Equal(*s1.Expires) {
+        t.Fatal("Unexpected storage expiration after restart!")
+    }
+
+    if destroyed := storagesManager.Reap(); destroyed != 1 {
+        t.Fatalf("Unexpected destroyed storages count: %d", destroyed)
+    }
+
+    if s := storagesManager.Get(s0.Id); s != nil {
+        t.Fatal("Expired storage is not destroyed!")
+    }
+
+    // Shared vault object must be still available
+    checkStorageOps_ReadPayload(s1, t, "asset0", "expired payload")
+
+    if err := storagesManager.SetExpires(s1.Id, time.Time{}); err != nil {
+        t.Fatal(err)
+    }
+
+    if s1.Expires != nil {
+        t.Fatal("Unexpected storage expiration!")
+    }
+}
+
+
+func TestReaper(t *testing.T) {
+
+    opts := PrefixedStoragesOpts(TESTING_WS)
+    opts.ReaperInterval = 10 * time.Millisecond
+
+    storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
+
+    s, err := storagesManager.CreateWithOpts(storage_ifaces.StorageMemory, storage_ifaces.StorageCreateOpts{
+        Expires: time.Now().Add(50 * time.Millisecond),
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    deadline := time.Now().Add(5 * time.Second)
+    for storagesManager.Get(s.Id) != nil {
+        if time.Now().After(deadline) {
+            t.Fatal("Expired storage is not reaped!")
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+
+    // Stopped reaper keeps expired storages.
+    storagesManager.Close()
+
+    s, err = storagesManager.CreateWithOpts(storage_ifaces.StorageMemory, storage_ifaces.StorageCreateOpts{
+        Expires: time.Now().Add(-time.Second),
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    time.Sleep(50 * time.Millisecond)
+
+    if storagesManager.Get(s.Id) == nil {
+        t.Fatal("Storage is reaped after close!")
+    }
+
+    if destroyed := storagesManager.Reap(); destroyed != 1 {
+        t.Fatalf("Unexpected destroyed storages count: %d", destroyed)
+    }
+}
+
+
+func TestCreateWithOpts(t *testing.T) {
+
+    opts := PrefixedStoragesOpts(TESTING_WS)
+    opts.ReaperInterval = 0
+
+    storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
+
+    name := "opts-" + storage_ifaces.MakeNewStorageId().Id
+    createOpts := storage_ifaces.StorageCreateOpts{
+        Name:       name,
+        Expires:    time.Now().Add(time.Hour),
+        Quota:      storage_ifaces.StorageQuota{MaxAssets: 1},
+    }
+
+    s, err := storagesManager.CreateWithOpts(storage_ifaces.StorageHashedFilesystem, createOpts)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer storagesManager.Destroy(s.Id)
+
+    if s.Expires == nil || !s.Expires.Equal(createOpts.Expires) || s.Quota == nil || *s.Quota != createOpts.Quota {
+        t.Fatalf("Unexpected storage options: %v %v", s.Expires, s.Quota)
+    }
+
+    checkStorageOps_NewAsset(s, t, "asset0", "payload", 0o644)
+
+    if err := s.CreateAsset("asset1", &storage_ifaces.StorageAssetReader{Reader: strings.NewReader("payload"), Opts: storage_ifaces.StorageAssetOpts{Mode: 0o644}}); !errors.Is(err, storage_ifaces.QUOTA_EXCEEDED) {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+
+    // Failed creation leaves no storage behind.
+    count := len(storagesManager.ListStorages())
+    if _, err := storagesManager.CreateWithOpts(storage_ifaces.StorageDefault, createOpts); !errors.Is(err, storage_ifaces.NAME_EXIST) {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if len(storagesManager.ListStorages()) != count {
+        t.Fatal("Unexpected storage existance!")
+    }
+
+    // Options must survive restart
+    storagesManager1 := NewStoragesManager(opts)
+    defer storagesManager1.Close()
+
+    s1 := storagesManager1.Get(storage_ifaces.MakeStorageId(name))
+    if s1 == nil || s1.Expires == nil || !s1.Expires.Equal(*s.Expires) || s1.Quota == nil || *s1.Quota != createOpts.Quota {
+        t.Fatal("Unexpected storage options after restart!")
+    }
+}
+
+
+func TestStorageQuota(t *testing.T) {
+
+    opts := PrefixedStoragesOpts(TESTING_WS)
+
+    storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
+
+    createAsset := func(s *storage_ifaces.Storage, path string, payload string) error {
+        return s.CreateAsset(path, &storage_ifaces.StorageAssetReader{
+            Reader: strings.NewReader(payload),
+            Opts: storage_ifaces.StorageAssetOpts{Mode: 0o644},
+        })
+    }
+
+    tempFiles := func() int {
+        files, err := ioutil.ReadDir(opts.TempDir)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return len(files)
+    }
+
+    for _, st := range []storage_ifaces.StorageType{storage_ifaces.StorageMemory, storage_ifaces.StoragePlainFilesystem, storage_ifaces.StorageHashedFilesystem} {
+
+        s := storagesManager.Create(st)
+        if s == nil {
+            t.Fatal("Can't create storage!")
+        }
+        defer storagesManager.Destroy(s.Id)
+
+        if err := storagesManager.SetQuota(s.Id, storage_ifaces.StorageQuota{MaxSize: 10, MaxAssets: 2}); err != nil {
+            t.Fatal(err)
+        }
+
+        temp := tempFiles()
+
+        if err := createAsset(s, "asset0", "123456"); err != nil {
+            t.Fatal(err)
+        }
+
+        if err := createAsset(s, "asset1", "12345678901"); !errors.Is(err, storage_ifaces.ASSET_TOO_LARGE) {
+            t.Fatalf("Unexpected error: %s", err)
+        }
+
+        if err := createAsset(s, "asset1", "12345"); !errors.Is(err, storage_ifaces.QUOTA_EXCEEDED) {
+            t.Fatalf("Unexpected error: %s", err)
+        }
+
+        if _, err := s.StatAsset("asset1"); err == nil {
+            t.Fatal("Unexpected asset existance!")
+        }
+
+        if tempFiles() != temp {
+            t.Fatal("Temp file is not removed!")
+        }
+
+        if err := createAsset(s, "asset1", "1234"); err != nil {
+            t.Fatal(err)
+        }
+
+        if err := createAsset(s, "asset2", ""); !errors.Is(err, storage_ifaces.QUOTA_EXCEEDED) {
+            t.Fatalf("Unexpected error: %s", err)
+        }
+
+        // Replaced asset size is released
+        if _, err := s.WriteAsset("asset0", &storage_ifaces.StorageAssetReader{
+            Reader: strings.NewReader("654321"),
+            Opts: storage_ifaces.StorageAssetOpts{Mode: 0o644},
+        }, storage_ifaces.StorageAssetCond{}); err != nil {
+            t.Fatal(err)
+        }
+
+        if err := s.DeleteAsset("asset1"); err != nil {
+            t.Fatal(err)
+        }
+
+        bid, err := storagesManager.Buffers().Create()
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer storagesManager.Buffers().Discard(bid)
+
+        if _, err := storagesManager.Buffers().Append(bid, strings.NewReader("1234567")); err != nil {
+            t.Fatal(err)
+        }
+
+        err = storagesManager.CreateStorageAssetFromBuffer(s.Id, "asset1", bid, storage_ifaces.StorageAssetOpts{Mode: 0o644})
+        if !errors.Is(err, storage_ifaces.QUOTA_EXCEEDED) {
+            t.Fatalf("Unexpected error: %s", err)
+        }
+
+        if tempFiles() != temp {
+            t.Fatal("Temp file is not removed!")
+        }
+    }
+}
+
+
+func TestStorageQuotaConcurrentWriters(t *testing.T) {
+
+    opts := PrefixedStoragesOpts(TESTING_WS)
+
+    storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
+
+    for _, st := range []storage_ifaces.StorageType{storage_ifaces.StorageMemory, storage_ifaces.StoragePlainFilesystem, storage_ifaces.StorageHashedFilesystem} {
+
+        s := storagesManager.Create(st)
+        if s == nil {
+            t.Fatal("Can't create storage!")
+        }
+        defer storagesManager.Destroy(s.Id)
+
+        if err := storagesManager.SetQuota(s.Id, storage_ifaces.StorageQuota{MaxSize: 10}); err != nil {
+            t.Fatal(err)
+        }
+
+        pr, pw := io.Pipe()
+
+        slow := make(chan error)
+        go func() {
+            slow <- s.CreateAsset("slow", &storage_ifaces.StorageAssetReader{Reader: pr, Opts: storage_ifaces.StorageAssetOpts{Mode: 0o644}})
+        }()
+
+        // The slow writer reads the data, so its quota is reserved.
+        if _, err := pw.Write([]byte("1")); err != nil {
+            t.Fatal(err)
+        }
+
+        // Committed while the slow writer is in progress.
+        checkStorageOps_NewAsset(s, t, "fast", "123456", 0o644)
+
+        pw.Write([]byte("23456"))
+        pw.Close()
+
+        if err := <-slow; !errors.Is(err, storage_ifaces.QUOTA_EXCEEDED) {
+            t.Fatalf("Unexpected error: %v", err)
+        }
+
+        // Deleted asset size is released.
+        if err := s.DeleteAsset("fast"); err != nil {
+            t.Fatal(err)
+        }
+
+        checkStorageOps_NewAsset(s, t, "slow", "1234567890", 0o644)
+    }
+}
+
+func TestStorageMetadataConcurrency(t *testing.T) {
+
+    opts := PrefixedStoragesOpts(TESTING_WS)
+
+    storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
+
+    s := storagesManager.Create(storage_ifaces.StorageMemory)
+    if s == nil {
+        t.Fatal("Can't create storage!")
+    }
+    defer storagesManager.Destroy(s.Id)
+
+    alias := fmt.Sprintf("alias-%s", s.Id.Id)
+
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        for i := 0; i < 200; i++ {
+            storagesManager.SetAlias(alias, s.Id)
+            storagesManager.SetExpires(s.Id, time.Now().Add(time.Hour))
+            storagesManager.SetQuota(s.Id, storage_ifaces.StorageQuota{MaxSize: int64(1000 + i)})
+            storagesManager.DeleteAlias(alias)
+        }
+    }()
+
+    for i := 0; i < 200; i++ {
+        if _, err := s.Summary(); err != nil {
+            t.Fatal(err)
+        }
+
+        if _, err := s.WriteAsset("asset", &storage_ifaces.StorageAssetReader{
+            Reader: strings.NewReader("payload"),
+            Opts: storage_ifaces.StorageAssetOpts{Mode: 0o644},
+        }, storage_ifaces.StorageAssetCond{}); err != nil {
+            t.Fatal(err)
+        }
+
+        if s.Expired(time.Now()) {
+            t.Fatal("Unexpected expired storage!")
+        }
+    }
+
+    <-done
+}
+
+
+func TestSealStorage(t *testing.T) {
+
+    opts := PrefixedStoragesOpts(TESTING_WS)
+
+    storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
+
+    for _, st := range []storage_ifaces.StorageType{storage_ifaces.StorageMemory, storage_ifaces.StoragePlainFilesystem, storage_ifaces.StorageHashedFilesystem} {
+
+        s := storagesManager.Create(st)
+        if s == nil {
+            t.Fatal("Can't create storage!")
+        }
+        defer storagesManager.Destroy(s.Id)
+
+        checkStorageOps_NewAsset(s, t, "dir/asset1", "payload1", 0o755)
+        checkStorageOps_NewAsset(s, t, "asset0", "payload0", 0o644)
+
+        seal, err := storagesManager.Seal(s.Id)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        if seal.Time.IsZero() || !s.IsSealed() {
+            t.Fatalf("Unexpected seal: %v", seal)
+        }
+
+        if st == storage_ifaces.StorageHashedFilesystem {
+            expected := sha256.New()
+            fmt.Fprintf(expected, "asset0\x00%x\x00644\n", sha256.Sum256([]byte("payload0")))
+            fmt.Fprintf(expected, "dir/asset1\x00%x\x00755\n", sha256.Sum256([]byte("payload1")))
+
+            if seal.Digest != fmt.Sprintf("%x", expected.Sum(nil)) {
+                t.Fatalf("Unexpected storage digest: %s", seal.Digest)
+            }
+        }
+
+        if _, err := storagesManager.Seal(s.Id); !errors.Is(err, storage_ifaces.SEALED) {
+            t.Fatalf("Unexpected error: %s", err)
+        }
+
+        err = s.CreateAsset("asset2", &storage_ifaces.StorageAssetReader{
+            Reader: strings.NewReader("payload2"),
+            Opts: storage_ifaces.StorageAssetOpts{Mode: 0o644},
+        })
+        if !errors.Is(err, storage_ifaces.SEALED) {
+            t.Fatalf("Unexpected error: %s", err)
+        }
+
+        if err := s.DeleteAsset("asset0"); !errors.Is(err, storage_ifaces.SEALED) {
+            t.Fatalf("Unexpected error: %s", err)
+        }
+
+        if err := s.MoveAsset("asset0", "asset2"); !errors.Is(err, storage_ifaces.SEALED) {
+            t.Fatalf("Unexpected error: %s", err)
+        }
+
+        bid, err := storagesManager.Buffers().Create()
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer storagesManager.Buffers().Discard(bid)
+
+        err = storagesManager.CreateStorageAssetFromBuffer(s.Id, "asset2", bid, storage_ifaces.StorageAssetOpts{Mode: 0o644})
+        if !errors.Is(err, storage_ifaces.SEALED) {
+            t.Fatalf("Unexpected error: %s", err)
+        }
+
+        checkStorageOps_ReadPayload(s, t, "asset0", "payload0")
+
+        if st == storage_ifaces.StorageMemory {
+            continue
+        }
+
+        // Seal must survive restart
+        storagesManager1 := NewStoragesManager(opts)
+        defer storagesManager1.Close()
+
+        s1 := storagesManager1.Get(s.Id)
+        if s1 == nil || s1.Sealed == nil || *s1.Sealed != *seal {
+            t.Fatal("Unexpected storage seal after restart!")
+        }
+
+        if err := s1.DeleteAsset("asset0"); !errors.Is(err, storage_ifaces.SEALED) {
+            t.Fatalf("Unexpected error: %s", err)
+        }
+    }
+}
+
+
+func TestConvertStorage(t *testing.T) {
+
+    opts := PrefixedStoragesOpts(TESTING_WS)
+
+    storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
+
+    s := storagesManager.Create(storage_ifaces.StoragePlainFilesystem)
+    if s == nil {
+        t.Fatal("Can't create storage!")
+    }
+    defer storagesManager.Destroy(s.Id)
+
+    checkStorageOps_NewAsset(s, t, "asset0", "payload0", 0o644)
+    checkStorageOps_NewAsset(s, t, "dir/asset1", "payload1", 0o755)
+    checkStorageOps_Properties(s, t)
+
+    expected, err := s.List()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    for _, st := range []storage_ifaces.StorageType{storage_ifaces.StorageHashedFilesystem, storage_ifaces.StorageMemory, storage_ifaces.StoragePlainFilesystem, storage_ifaces.StorageHashedFilesystem} {
+
+        if err := storagesManager.Convert(s.Id, st); err != nil {
+            t.Fatal(err)
+        }
+
+        if s.Type != st {
+            t.Fatalf("Unexpected storage type: %d", int(s.Type))
+        }
+
+        got, err := s.List()
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        if string(got) != string(expected) {
+            t.Fatalf("Unexpected storage content!\nGot\t\t: %s\nExpected\t: %s", string(got), string(expected))
+        }
+
+        checkStorageOps_ReadPayload(s, t, "dir/asset1", "payload1")
+    }
+
+    // Converted storage must survive restart
+    storagesManager1 := NewStoragesManager(opts)
+    defer storagesManager1.Close()
+
+    s1 := storagesManager1.Get(s.Id)
+    if s1 == nil || s1.Type != storage_ifaces.StorageHashedFilesystem {
+        t.Fatal("Unexpected storage after restart!")
+    }
+
+    checkStorageOps_ReadPayload(s1, t, "asset0", "payload0")
+}
+
+
+func TestConvertGuards(t *testing.T) {
+
+    opts := PrefixedStoragesOpts(TESTING_WS)
+
+    storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
+
+    s := storagesManager.Create(storage_ifaces.StoragePlainFilesystem)
+    if s == nil {
+        t.Fatal("Can't create storage!")
+    }
+    defer storagesManager.Destroy(s.Id)
+
+    checkStorageOps_NewAsset(s, t, "asset", "payload", 0o644)
+
+    // Conversion in progress.
+    storagesManager.busy.Store(s.Id.Id, true)
+
+    if err := storagesManager.Convert(s.Id, storage_ifaces.StorageHashedFilesystem); !errors.Is(err, storage_ifaces.CONVERTING) {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+
+    // Storage can't be destroyed while it is converted.
+    if err := storagesManager.Destroy(s.Id); !errors.Is(err, storage_ifaces.CONVERTING) {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+
+    storagesManager.busy.Delete(s.Id.Id)
+
+    // Readers see either implementation while the storage is converted.
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        for _, st := range []storage_ifaces.StorageType{storage_ifaces.StorageHashedFilesystem, storage_ifaces.StorageMemory, storage_ifaces.StoragePlainFilesystem} {
+            if err := storagesManager.Convert(s.Id, st); err != nil {
+                t.Error(err)
+            }
+        }
+    }()
+
+    for converted := false; !converted; {
+        select {
+        case <-done:
+            converted = true
+        default:
+        }
+
+        checkStorageOps_ReadPayload(s, t, "asset", "payload")
+        if _, err := s.Stats(); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    // Every attempt gets own root.
+    if err := storagesManager.Convert(s.Id, storage_ifaces.StorageHashedFilesystem); err != nil {
+        t.Fatal(err)
+    }
+    root := s.Root
+
+    if err := storagesManager.Convert(s.Id, storage_ifaces.StorageMemory); err != nil {
+        t.Fatal(err)
+    }
+    if err := storagesManager.Convert(s.Id, storage_ifaces.StorageHashedFilesystem); err != nil {
+        t.Fatal(err)
+    }
+    if s.Root == root {
+        t.Fatalf("Conversion root is reused: %s", root)
+    }
+
+    if _, err := storagesManager.Seal(s.Id); err != nil {
+        t.Fatal(err)
+    }
+
+    if err := storagesManager.Convert(s.Id, storage_ifaces.StorageMemory); !errors.Is(err, storage_ifaces.SEALED) {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+
+    if s.Type != storage_ifaces.StorageHashedFilesystem {
+        t.Fatalf("Unexpected storage type: %d", int(s.Type))
+    }
+
+    checkStorageOps_ReadPayload(s, t, "asset", "payload")
+}
+
+
+func TestPathIndex(t *testing.T) {
+
+    var index storage_ifaces.PathIndex
+
+    expected := make([]storage_ifaces.Path, 0, 1000)
+    for i := 999; i >= 0; i-- {
+        index.Insert(fmt.Sprintf("dir/%03d", i))
+    }
+    for i := 0; i < 1000; i++ {
+        if i % 3 == 0 {
+            index.Delete(fmt.Sprintf("dir/%03d", i))
+            continue
+        }
+        expected = append(expected, fmt.Sprintf("dir/%03d", i))
+    }
+    index.Insert("dir/001")
+    index.Insert("a")
+    index.Insert("z")
+
+    paths := make([]storage_ifaces.Path, 0)
+    index.Range(storage_ifaces.StorageRangeOpts{Prefix: "dir/"}, func(path storage_ifaces.Path) bool {
+        paths = append(paths, path)
+        return true
+    })
+    if !reflect.DeepEqual(paths, expected) {
+        t.Fatalf("Unexpected paths: %v", paths)
+    }
+
+    cases := []struct {
+        opts        storage_ifaces.StorageRangeOpts
+        expected    []storage_ifaces.Path
+    }{
+        {storage_ifaces.StorageRangeOpts{Prefix: "dir/", After: "dir/001"}, []storage_ifaces.Path{"dir/002", "dir/004"}},
+        {storage_ifaces.StorageRangeOpts{Prefix: "dir/", After: "dir/003"}, []storage_ifaces.Path{"dir/004", "dir/005"}},
+        {storage_ifaces.StorageRangeOpts{Prefix: "dir/", After: "a"}, []storage_ifaces.Path{"dir/001", "dir/002"}},
+        {storage_ifaces.StorageRangeOpts{Prefix: "dir/", After: "dir/999"}, []storage_ifaces.Path{}},
+        {storage_ifaces.StorageRangeOpts{After: "dir/998"}, []storage_ifaces.Path{"z"}},
+        {storage_ifaces.StorageRangeOpts{}, []storage_ifaces.Path{"a", "dir/001"}},
+    }
+
+    for _, c := range cases {
+        if paths := index.Next(c.opts, 2); !reflect.DeepEqual(paths, c.expected) {
+            t.Fatalf("Unexpected paths: %v for range: %v", paths, c.opts)
+        }
+    }
+}
+
+
+func TestAssetPaths(t *testing.T) {
+
+    for _, c := range []struct{ path, expected string }{
+        {"a/b", "a/b"},
+        {"./a", "a"},
+        {"a//b/", "a/b"},
+        {"a/../b", "b"},
+        {"a\\b", "a/b"},
+        {"/a", ""},
+        {"..", ""},
+        {"a/../../b", ""},
+        {".", ""},
+        {"", ""},
+        {"a\x00b", ""},
+        {"\xff", ""},
+        {strings.Repeat("a", 256), ""},
+        {strings.Repeat("a/", 2049), ""},
+    } {
+        cleaned, err := storage_ifaces.CleanPath(c.path)
+        if len(c.expected) == 0 {
+            if !errors.Is(err, storage_ifaces.BAD_PATH) {
+                t.Fatalf("Unexpected CleanPath(%q) result: %q error: %s", c.path, cleaned, err)
+            }
+            continue
+        }
+
+        if err != nil || cleaned != c.expected {
+            t.Fatalf("Unexpected CleanPath(%q) result: %q error: %s", c.path, cleaned, err)
+        }
+    }
+
+    opts := PrefixedStoragesOpts(TESTING_WS)
+
+    storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
+
+    for _, st := range []storage_ifaces.StorageType{storage_ifaces.StorageMemory, storage_ifaces.StoragePlainFilesystem, storage_ifaces.StorageHashedFilesystem} {
+
+        s := storagesManager.Create(st)
+        if s == nil {
+            t.Fatal("Can't create storage!")
+        }
+        defer storagesManager.Destroy(s.Id)
+
+        checkStorageOps_NewAsset(s, t, "dir//./asset0", "payload0", 0o644)
+        checkStorageOps_ReadPayload(s, t, "dir/asset0", "payload0")
+        checkStorageOps_ReadPayload(s, t, "other/../dir/asset0", "payload0")
+
+        err := s.CreateAsset("../escaped", &storage_ifaces.StorageAssetReader{
+            Reader: strings.NewReader("escaped"),
+            Opts: storage_ifaces.StorageAssetOpts{Mode: 0o644},
+        })
+        if !errors.Is(err, storage_ifaces.BAD_PATH) {
+            t.Fatalf("Unexpected error: %s", err)
+        }
+
+        if _, err := s.ReadAsset("/dir/asset0"); !errors.Is(err, storage_ifaces.BAD_PATH) {
+            t.Fatalf("Unexpected error: %s", err)
+        }
+
+        if err := s.MoveAsset("dir/asset0", "../../asset0"); !errors.Is(err, storage_ifaces.BAD_PATH) {
+            t.Fatalf("Unexpected error: %s", err)
+        }
+
+        list, err := s.List()
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        if expected := `[{"path":"dir/asset0","properties":{"mode":"420"}}]`; string(list) != expected {
+            t.Fatalf("Unexpected storage content: %s", string(list))
+        }
+    }
+}
+
+
+func TestAssetKinds(t *testing.T) {
+
+    opts := PrefixedStoragesOpts(TESTING_WS)
+
+    storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
+
+    createAsset := func(s *storage_ifaces.Storage, path string, payload string, opts storage_ifaces.StorageAssetOpts) error {
+        return s.CreateAsset(path, &storage_ifaces.StorageAssetReader{Reader: strings.NewReader(payload), Opts: opts})
+    }
+
+    for _, st := range []storage_ifaces.StorageType{storage_ifaces.StorageMemory, storage_ifaces.StoragePlainFilesystem, storage_ifaces.StorageHashedFilesystem} {
+
+        s := storagesManager.Create(st)
+        if s == nil {
+            t.Fatal("Can't create storage!")
+        }
+        defer storagesManager.Destroy(s.Id)
+
+        checkStorageOps_NewAsset(s, t, "lib/libfoo.so.1", "payload", 0o644)
+
+        symlinkOpts := storage_ifaces.StorageAssetOpts{Mode: 0o777, Kind: storage_ifaces.AssetKindSymlink, Target: "libfoo.so.1"}
+        if err := createAsset(s, "lib/libfoo.so", "", symlinkOpts); err != nil {
+            t.Fatal(err)
+        }
+
+        dirOpts := storage_ifaces.StorageAssetOpts{
+            Mode:       0o755,
+            Kind:       storage_ifaces.AssetKindDir,
+            Properties: storage_ifaces.StorageAssetProperties{"owner": "ci"},
+        }
+        if err := createAsset(s, "empty", "", dirOpts); err != nil {
+            t.Fatal(err)
+        }
+
+        if err := createAsset(s, "fifo", "", storage_ifaces.StorageAssetOpts{Kind: "fifo"}); !errors.Is(err, storage_ifaces.BAD_ASSET_OPTS) {
+            t.Fatalf("Unexpected error: %s", err)
+        }
+
+        if err := createAsset(s, "link", "", storage_ifaces.StorageAssetOpts{Kind: storage_ifaces.AssetKindSymlink}); !errors.Is(err, storage_ifaces.BAD_ASSET_OPTS) {
+            t.Fatalf("Unexpected error: %s", err)
+        }
+
+        info, err := s.StatAsset("lib/libfoo.so")
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        if !reflect.DeepEqual(info.StorageAssetOpts, symlinkOpts) || info.Size != 0 || info.ETag != symlinkOpts.KindChecksum() {
+            t.Fatalf("Unexpected symlink info: %v", info)
+        }
+
+        checkStorageOps_ReadPayload(s, t, "lib/libfoo.so", "")
+
+        // Directory asset must stay after its content removal
+        checkStorageOps_NewAsset(s, t, "empty/asset", "payload", 0o644)
+        if err := s.DeleteAsset("empty/asset"); err != nil {
+            t.Fatal(err)
+        }
+
+        list, err := s.List()
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        expected := `[{"path":"empty","properties":{"kind":"dir","mode":"493","owner":"ci"}},` +
+            `{"path":"lib/libfoo.so","properties":{"kind":"symlink","mode":"511","target":"libfoo.so.1"}},` +
+            `{"path":"lib/libfoo.so.1","properties":{"mode":"420"}}]`
+        if string(list) != expected {
+            t.Fatalf("Unexpected List() result!\nGot\t\t: %s\nExpected\t: %s", string(list), expected)
+        }
+
+        stats, err := s.Stats()
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        if stats.Assets != 3 || stats.Size != 7 {
+            t.Fatalf("Unexpected storage statistics: %v", stats)
+        }
+
+        if st == storage_ifaces.StoragePlainFilesystem {
+            if err := createAsset(s, "lib/libfoo.so/escaped", "", storage_ifaces.StorageAssetOpts{Mode: 0o644}); !errors.Is(err, storage_ifaces.BAD_PATH) {
+                t.Fatalf("Unexpected error: %s", err)
+            }
+        }
+
+        // Symlink can be replaced by file
+        checkStorageOps_WriteAsset(s, t, "lib/libfoo.so.2")
+        if err := s.MoveAsset("lib/libfoo.so.2", "lib/libfoo.so"); !errors.Is(err, storage_ifaces.ASSET_EXIST) {
+            t.Fatalf("Unexpected error: %s", err)
+        }
+
+        if err := s.DeleteAsset("lib/libfoo.so"); err != nil {
+            t.Fatal(err)
+        }
+
+        if err := s.DeleteAsset("empty"); err != nil {
+            t.Fatal(err)
+        }
+
+        if _, err := s.StatAsset("empty"); !errors.Is(err, storage_ifaces.ASSET_NOT_EXIST) {
+            t.Fatalf("Unexpected error: %s", err)
+        }
+    }
+}
+
+
+func TestAssetAttributes(t *testing.T) {
+
+    opts := PrefixedStoragesOpts(TESTING_WS)
+
+    storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
+
+    mtime := time.Date(2020, time.March, 1, 12, 30, 0, 0, time.UTC)
+    uid, gid := os.Getuid(), os.Getgid()
+    started := time.Now().UTC()
+
+    assetOpts := storage_ifaces.StorageAssetOpts{
+        Mode:   0o4755,
+        Mtime:  &mtime,
+        Uid:    &uid,
+        Gid:    &gid,
+    }
+
+    for _, st := range []storage_ifaces.StorageType{storage_ifaces.StorageMemory, storage_ifaces.StoragePlainFilesystem, storage_ifaces.StorageHashedFilesystem} {
+
+        s := storagesManager.Create(st)
+        if s == nil {
+            t.Fatal("Can't create storage!")
+        }
+        defer storagesManager.Destroy(s.Id)
+
+        err := s.CreateAsset("bin/tool", &storage_ifaces.StorageAssetReader{Reader: strings.NewReader("payload"), Opts: assetOpts})
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        badOpts := storage_ifaces.StorageAssetOpts{Mode: 0o10644}
+        err = s.CreateAsset("bin/bad", &storage_ifaces.StorageAssetReader{Reader: strings.NewReader("payload"), Opts: badOpts})
+        if !errors.Is(err, storage_ifaces.BAD_ASSET_OPTS) {
+            t.Fatalf("Unexpected error: %s", err)
+        }
+
+        info, err := s.StatAsset("bin/tool")
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        if !reflect.DeepEqual(info.StorageAssetOpts, assetOpts) {
+            t.Fatalf("Unexpected asset opts: %s", info.StorageAssetOpts.String())
+        }
+
+        // Client mtime is not the asset modification time.
+        if info.Modified.Before(started) {
+            t.Fatalf("Unexpected modification time: %s", info.Modified)
+        }
+
+        list, err := s.List()
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        expected := fmt.Sprintf(`[{"path":"bin/tool","properties":{"gid":"%d","mode":"2541","mtime":"2020-03-01T12:30:00Z","uid":"%d"}}]`, gid, uid)
+        if string(list) != expected {
+            t.Fatalf("Unexpected List() result!\nGot\t\t: %s\nExpected\t: %s", string(list), expected)
+        }
+
+        if st == storage_ifaces.StoragePlainFilesystem {
+            fi, err := os.Stat(filepath.Join(opts.StoragesRoot, s.RootName(), "bin/tool"))
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            if storage_ifaces.AssetMode(fi.Mode()) != 0o4755 || !fi.ModTime().Equal(mtime) {
+                t.Fatalf("Attributes are not applied: %s %s", fi.Mode(), fi.ModTime())
+            }
+
+            // Content modified in place with the same size and mtime.
+            assetPath := filepath.Join(opts.StoragesRoot, s.RootName(), "bin/tool")
+            if err := ioutil.WriteFile(assetPath, []byte("PAYLOAD"), 0o755); err != nil {
+                t.Fatal(err)
+            }
+            if err := os.Chtimes(assetPath, mtime, mtime); err != nil {
+                t.Fatal(err)
+            }
+
+            modifiedInfo, err := s.StatAsset("bin/tool")
+            if err != nil {
+                t.Fatal(err)
+            }
+            if modifiedInfo.ETag == info.ETag {
+                t.Fatalf("Stale ETag: %s", modifiedInfo.ETag)
+            }
+        }
+
+        // Unprivileged server can't give the file away, but the ownership
+        // is kept anyway.
+        foreign := uid + 12345
+        foreignOpts := storage_ifaces.StorageAssetOpts{Mode: 0o644, Uid: &foreign, Gid: &foreign}
+
+        err = s.CreateAsset("bin/foreign", &storage_ifaces.StorageAssetReader{Reader: strings.NewReader("payload"), Opts: foreignOpts})
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        info, err = s.StatAsset("bin/foreign")
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        if info.Uid == nil || *info.Uid != foreign || info.Gid == nil || *info.Gid != foreign {
+            t.Fatalf("Unexpected asset opts: %s", info.StorageAssetOpts.String())
+        }
+    }
+}
+
+
+func TestOpenAsset(t *testing.T) {
+
+    opts := PrefixedStoragesOpts(TESTING_WS)
+
+    storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
+
+    payloads := []string{"payload0", "replaced payload1"}
+
+    for _, st := range []storage_ifaces.StorageType{storage_ifaces.StorageMemory, storage_ifaces.StoragePlainFilesystem, storage_ifaces.StorageHashedFilesystem} {
+
+        s := storagesManager.Create(st)
+        if s == nil {
+            t.Fatal("Can't create storage!")
+        }
+        defer storagesManager.Destroy(s.Id)
+
+        if _, _, err := s.OpenAsset("asset"); !errors.Is(err, storage_ifaces.ASSET_NOT_EXIST) {
+            t.Fatalf("Unexpected error: %v", err)
+        }
+
+        checkStorageOps_NewAsset(s, t, "asset", payloads[0], 0o644)
+
+        // Info describes the opened version while the asset is replaced.
+        done := make(chan struct{})
+        go func() {
+            defer close(done)
+            for i := 1; i < 1000; i++ {
+                payload := payloads[i % len(payloads)]
+                if _, err := s.WriteAsset("asset", &storage_ifaces.StorageAssetReader{Reader: strings.NewReader(payload), Opts: storage_ifaces.StorageAssetOpts{Mode: 0o644}}, storage_ifaces.StorageAssetCond{}); err != nil {
+                    t.Error(err)
+                    return
+                }
+            }
+        }()
+
+        for i := 0; i < 1000; i++ {
+            info, r, err := s.OpenAsset("asset")
+            if err != nil {
+                continue
+            }
+
+            payload, err := ioutil.ReadAll(r)
+            r.Close()
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            if info.Size != int64(len(payload)) || info.ETag != fmt.Sprintf("%x", sha256.Sum256(payload)) {
+                t.Fatalf("Unexpected info: %s for payload: %s", info, payload)
+            }
+        }
+
+        <-done
+    }
+}
+
+
+func TestVaultRefs(t *testing.T) {
+
+    opts := PrefixedStoragesOpts(TESTING_WS)
+
+    storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
+
+    s1 := storagesManager.Create(storage_ifaces.StorageHashedFilesystem)
+    s2 := storagesManager.Create(storage_ifaces.StorageHashedFilesystem)
+    if s1 == nil || s2 == nil {
+        t.Fatal("Can't create storage!")
+    }
+    defer storagesManager.Destroy(s1.Id)
+    defer storagesManager.Destroy(s2.Id)
+
+    checkStorageOps_NewAsset(s1, t, "a/shared", "shared payload", 0o644)
+    checkStorageOps_NewAsset(s2, t, "b/shared", "shared payload", 0o644)
+
+    object := fmt.Sprintf("%x", sha256.Sum256([]byte("shared payload")))
+    if !storage_ifaces.IsVaultObject(object) || storage_ifaces.IsVaultObject("../" + object[3:]) {
+        t.Fatal("Unexpected vault object id check result!")
+    }
+
+    expected := []storage_ifaces.VaultRef{{StorageId: s1.Id, Path: "a/shared"}, {StorageId: s2.Id, Path: "b/shared"}}
+    if s2.Id.Id < s1.Id.Id {
+        expected[0], expected[1] = expected[1], expected[0]
+    }
+
+    if refs := storagesManager.Vault().Refs(object); !reflect.DeepEqual(refs, expected) {
+        t.Fatalf("Unexpected refs: %v", refs)
+    }
+
+    f, err := storagesManager.Vault().OpenObject(storage_ifaces.VaultAsset{Object: object})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    data, err := ioutil.ReadAll(f.File)
+    f.Close()
+    if err != nil || string(data) != "shared payload" {
+        t.Fatalf("Unexpected object content: %s err: %v", string(data), err)
+    }
+
+    if err := s2.DeleteAsset("b/shared"); err != nil {
+        t.Fatal(err)
+    }
+
+    if refs := storagesManager.Vault().Refs(object); len(refs) != 1 || refs[0].StorageId != s1.Id {
+        t.Fatalf("Unexpected refs: %v", refs)
+    }
+}
+
+
+func TestLinkAsset(t *testing.T) {
+
+    opts := PrefixedStoragesOpts(TESTING_WS)
+
+    storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
+
+    src := storagesManager.Create(storage_ifaces.StorageHashedFilesystem)
+    if src == nil {
+        t.Fatal("Can't create storage!")
+    }
+    defer storagesManager.Destroy(src.Id)
+
+    checkStorageOps_NewAsset(src, t, "src/shared", "shared payload", 0o644)
+
+    object := fmt.Sprintf("%x", sha256.Sum256([]byte("shared payload")))
+    unknown := fmt.Sprintf("%x", sha256.Sum256([]byte("unknown payload")))
+
+    for _, st := range []storage_ifaces.StorageType{storage_ifaces.StorageMemory, storage_ifaces.StoragePlainFilesystem, storage_ifaces.StorageHashedFilesystem} {
+
+        s := storagesManager.Create(st)
+        if s == nil {
+            t.Fatal("Can't create storage!")
+        }
+        defer storagesManager.Destroy(s.Id)
+
+        assetOpts := storage_ifaces.StorageAssetOpts{Mode: 0o600, Properties: storage_ifaces.StorageAssetProperties{"linked": "yes"}}
+
+        etag, err := s.LinkAsset("dst/linked", object, assetOpts, storage_ifaces.StorageAssetCond{IfNoneMatch: "*"})
+        if err != nil {
+            t.Fatal(err)
+        }
+        if etag != object {
+            t.Fatalf("Unexpected etag: %s", etag)
+        }
+
+        // Relink to the same object must keep the reference
+        if _, err := s.LinkAsset("dst/linked", object, assetOpts, storage_ifaces.StorageAssetCond{IfMatch: object}); err != nil {
+            t.Fatal(err)
+        }
+
+        if _, err := s.LinkAsset("dst/linked", object, assetOpts, storage_ifaces.StorageAssetCond{IfNoneMatch: "*"}); !errors.Is(err, storage_ifaces.PRECONDITION_FAILED) {
+            t.Fatalf("Unexpected error: %s", err)
+        }
+
+        if _, err := s.LinkAsset("dst/unknown", unknown, assetOpts, storage_ifaces.StorageAssetCond{}); !errors.Is(err, storage_ifaces.OBJECT_NOT_EXIST) {
+            t.Fatalf("Unexpected error: %s", err)
+        }
+
+        if _, err := s.LinkAsset("dst/bad", "../" + object, assetOpts, storage_ifaces.StorageAssetCond{}); !errors.Is(err, storage_ifaces.BAD_ASSET_OPTS) {
+            t.Fatalf("Unexpected error: %s", err)
+        }
+
+        info, err := s.StatAsset("dst/linked")
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        if info.Size != int64(len("shared payload")) || !reflect.DeepEqual(info.StorageAssetOpts, assetOpts) {
+            t.Fatalf("Unexpected asset info: %v", info)
+        }
+
+        checkStorageOps_ReadPayload(s, t, "dst/linked", "shared payload")
+
+        refs := storagesManager.Vault().Refs(object)
+        linked := false
+        for _, ref := range refs {
+            linked = linked || (ref.StorageId == s.Id && ref.Path == "dst/linked")
+        }
+        if linked != (st == storage_ifaces.StorageHashedFilesystem) {
+            t.Fatalf("Unexpected refs: %v", refs)
+        }
+
+        // Failed relink keeps the replaced asset referenced.
+        if linker, ok := s.Ops.(storage_ifaces.StorageLinker); ok {
+            if _, err := linker.LinkAsset(s, "dst/linked", unknown, assetOpts, storage_ifaces.StorageAssetCond{}); !errors.Is(err, storage_ifaces.OBJECT_NOT_EXIST) {
+                t.Fatalf("Unexpected error: %v", err)
+            }
+
+            if refs := storagesManager.Vault().Refs(unknown); len(refs) != 0 {
+                t.Fatalf("Unexpected refs: %v", refs)
+            }
+            if !reflect.DeepEqual(storagesManager.Vault().Refs(object), refs) {
+                t.Fatalf("Unexpected refs: %v", storagesManager.Vault().Refs(object))
+            }
+
+            checkStorageOps_ReadPayload(s, t, "dst/linked", "shared payload")
+        }
+    }
+
+    // Linked object must outlive the source asset
+    if err := src.DeleteAsset("src/shared"); err != nil {
+        t.Fatal(err)
+    }
+
+    if _, err := storagesManager.Vault().StatObject(storage_ifaces.VaultAsset{Object: object}); err != nil {
+        t.Fatal(err)
+    }
+
+    quoted := storagesManager.Create(storage_ifaces.StorageHashedFilesystem)
+    if quoted == nil {
+        t.Fatal("Can't create storage!")
+    }
+    defer storagesManager.Destroy(quoted.Id)
+
+    if err := storagesManager.SetQuota(quoted.Id, storage_ifaces.StorageQuota{MaxSize: 4}); err != nil {
+        t.Fatal(err)
+    }
+
+    if _, err := quoted.LinkAsset("linked", object, storage_ifaces.StorageAssetOpts{Mode: 0o644}, storage_ifaces.StorageAssetCond{}); !errors.Is(err, storage_ifaces.ASSET_TOO_LARGE) {
+        t.Fatalf("Unexpected error: %s", err)
+    }
+
+    if _, err := quoted.StatAsset("linked"); !errors.Is(err, storage_ifaces.ASSET_NOT_EXIST) {
+        t.Fatalf("Unexpected error: %s", err)
+    }
+}
+
+
+func TestDigestVerification(t *testing.T) {
+
+    opts := PrefixedStoragesOpts(TESTING_WS)
+
+    storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
+
+    payload := "verified payload"
+
+    good := storage_ifaces.StorageAssetDigests{}
+    if err := good.Add(storage_ifaces.DigestSha256, fmt.Sprintf("%X", sha256.Sum256([]byte(payload)))); err != nil {
+        t.Fatal(err)
+    }
+    if err := good.Add(storage_ifaces.DigestSha256, fmt.Sprintf("%x", sha256.Sum256([]byte(payload + "!")))); !errors.Is(err, storage_ifaces.BAD_DIGEST) {
+        t.Fatalf("Unexpected error: %s", err)
+    }
+    if err := good.Add("crc32", "00000000"); !errors.Is(err, storage_ifaces.BAD_DIGEST) {
+        t.Fatalf("Unexpected error: %s", err)
+    }
+    if err := good.Add(storage_ifaces.DigestMd5, "abc"); !errors.Is(err, storage_ifaces.BAD_DIGEST) {
+        t.Fatalf("Unexpected error: %s", err)
+    }
+
+    bad := storage_ifaces.StorageAssetDigests{storage_ifaces.DigestSha256: fmt.Sprintf("%x", sha256.Sum256([]byte(payload[:8])))}
+
+    for _, st := range []storage_ifaces.StorageType{storage_ifaces.StorageMemory, storage_ifaces.StoragePlainFilesystem, storage_ifaces.StorageHashedFilesystem} {
+
+        s := storagesManager.Create(st)
+        if s == nil {
+            t.Fatal("Can't create storage!")
+        }
+        defer storagesManager.Destroy(s.Id)
+
+        write := func(path string, digests storage_ifaces.StorageAssetDigests) error {
+            return s.CreateAsset(path, &storage_ifaces.StorageAssetReader{
+                Reader: storage_ifaces.NewDigestReader(strings.NewReader(payload), digests),
+                Opts:   storage_ifaces.StorageAssetOpts{Mode: 0o644},
+            })
+        }
+
+        if err := write("good", good); err != nil {
+            t.Fatal(err)
+        }
+
+        if err := write("bad", bad); !errors.Is(err, storage_ifaces.DIGEST_MISMATCH) {
+            t.Fatalf("Unexpected error: %s", err)
+        }
+
+        if _, err := s.StatAsset("bad"); !errors.Is(err, storage_ifaces.ASSET_NOT_EXIST) {
+            t.Fatalf("Unexpected error: %s", err)
+        }
+
+        bid, err := storagesManager.Buffers().Create()
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        if _, err := storagesManager.Buffers().Append(bid, strings.NewReader(payload[:8])); err != nil {
+            t.Fatal(err)
+        }
+
+        // Rejected chunk must not be appended
+        if _, err := storagesManager.Buffers().Append(bid, storage_ifaces.NewDigestReader(strings.NewReader("garbage"), bad)); !errors.Is(err, storage_ifaces.DIGEST_MISMATCH) {
+            t.Fatalf("Unexpected error: %s", err)
+        }
+
+        if _, err := storagesManager.Buffers().Append(bid, strings.NewReader(payload[8:])); err != nil {
+            t.Fatal(err)
+        }
+
+        err = storagesManager.CreateVerifiedStorageAssetFromBuffer(s.Id, "buffered_bad", bid, storage_ifaces.StorageAssetOpts{Mode: 0o644}, bad)
+        if !errors.Is(err, storage_ifaces.DIGEST_MISMATCH) {
+            t.Fatalf("Unexpected error: %s", err)
+        }
+
+        err = storagesManager.CreateVerifiedStorageAssetFromBuffer(s.Id, "buffered", bid, storage_ifaces.StorageAssetOpts{Mode: 0o644}, good)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        storagesManager.Buffers().Discard(bid)
+
+        checkStorageOps_ReadPayload(s, t, "buffered", payload)
+
+        list, err := s.List()
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        expected := `[{"path":"buffered","properties":{"mode":"420"}},{"path":"good","properties":{"mode":"420"}}]`
+        if string(list) != expected {
+            t.Fatalf("Unexpected List() result!\nGot\t\t: %s\nExpected\t: %s", string(list), expected)
+        }
+    }
+
+    // Rejected uploads must not leave temp files
+    tempFiles, err := ioutil.ReadDir(opts.TempDir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(tempFiles) != 0 {
+        t.Fatalf("Temp files are left: %d", len(tempFiles))
+    }
+}
+
+
+func TestVaultAlgorithms(t *testing.T) {
+
+    opts := PrefixedStoragesOpts(filepath.Join(TESTING_WS, "sha512"))
+    opts.VaultAlgorithm   = storage_ifaces.DigestSha512
+    opts.SecondaryDigests = []string{storage_ifaces.DigestMd5, storage_ifaces.DigestSha256}
+
+    storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
+
+    s := storagesManager.Create(storage_ifaces.StorageHashedFilesystem)
+    if s == nil {
+        t.Fatal("Can't create storage!")
+    }
+    defer storagesManager.Destroy(s.Id)
+
+    payload := "multi digest payload"
+    checkStorageOps_NewAsset(s, t, "asset", payload, 0o644)
+
+    expected := storage_ifaces.StorageAssetDigests{
+        storage_ifaces.DigestMd5:       fmt.Sprintf("%x", md5.Sum([]byte(payload))),
+        storage_ifaces.DigestSha256:    fmt.Sprintf("%x", sha256.Sum256([]byte(payload))),
+        storage_ifaces.DigestSha512:    fmt.Sprintf("%x", sha512.Sum512([]byte(payload))),
+    }
+
+    info, err := s.StatAsset("asset")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if info.ETag != expected[storage_ifaces.DigestSha256] || !reflect.DeepEqual(info.Digests, expected) {
+        t.Fatalf("Unexpected asset info: %v digests: %s", info, info.Digests.String())
+    }
+
+    object := storage_ifaces.MakeVaultObject(storage_ifaces.DigestSha512, expected[storage_ifaces.DigestSha512])
+
+    if refs := storagesManager.Vault().Refs(object); len(refs) != 1 || refs[0].Path != "asset" {
+        t.Fatalf("Unexpected refs: %v", refs)
+    }
+
+    if _, err := os.Stat(filepath.Join(opts.VaultRoot, storage_ifaces.DigestSha512)); err != nil {
+        t.Fatal(err)
+    }
+
+    if _, err := s.LinkAsset("linked", object, storage_ifaces.StorageAssetOpts{Mode: 0o644}, storage_ifaces.StorageAssetCond{}); err != nil {
+        t.Fatal(err)
+    }
+
+    checkStorageOps_ReadPayload(s, t, "linked", payload)
+
+    // Linked asset has the same digests and ETag as the written one.
+    linked, err := s.StatAsset("linked")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if linked.ETag != info.ETag || !reflect.DeepEqual(linked.Digests, expected) {
+        t.Fatalf("Unexpected linked asset info: %v digests: %s", linked, linked.Digests.String())
+    }
+
+    if l := storage_ifaces.MaxVaultObjectLen(); l != len(object) {
+        t.Fatalf("Unexpected max vault object length: %d", l)
+    }
+
+    if storage_ifaces.IsVaultObject("sha512:" + expected[storage_ifaces.DigestSha256]) || storage_ifaces.IsVaultObject("crc32:00000000") {
+        t.Fatal("Unexpected vault object id check result!")
+    }
+
+    // Unknown content sha256 is not reported.
+    opts = PrefixedStoragesOpts(filepath.Join(TESTING_WS, "sha512only"))
+    opts.VaultAlgorithm = storage_ifaces.DigestSha512
+
+    storagesManager = NewStoragesManager(opts)
+    defer storagesManager.Close()
+
+    s = storagesManager.Create(storage_ifaces.StorageHashedFilesystem)
+    if s == nil {
+        t.Fatal("Can't create storage!")
+    }
+    defer storagesManager.Destroy(s.Id)
+
+    checkStorageOps_NewAsset(s, t, "asset", payload, 0o644)
+
+    info, err = s.StatAsset("asset")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if info.ETag != object || !reflect.DeepEqual(info.Digests, storage_ifaces.StorageAssetDigests{storage_ifaces.DigestSha512: expected[storage_ifaces.DigestSha512]}) {
+        t.Fatalf("Unexpected asset info: %v digests: %s", info, info.Digests.String())
+    }
+}
+
+
+func TestLegacyVaultObjects(t *testing.T) {
+
+    opts := PrefixedStoragesOpts(filepath.Join(TESTING_WS, "legacy"))
+    opts.ReaperInterval = 0
+
+    os.RemoveAll(filepath.Join(TESTING_WS, "legacy"))
+
+    s := NewStoragesManager(opts).Create(storage_ifaces.StorageHashedFilesystem)
+    if s == nil {
+        t.Fatal("Can't create storage!")
+    }
+
+    payload := "legacy payload"
+    checkStorageOps_NewAsset(s, t, "asset", payload, 0o644)
+
+    hex := fmt.Sprintf("%x", sha256.Sum256([]byte(payload)))
+
+    // Downgrade refs database and storage metadata to the bare hex ids
+    for _, file := range []string{filepath.Join(opts.VaultRoot, "refs.db"), filepath.Join(opts.StoragesRoot, s.RootName(), "metadata.json")} {
+        data, err := ioutil.ReadFile(file)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        if !bytes.Contains(data, []byte("sha256:" + hex)) {
+            t.Fatalf("No canonical object id in: %s", file)
+        }
+
+        if err := ioutil.WriteFile(file, bytes.ReplaceAll(data, []byte("sha256:"), nil), 0o600); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
+
+    s = storagesManager.Get(s.Id)
+    if s == nil {
+        t.Fatal("Storage is not restored!")
+    }
+
+    checkStorageOps_ReadPayload(s, t, "asset", payload)
+
+    info, err := s.StatAsset("asset")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if info.ETag != hex {
+        t.Fatalf("Unexpected ETag: %s", info.ETag)
+    }
+
+    if refs := storagesManager.Vault().Refs(hex); len(refs) != 1 {
+        t.Fatalf("Unexpected refs: %v", refs)
+    }
+
+    // New reference to the same content shares the legacy object
+    checkStorageOps_NewAsset(s, t, "copy", payload, 0o644)
+
+    if err := s.DeleteAsset("asset"); err != nil {
+        t.Fatal(err)
+    }
+
+    checkStorageOps_ReadPayload(s, t, "copy", payload)
+
+    if err := storagesManager.Destroy(s.Id); err != nil {
+        t.Fatal(err)
+    }
+
+    if _, err := storagesManager.Vault().StatObject(storage_ifaces.VaultAsset{Object: hex}); !os.IsNotExist(err) {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+}
+
+
+func TestWriteArchive(t *testing.T) {
+
+    opts := PrefixedStoragesOpts(TESTING_WS)
+
+    storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
+
+    mtime := time.Date(2021, time.June, 1, 8, 0, 0, 0, time.UTC)
+
+    for _, st := range []storage_ifaces.StorageType{storage_ifaces.StorageMemory, storage_ifaces.StoragePlainFilesystem, storage_ifaces.StorageHashedFilesystem} {
+
+        s := storagesManager.Create(st)
+        if s == nil {
+            t.Fatal("Can't create storage!")
+        }
+        defer storagesManager.Destroy(s.Id)
+
+        create := func(path string, payload string, opts storage_ifaces.StorageAssetOpts) {
+            if err := s.CreateAsset(path, &storage_ifaces.StorageAssetReader{Reader: strings.NewReader(payload), Opts: opts}); err != nil {
+                t.Fatal(err)
+            }
+        }
+
+        create("dir/a", "payload a", storage_ifaces.StorageAssetOpts{Mode: 0o4755, Mtime: &mtime, Properties: storage_ifaces.StorageAssetProperties{"arch": "x86_64"}})
+        create("dir/link", "", storage_ifaces.StorageAssetOpts{Kind: storage_ifaces.AssetKindSymlink, Target: "a"})
+        create("dir/empty", "", storage_ifaces.StorageAssetOpts{Mode: 0o750, Kind: storage_ifaces.AssetKindDir})
+        create("dir2/b", "payload b", storage_ifaces.StorageAssetOpts{Mode: 0o644})
+
+        if err := s.WriteArchive(ioutil.Discard, storage_ifaces.StorageArchiveOpts{Format: "rar"}); !errors.Is(err, storage_ifaces.BAD_ARCHIVE) {
+            t.Fatalf("Unexpected error: %s", err)
+        }
+
+        var buf bytes.Buffer
+        if err := s.WriteArchive(&buf, storage_ifaces.StorageArchiveOpts{Format: storage_ifaces.ArchiveTarGz, Prefix: "dir/"}); err != nil {
+            t.Fatal(err)
+        }
+
+        gr, err := gzip.NewReader(&buf)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        tr := tar.NewReader(gr)
+
+        entries := make([]string, 0)
+        for {
+            hdr, err := tr.Next()
+            if err == io.EOF {
+                break
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            data, err := ioutil.ReadAll(tr)
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            entries = append(entries, fmt.Sprintf("%s %c %o %s %s %s", hdr.Name, hdr.Typeflag, hdr.Mode, hdr.Linkname, data, hdr.PAXRecords["STORAGES.property.arch"]))
+
+            if hdr.Name == "dir/a" && !hdr.ModTime.Equal(mtime) {
+                t.Fatalf("Unexpected mtime: %s", hdr.ModTime)
+            }
+        }
+
+        expected := []string{
+            "dir/a 0 4755  payload a x86_64",
+            "dir/empty/ 5 750   ",
+            "dir/link 2 777 a  ",
+        }
+        if !reflect.DeepEqual(entries, expected) {
+            t.Fatalf("Unexpected archive entries!\nGot\t\t: %q\nExpected\t: %q", entries, expected)
+        }
+
+        // Entry header and content are of the same version of the asset
+        // replaced during archiving.
+        versions := []string{"short", "much longer payload"}
+        create("race/asset", versions[0], storage_ifaces.StorageAssetOpts{Mode: 0o644})
+
+        stop := make(chan struct{})
+        done := make(chan struct{})
+        go func() {
+            defer close(done)
+            for i := 1; ; i++ {
+                select {
+                case <-stop:
+                    return
+                default:
+                }
+
+                s.WriteAsset("race/asset", &storage_ifaces.StorageAssetReader{
+                    Reader: strings.NewReader(versions[i % 2]),
+                    Opts: storage_ifaces.StorageAssetOpts{Mode: 0o644},
+                }, storage_ifaces.StorageAssetCond{})
+                time.Sleep(100 * time.Microsecond)
+            }
+        }()
+
+        archived := func() (string, error) {
+            buf.Reset()
+            if err := s.WriteArchive(&buf, storage_ifaces.StorageArchiveOpts{Format: storage_ifaces.ArchiveTar, Prefix: "race"}); err != nil {
+                return "", err
+            }
+
+            tr := tar.NewReader(&buf)
+            if _, err := tr.Next(); err != nil {
+                return "", err
+            }
+
+            data, err := ioutil.ReadAll(tr)
+            return string(data), err
+        }
+
+        var raceErr error
+        for i := 0; i < 500 && raceErr == nil; i++ {
+            data, err := archived()
+            if err == nil && data != versions[0] && data != versions[1] {
+                err = fmt.Errorf("Unexpected archived asset: %q", data)
+            }
+            raceErr = err
+        }
+
+        close(stop)
+        <-done
+
+        if raceErr != nil {
+            t.Fatal(raceErr)
+        }
+    }
+}
+
+
+func TestImportArchive(t *testing.T) {
+
+    opts := PrefixedStoragesOpts(TESTING_WS)
+
+    storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
+
+    mtime := time.Date(2021, time.June, 1, 8, 0, 0, 0, time.UTC)
+
+    for _, st := range []storage_ifaces.StorageType{storage_ifaces.StorageMemory, storage_ifaces.StoragePlainFilesystem, storage_ifaces.StorageHashedFilesystem} {
+
+        src := storagesManager.Create(st)
+        if src == nil {
+            t.Fatal("Can't create storage!")
+        }
+        defer storagesManager.Destroy(src.Id)
+
+        s := storagesManager.Create(st)
+        if s == nil {
+            t.Fatal("Can't create storage!")
+        }
+        defer storagesManager.Destroy(s.Id)
+
+        create := func(s *storage_ifaces.Storage, path string, payload string, opts storage_ifaces.StorageAssetOpts) {
+            if err := s.CreateAsset(path, &storage_ifaces.StorageAssetReader{Reader: strings.NewReader(payload), Opts: opts}); err != nil {
+                t.Fatal(err)
+            }
+        }
+
+        create(src, "dir/a", "payload a", storage_ifaces.StorageAssetOpts{Mode: 0o755, Mtime: &mtime, Properties: storage_ifaces.StorageAssetProperties{"arch": "x86_64"}})
+        create(src, "dir/link", "", storage_ifaces.StorageAssetOpts{Kind: storage_ifaces.AssetKindSymlink, Target: "a"})
+        create(src, "dir/empty", "", storage_ifaces.StorageAssetOpts{Mode: 0o750, Kind: storage_ifaces.AssetKindDir})
+
+        var buf bytes.Buffer
+        if err := src.WriteArchive(&buf, storage_ifaces.StorageArchiveOpts{Format: storage_ifaces.ArchiveTarGz}); err != nil {
+            t.Fatal(err)
+        }
+        archive := buf.Bytes()
+
+        // Format is detected by the content.
+        entries, err := s.ImportArchive(bytes.NewReader(archive), storage_ifaces.StorageArchiveOpts{Prefix: "imp"})
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(entries) != 3 || entries[0].Path != "imp/dir/a" || entries[0].Size != 9 || len(entries[0].ETag) == 0 {
+            t.Fatalf("Unexpected import entries: %v", entries)
+        }
+
+        info, err := s.StatAsset("imp/dir/a")
+        if err != nil {
+            t.Fatal(err)
+        }
+        if info.Mode != 0o755 || info.Mtime == nil || !info.Mtime.Equal(mtime) || info.Properties["arch"] != "x86_64" {
+            t.Fatalf("Unexpected imported asset: %s", info)
+        }
+
+        info, err = s.StatAsset("imp/dir/link")
+        if err != nil || info.Kind != storage_ifaces.AssetKindSymlink || info.Target != "a" {
+            t.Fatalf("Unexpected imported symlink: %v, err: %v", info, err)
+        }
+
+        info, err = s.StatAsset("imp/dir/empty")
+        if err != nil || info.Kind != storage_ifaces.AssetKindDir || info.Mode != 0o750 {
+            t.Fatalf("Unexpected imported dir: %v, err: %v", info, err)
+        }
+
+        // Existing assets are never replaced and the partial import is
+        // rolled back.
+        create(s, "conflict/dir/link", "", storage_ifaces.StorageAssetOpts{})
+
+        entries, err = s.ImportArchive(bytes.NewReader(archive), storage_ifaces.StorageArchiveOpts{Format: storage_ifaces.ArchiveTarGz, Prefix: "conflict"})
+        if !errors.Is(err, storage_ifaces.ASSET_EXIST) {
+            t.Fatalf("Unexpected error: %v", err)
+        }
+        if len(entries) == 0 || len(entries[len(entries)-1].Error) == 0 {
+            t.Fatalf("Unexpected import entries: %v", entries)
+        }
+        if _, err := s.StatAsset("conflict/dir/a"); !errors.Is(err, storage_ifaces.ASSET_NOT_EXIST) {
+            t.Fatalf("Import is not rolled back: %v", err)
+        }
+        if _, err := s.StatAsset("conflict/dir/link"); err != nil {
+            t.Fatalf("Existing asset is removed: %v", err)
+        }
+
+        // Truncated archive.
+        _, err = s.ImportArchive(bytes.NewReader(archive[:len(archive) - 10]), storage_ifaces.StorageArchiveOpts{Prefix: "truncated"})
+        if !errors.Is(err, storage_ifaces.BAD_ARCHIVE) {
+            t.Fatalf("Unexpected error: %v", err)
+        }
+        if _, err := s.StatAsset("truncated/dir/a"); !errors.Is(err, storage_ifaces.ASSET_NOT_EXIST) {
+            t.Fatalf("Import is not rolled back: %v", err)
+        }
+
+        // Zip archive.
+        buf.Reset()
+        zw := zip.NewWriter(&buf)
+
+        fh := &zip.FileHeader{Name: "z/file", Method: zip.Deflate, Modified: mtime}
+        fh.SetMode(0o640)
+        fw, err := zw.CreateHeader(fh)
+        if err != nil {
+            t.Fatal(err)
+        }
+        fw.Write([]byte("zipped"))
+
+        fh = &zip.FileHeader{Name: "z/link"}
+        fh.SetMode(os.ModeSymlink | 0o777)
+        fw, err = zw.CreateHeader(fh)
+        if err != nil {
+            t.Fatal(err)
+        }
+        fw.Write([]byte("file"))
+
+        fh = &zip.FileHeader{Name: "z/dir/"}
+        fh.SetMode(os.ModeDir | 0o700)
+        if _, err := zw.CreateHeader(fh); err != nil {
+            t.Fatal(err)
+        }
+
+        if err := zw.Close(); err != nil {
+            t.Fatal(err)
+        }
+
+        if _, err := s.ImportArchive(bytes.NewReader(buf.Bytes()), storage_ifaces.StorageArchiveOpts{Format: storage_ifaces.ArchiveZip}); err != nil {
+            t.Fatal(err)
+        }
+
+        checkStorageOps_StatAsset(s, t, "z/file", "zipped", 0o640)
+
+        info, err = s.StatAsset("z/link")
+        if err != nil || info.Kind != storage_ifaces.AssetKindSymlink || info.Target != "file" {
+            t.Fatalf("Unexpected imported symlink: %v, err: %v", info, err)
+        }
+
+        info, err = s.StatAsset("z/dir")
+        if err != nil || info.Kind != storage_ifaces.AssetKindDir || info.Mode != 0o700 {
+            t.Fatalf("Unexpected imported dir: %v, err: %v", info, err)
+        }
+
+        // Tar of the directory content ('tar -C dir -cf x.tar .').
+        buf.Reset()
+        tw := tar.NewWriter(&buf)
+
+        for _, hdr := range []*tar.Header{
+            {Name: "./", Typeflag: tar.TypeDir, Mode: 0o755},
+            {Name: "./sub/", Typeflag: tar.TypeDir, Mode: 0o750},
+            {Name: "./sub/file", Typeflag: tar.TypeReg, Mode: 0o644, Size: 6},
+        } {
+            if err := tw.WriteHeader(hdr); err != nil {
+                t.Fatal(err)
+            }
+            if hdr.Size > 0 {
+                tw.Write([]byte("dotted"))
+            }
+        }
+
+        if err := tw.Close(); err != nil {
+            t.Fatal(err)
+        }
+
+        for _, prefix := range []string{"", "dotted"} {
+            entries, err = s.ImportArchive(bytes.NewReader(buf.Bytes()), storage_ifaces.StorageArchiveOpts{Format: storage_ifaces.ArchiveTar, Prefix: prefix})
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(entries) != 2 || entries[0].Path != path.Join(prefix, "sub") || entries[1].Path != path.Join(prefix, "sub/file") {
+                t.Fatalf("Unexpected import entries: %v", entries)
+            }
+
+            checkStorageOps_StatAsset(s, t, path.Join(prefix, "sub/file"), "dotted", 0o644)
+        }
+    }
+}
+
+
+func TestZipArchive(t *testing.T) {
+
+    opts := PrefixedStoragesOpts(TESTING_WS)
+
+    storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
+
+    mtime := time.Date(2021, time.June, 1, 8, 0, 0, 0, time.UTC)
+
+    for _, st := range []storage_ifaces.StorageType{storage_ifaces.StorageMemory, storage_ifaces.StoragePlainFilesystem, storage_ifaces.StorageHashedFilesystem} {
+
+        archives := make([][]byte, 0, 2)
+
+        // Same assets created in the different order give the same archive.
+        for _, order := range [][]int{{0, 1, 2, 3}, {3, 2, 1, 0}} {
+            s := storagesManager.Create(st)
+            if s == nil {
+                t.Fatal("Can't create storage!")
+            }
+            defer storagesManager.Destroy(s.Id)
+
+            assets := []struct{
+                path    string
+                payload string
+                opts    storage_ifaces.StorageAssetOpts
+            }{
+                {"dir/b", "payload b", storage_ifaces.StorageAssetOpts{Mode: 0o755}},
+                {"dir/a", "payload a", storage_ifaces.StorageAssetOpts{Mode: 0o644, Mtime: &mtime}},
+                {"dir/link", "", storage_ifaces.StorageAssetOpts{Kind: storage_ifaces.AssetKindSymlink, Target: "a"}},
+                {"dir/empty", "", storage_ifaces.StorageAssetOpts{Mode: 0o750, Kind: storage_ifaces.AssetKindDir}},
+            }
+
+            for _, i := range order {
+                err := s.CreateAsset(assets[i].path, &storage_ifaces.StorageAssetReader{Reader: strings.NewReader(assets[i].payload), Opts: assets[i].opts})
+                if err != nil {
+                    t.Fatal(err)
+                }
+            }
+
+            archiveOpts := storage_ifaces.StorageArchiveOpts{Format: storage_ifaces.ArchiveZip, Mtime: &mtime}
+
+            var buf bytes.Buffer
+            if err := s.WriteArchive(&buf, archiveOpts); err != nil {
+                t.Fatal(err)
+            }
+
+            // Archiving the same storage twice gives the same archive.
+            var again bytes.Buffer
+            if err := s.WriteArchive(&again, archiveOpts); err != nil {
+                t.Fatal(err)
+            }
+            if !bytes.Equal(buf.Bytes(), again.Bytes()) {
+                t.Fatal("Zip archive is not reproducible!")
+            }
+
+            archives = append(archives, buf.Bytes())
+        }
+
+        if !bytes.Equal(archives[0], archives[1]) {
+            t.Fatal("Zip archives of the same assets differ!")
+        }
+
+        zr, err := zip.NewReader(bytes.NewReader(archives[0]), int64(len(archives[0])))
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        entries := make([]string, 0)
+        for _, f := range zr.File {
+            fr, err := f.Open()
+            if err != nil {
+                t.Fatal(err)
+            }
+            data, err := ioutil.ReadAll(fr)
+            fr.Close()
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            entries = append(entries, fmt.Sprintf("%s %s %d %s", f.Name, f.Mode(), f.Method, data))
+
+            if !f.Modified.Equal(mtime) {
+                t.Fatalf("Unexpected mtime: %s", f.Modified)
+            }
+        }
+
+        expected := []string{
+            "dir/a -rw-r--r-- 8 payload a",
+            "dir/b -rwxr-xr-x 8 payload b",
+            "dir/empty/ drwxr-x--- 0 ",
+            "dir/link Lrwxrwxrwx 0 a",
+        }
+        if !reflect.DeepEqual(entries, expected) {
+            t.Fatalf("Unexpected archive entries!\nGot\t\t: %q\nExpected\t: %q", entries, expected)
+        }
+    }
+
+    s := storagesManager.Create(storage_ifaces.StorageMemory)
+    defer storagesManager.Destroy(s.Id)
+
+    if err := s.CreateAsset("a", &storage_ifaces.StorageAssetReader{Reader: strings.NewReader("payload"), Opts: storage_ifaces.StorageAssetOpts{Mode: 0o644}}); err != nil {
+        t.Fatal(err)
+    }
+
+    var buf bytes.Buffer
+    if err := s.WriteArchive(&buf, storage_ifaces.StorageArchiveOpts{Format: storage_ifaces.ArchiveZip, Compression: storage_ifaces.ArchiveStore}); err != nil {
+        t.Fatal(err)
+    }
+
+    zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(zr.File) != 1 || zr.File[0].Method != zip.Store {
+        t.Fatalf("Unexpected zip entries: %v", zr.File)
+    }
+
+    err = s.WriteArchive(ioutil.Discard, storage_ifaces.StorageArchiveOpts{Format: storage_ifaces.ArchiveTar, Compression: storage_ifaces.ArchiveStore})
+    if !errors.Is(err, storage_ifaces.BAD_ARCHIVE) {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+}
+
+
+func TestCheckVault(t *testing.T) {
+
+    opts := PrefixedStoragesOpts(filepath.Join(TESTING_WS, "fsck"))
+    opts.ReaperInterval = 0
+
+    os.RemoveAll(filepath.Join(TESTING_WS, "fsck"))
+
+    storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
+    vault := storagesManager.Vault()
+
+    s := storagesManager.Create(storage_ifaces.StorageHashedFilesystem)
+    if s == nil {
+        t.Fatal("Can't create storage!")
+    }
+
+    // Storages not keeping assets in the vault are skipped.
+    plain := storagesManager.Create(storage_ifaces.StoragePlainFilesystem)
+    if plain == nil {
+        t.Fatal("Can't create storage!")
+    }
+    checkStorageOps_NewAsset(plain, t, "a", "payload a", 0o644)
+
+    for _, path := range []string{"a", "b", "b2", "c"} {
+        checkStorageOps_NewAsset(s, t, path, "payload " + path[:1], 0o644)
+    }
+
+    object := func(payload string) string {
+        return storage_ifaces.MakeVaultObject(storage_ifaces.DigestSha256, fmt.Sprintf("%x", sha256.Sum256([]byte(payload))))
+    }
+
+    ref := func(object string, path string) storage_ifaces.VaultObjectRef {
+        return storage_ifaces.VaultObjectRef{Object: object, VaultRef: storage_ifaces.VaultRef{StorageId: s.Id, Path: path}}
+    }
+
+    report, err := storagesManager.CheckVault(storage_ifaces.VaultCheckOpts{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !report.Clean() || report.Objects != 3 || report.Refs != 4 {
+        t.Fatalf("Unexpected report: %+v", report)
+    }
+
+    // Object put to the vault without the storage metadata update.
+    tmp := filepath.Join(opts.TempDir, "orphan")
+    if err := ioutil.WriteFile(tmp, []byte("orphan"), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    orphan := storage_ifaces.VaultAsset{Object: object("orphan"), Path: "lost"}
+    if err := vault.Put(s, orphan, tmp); err != nil {
+        t.Fatal(err)
+    }
+
+    // Lost reference.
+    vault.Unref(s, storage_ifaces.VaultAsset{Object: object("payload b"), Path: "b2"})
+
+    // Lost object file.
+    fi, err := vault.StatObject(storage_ifaces.VaultAsset{Object: object("payload c")})
+    if err != nil {
+        t.Fatal(err)
+    }
+    filepath.Walk(opts.VaultRoot, func(path string, info os.FileInfo, err error) error {
+        if err == nil && os.SameFile(info, fi) {
+            os.Remove(path)
+        }
+        return nil
+    })
+
+    report, err = storagesManager.CheckVault(storage_ifaces.VaultCheckOpts{})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    expected := &storage_ifaces.VaultCheckReport{
+        Objects:    3,
+        Refs:       4,
+        Orphaned:   []string{orphan.Object},
+        Missing:    []storage_ifaces.VaultObjectRef{ref(object("payload c"), "c")},
+        Dangling:   []storage_ifaces.VaultObjectRef{ref(orphan.Object, "lost")},
+        Unindexed:  []storage_ifaces.VaultObjectRef{ref(object("payload b"), "b2")},
+        Removed:    []string{},
+        Deferred:   []string{},
+    }
+    if !reflect.DeepEqual(report, expected) {
+        t.Fatalf("Unexpected report!\nGot\t\t: %+v\nExpected\t: %+v", report, expected)
+    }
+
+    // Opened orphaned object is removed on close.
+    f, err := vault.OpenObject(orphan)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    report, err = storagesManager.CheckVault(storage_ifaces.VaultCheckOpts{Gc: true})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !report.Repaired || !reflect.DeepEqual(report.Deferred, []string{orphan.Object}) || len(report.Removed) != 0 {
+        t.Fatalf("Unexpected report: %+v", report)
+    }
+
+    if _, err := vault.StatObject(orphan); err != nil {
+        t.Fatalf("Opened object is removed: %s", err)
+    }
+
+    f.Close()
+
+    if _, err := vault.StatObject(orphan); !os.IsNotExist(err) {
+        t.Fatalf("Orphaned object is not removed: %v", err)
+    }
+
+    if refs := vault.Refs(object("payload b")); len(refs) != 2 {
+        t.Fatalf("Unexpected refs: %v", refs)
+    }
+
+    report, err = storagesManager.CheckVault(storage_ifaces.VaultCheckOpts{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if report.Objects != 2 || len(report.Orphaned) != 0 || len(report.Dangling) != 0 || len(report.Unindexed) != 0 || len(report.Missing) != 1 {
+        t.Fatalf("Unexpected report: %+v", report)
+    }
+
+    // Repaired references are unreferenced as usual.
+    for _, path := range []string{"b", "b2"} {
+        if err := s.DeleteAsset(path); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    if _, err := vault.StatObject(storage_ifaces.VaultAsset{Object: object("payload b")}); !os.IsNotExist(err) {
+        t.Fatalf("Unreferenced object is not removed: %v", err)
+    }
+
+    // Orphaned objects without readers are removed at once.
+    if err := ioutil.WriteFile(tmp, []byte("orphan"), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    if err := vault.Put(s, orphan, tmp); err != nil {
+        t.Fatal(err)
+    }
+
+    report, err = storagesManager.CheckVault(storage_ifaces.VaultCheckOpts{Gc: true})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !reflect.DeepEqual(report.Removed, []string{orphan.Object}) {
+        t.Fatalf("Unexpected report: %+v", report)
+    }
+    if _, err := vault.StatObject(orphan); !os.IsNotExist(err) {
+        t.Fatalf("Orphaned object is not removed: %v", err)
+    }
+
+    storagesManager.Destroy(s.Id)
+    storagesManager.Destroy(plain.Id)
+}
+
+
+func TestCheckVaultBlocksCreate(t *testing.T) {
+
+    opts := PrefixedStoragesOpts(filepath.Join(TESTING_WS, "fsck"))
+    opts.ReaperInterval = 0
+
+    storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
+
+    // Held by the vault check.
+    storagesManager.maintenance.Lock()
+
+    created := make(chan *storage_ifaces.Storage)
+    go func() {
+        created <- storagesManager.Create(storage_ifaces.StorageHashedFilesystem)
+    }()
+
+    select {
+    case <-created:
+        t.Fatal("Storage is created while the vault is checked!")
+    case <-time.After(50 * time.Millisecond):
+    }
+
+    storagesManager.maintenance.Unlock()
+
+    s := <-created
+    if s == nil {
+        t.Fatal("Can't create storage!")
+    }
+
+    storagesManager.Destroy(s.Id)
+}
+
+
+func TestHashedWriteFailure(t *testing.T) {
+
+    opts := PrefixedStoragesOpts(filepath.Join(TESTING_WS, "putfail"))
+    opts.ReaperInterval = 0
+
+    os.RemoveAll(filepath.Join(TESTING_WS, "putfail"))
+
+    storagesManager := NewStoragesManager(opts)
+    defer storagesManager.Close()
+
+    s := storagesManager.Create(storage_ifaces.StorageHashedFilesystem)
+    if s == nil {
+        t.Fatal("Can't create storage!")
+    }
+    defer storagesManager.Destroy(s.Id)
+
+    checkStorageOps_NewAsset(s, t, "asset", "payload", 0o644)
+
+    // Object directory can't be created.
+    hex := fmt.Sprintf("%x", sha256.Sum256([]byte("new payload")))
+    if err := ioutil.WriteFile(filepath.Join(opts.VaultRoot, hex[:2]), nil, 0o600); err != nil {
+        t.Fatal(err)
+    }
+
+    _, err := s.WriteAsset("asset", &storage_ifaces.StorageAssetReader{Reader: strings.NewReader("new payload"), Opts: storage_ifaces.StorageAssetOpts{Mode: 0o644}}, storage_ifaces.StorageAssetCond{})
+    if err == nil {
+        t.Fatal("Unexpected write success!")
+    }
+
+    checkStorageOps_ReadPayload(s, t, "asset", "payload")
+
+    report, err := storagesManager.CheckVault(storage_ifaces.VaultCheckOpts{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !report.Clean() {
+        t.Fatalf("Unexpected report: %+v", report)
+    }
+
+    // Replacing by the same content keeps the reference.
+    checkStorageOps_NewAsset(s, t, "copy", "payload", 0o644)
+    if _, err := s.WriteAsset("asset", &storage_ifaces.StorageAssetReader{Reader: strings.NewReader("payload"), Opts: storage_ifaces.StorageAssetOpts{Mode: 0o644}}, storage_ifaces.StorageAssetCond{}); err != nil {
+        t.Fatal(err)
+    }
+
+    object := fmt.Sprintf("%x", sha256.Sum256([]byte("payload")))
+    if refs := storagesManager.Vault().Refs(object); len(refs) != 2 {
+        t.Fatalf("Unexpected refs: %v", refs)
+    }
+
+    // Stale reference on the target path fails the move.
+    if err := storagesManager.Vault().Link(s, storage_ifaces.VaultAsset{Object: object, Path: "stale"}); err != nil {
+        t.Fatal(err)
+    }
+    if err := s.MoveAsset("asset", "stale"); err == nil {
+        t.Fatal("Unexpected move success!")
+    }
+
+    checkStorageOps_ReadPayload(s, t, "asset", "payload")
+    if _, err := s.StatAsset("stale"); !errors.Is(err, storage_ifaces.ASSET_NOT_EXIST) {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+}
@@ -1,7 +1,9 @@
 package storage
 
 import (
+    "fmt"
     "sync"
+    "time"
     "encoding/json"
 
     "./ifaces"
@@ -12,12 +14,17 @@ type storagesMap struct {
     sync.Mutex
 
     values map[string]*storage_ifaces.Storage
+
+    // Storages names and aliases index. Key is the name or alias,
+    // value is the storage id.
+    names  map[string]string
 }
 
 
 func makeStoragesMap() storagesMap {
     return storagesMap{
         values: make(map[string]*storage_ifaces.Storage),
+        names:  make(map[string]string),
     }
 }
 
@@ -27,19 +34,26 @@ func (m *storagesMap) Store(id storage_ifaces.StorageId, s *storage_ifaces.Stora
     defer m.Unlock()
 
     m.values[id.String()] = s
+    m.index(s)
 }
 
 
+// Load storage by id, name or alias.
 func (m *storagesMap) Load(id storage_ifaces.StorageId) (*storage_ifaces.Storage, bool) {
     m.Lock()
     defer m.Unlock()
 
     s, ok := m.values[id.String()]
+    if ok {
+        return s, true
+    }
+
+    sid, ok := m.names[id.String()]
     if !ok {
         return nil, false
     }
 
-    return s, true
+    return m.values[sid], true
 }
 
 
@@ -59,19 +73,187 @@ func (m *storagesMap) Delete(id storage_ifaces.StorageId) {
     m.Lock()
     defer m.Unlock()
 
+    if s, ok := m.values[id.String()]; ok {
+        m.unindex(s)
+    }
+
     delete(m.values, id.String())
 }
 
 
+// Check if the name can be used as new storage name or alias. Not
+// thread safe.
+func (m *storagesMap) checkName(name string) error {
+    if _, ok := m.values[name]; ok {
+        return fmt.Errorf("Name: '%s' is used as storage id! Err: %w", name, storage_ifaces.NAME_EXIST)
+    }
+
+    if _, ok := m.names[name]; ok {
+        return fmt.Errorf("Name: '%s' already used! Err: %w", name, storage_ifaces.NAME_EXIST)
+    }
+
+    return nil
+}
+
+
+// Store new named storage. Fails if the name is already used.
+func (m *storagesMap) StoreNamed(id storage_ifaces.StorageId, s *storage_ifaces.Storage) error {
+    m.Lock()
+    defer m.Unlock()
+
+    if err := m.checkName(s.UniqueName); err != nil {
+        return err
+    }
+
+    m.values[id.String()] = s
+    m.index(s)
+
+    return nil
+}
+
+
+//  Attach alias to storage. If the alias is attached to another storage,
+// it will be moved.
+func (m *storagesMap) SetAlias(alias string, id storage_ifaces.StorageId) error {
+    m.Lock()
+    defer m.Unlock()
+
+    s, ok := m.values[id.String()]
+    if !ok {
+        return fmt.Errorf("Attempt to alias non existing storage: %s!", id.Id)
+    }
+
+    if sid, ok := m.names[alias]; ok {
+        old := m.values[sid]
+        if old.UniqueName == alias {
+            return fmt.Errorf("Name: '%s' already used as storage name! Err: %w", alias, storage_ifaces.NAME_EXIST)
+        }
+
+        if sid == id.String() {
+            return nil
+        }
+
+        old.RemoveAlias(alias)
+    } else if err := m.checkName(alias); err != nil {
+        return err
+    }
+
+    s.AddAlias(alias)
+    m.names[alias] = id.String()
+
+    return nil
+}
+
+
+// Detach alias from storage.
+func (m *storagesMap) DeleteAlias(alias string) error {
+    m.Lock()
+    defer m.Unlock()
+
+    sid, ok := m.names[alias]
+    if !ok || m.values[sid].UniqueName == alias {
+        return fmt.Errorf("Attempt to delete non existing alias: '%s'! Err: %w", alias, storage_ifaces.NAME_NOT_EXIST)
+    }
+
+    m.values[sid].RemoveAlias(alias)
+    delete(m.names, alias)
+
+    return nil
+}
+
+
+// Set storage expiration time. Nil expiration time means that storage
+// never expires.
+func (m *storagesMap) SetExpires(id storage_ifaces.StorageId, expires *time.Time) error {
+    m.Lock()
+    defer m.Unlock()
+
+    s, ok := m.values[id.String()]
+    if !ok {
+        return fmt.Errorf("Attempt to expire non existing storage: %s!", id.Id)
+    }
+
+    s.SetExpires(expires)
+
+    return nil
+}
+
+
+// Set storage quota. Nil quota means unlimited storage.
+func (m *storagesMap) SetQuota(id storage_ifaces.StorageId, quota *storage_ifaces.StorageQuota) error {
+    m.Lock()
+    defer m.Unlock()
+
+    s, ok := m.values[id.String()]
+    if !ok {
+        return fmt.Errorf("Attempt to set quota for non existing storage: %s!", id.Id)
+    }
+
+    s.SetQuota(quota)
+
+    return nil
+}
+
+
+// Get ids of storages expired at the given time.
+func (m *storagesMap) Expired(now time.Time) []storage_ifaces.StorageId {
+    m.Lock()
+    defer m.Unlock()
+
+    expired := make([]storage_ifaces.StorageId, 0)
+
+    for _, s := range m.values {
+        if s.Expired(now) {
+            expired = append(expired, s.Id)
+        }
+    }
+
+    return expired
+}
+
+
+// Add storage name and aliases to index. Not thread safe.
+func (m *storagesMap) index(s *storage_ifaces.Storage) {
+    if len(s.UniqueName) > 0 {
+        m.names[s.UniqueName] = s.Id.String()
+    }
+
+    for _, alias := range s.GetAliases() {
+        m.names[alias] = s.Id.String()
+    }
+}
+
+
+// Remove storage name and aliases from index. Not thread safe.
+func (m *storagesMap) unindex(s *storage_ifaces.Storage) {
+    if len(s.UniqueName) > 0 {
+        delete(m.names, s.UniqueName)
+    }
+
+    for _, alias := range s.GetAliases() {
+        delete(m.names, alias)
+    }
+}
+
+
 func (m *storagesMap) UnmarshalJSON(b []byte) (err error) {
     m.Lock()
     defer m.Unlock()
 
-    return json.Unmarshal(b, &m.values)
+    if err := json.Unmarshal(b, &m.values); err != nil {
+        return err
+    }
+
+    m.names = make(map[string]string)
+    for _, s := range m.values {
+        m.index(s)
+    }
+
+    return nil
 }
 
 
-func (m storagesMap) MarshalJSON() ([]byte, error) {
+func (m *storagesMap) MarshalJSON() ([]byte, error) {
     m.Lock()
     defer m.Unlock()
 
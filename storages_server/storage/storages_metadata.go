@@ -49,7 +49,7 @@ func (sm *StoragesManager) storeMetadata() {
 
         encoder := json.NewEncoder(writer)
 
-        err := encoder.Encode(sm.storages)
+        err := encoder.Encode(&sm.storages)
         if err != nil {
             log.Printf("Storages encoding error: %s", err)
             return err
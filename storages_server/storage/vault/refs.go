@@ -87,10 +87,29 @@ func (r *Refs) loadDb() *Refs {
         vaultLog.Panicf("Storages decode error: %s", err)
     }
 
+    r.canonicalize()
+
     return r
 }
 
 
+//  Replace bare hex object ids of the previous database versions by the
+// canonical ones. Not thread safe.
+func (r *Refs) canonicalize() {
+    for object, refs := range r.values {
+        canonical := storage_ifaces.CanonicalVaultObject(object)
+        if canonical == object {
+            continue
+        }
+
+        vaultLog.Printf("vault refs: object: %s is renamed to: %s", object, canonical)
+
+        r.values[canonical] = append(r.values[canonical], refs...)
+        delete(r.values, object)
+    }
+}
+
+
 // Store references database to a file located by r.dbpath location.
 // The method guarantee sucessfull data write or panic. Not thhread safe.
 func (r *Refs) storeDb() {
@@ -167,10 +186,10 @@ func (r *Refs) Add(object string, id storage_ifaces.StorageId, path storage_ifac
         }
     }
 
-    refsCount := len(refs)
-
     // Add new reference to object references collection.
-    refs = append(refs, &Ref{ StorageId: id, Path: path })
+    r.values[object] = append(refs, &Ref{ StorageId: id, Path: path })
+
+    refsCount := len(r.values[object])
 
     vaultLog.Printf("vault refs add: object: %s refs count: %d", object, refsCount)
 
@@ -180,6 +199,37 @@ func (r *Refs) Add(object string, id storage_ifaces.StorageId, path storage_ifac
 }
 
 
+//  Rename reference to object in storage. Returns REF_NOT_EXIST if there
+// is no reference with given path, and REF_EXIST if the new reference
+// already exist.
+func (r *Refs) Rename(object string, id storage_ifaces.StorageId, from storage_ifaces.Path, to storage_ifaces.Path) error {
+    r.Lock()
+    defer r.Unlock()
+
+    vaultLog.Printf("vault refs: rename object: %s reference for storage: %s path: %s to: %s", object, id.Id, from, to)
+
+    var found *Ref
+    for _, ref := range r.values[object] {
+        if ref.StorageId == id && ref.Path == to {
+            return REF_EXIST
+        }
+        if ref.StorageId == id && ref.Path == from {
+            found = ref
+        }
+    }
+
+    if found == nil {
+        return REF_NOT_EXIST
+    }
+
+    found.Path = to
+
+    r.storeDb()
+
+    return nil
+}
+
+
 // Get references count by object id.
 func (r *Refs) RefsCount(object string) int {
     r.Lock()
@@ -194,6 +244,26 @@ func (r *Refs) RefsCount(object string) int {
 }
 
 
+// Get copy of object references. Returns 'nil' if there are no references.
+func (r *Refs) Get(object string) RefsSlice {
+    r.Lock()
+    defer r.Unlock()
+
+    refs, ok := r.values[object]
+    if !ok {
+        return nil
+    }
+
+    result := make(RefsSlice, 0, len(refs))
+    for _, ref := range refs {
+        refCopy := *ref
+        result = append(result, &refCopy)
+    }
+
+    return result
+}
+
+
 //  Remove reference to object in storage.
 func (r *Refs) Remove(object string, id storage_ifaces.StorageId, path storage_ifaces.Path) (int, error) {
     r.Lock()
@@ -238,3 +308,33 @@ func (r Refs) MarshalJSON() ([]byte, error) {
     b, err := json.Marshal(r.values)
     return b, err
 }
+
+
+// Get copy of all references.
+func (r *Refs) All() map[string]RefsSlice {
+    r.Lock()
+    defer r.Unlock()
+
+    result := make(map[string]RefsSlice, len(r.values))
+    for object, refs := range r.values {
+        for _, ref := range refs {
+            refCopy := *ref
+            result[object] = append(result[object], &refCopy)
+        }
+    }
+
+    return result
+}
+
+
+// Replace all references and store the database.
+func (r *Refs) Reset(values map[string]RefsSlice) {
+    r.Lock()
+    defer r.Unlock()
+
+    vaultLog.Printf("vault refs reset: objects count: %d", len(values))
+
+    r.values = values
+
+    r.storeDb()
+}
@@ -3,6 +3,7 @@ package vault
 import (
     "os"
     "fmt"
+    "sort"
     "strings"
     "sync"
     "path/filepath"
@@ -29,6 +30,11 @@ type Vault struct {
 
 
 func NewVault(opts storage_ifaces.StoragesManagerOpts) *Vault {
+    if algorithm := opts.VaultDigestAlgorithm(); !storage_ifaces.IsDigestAlgorithm(algorithm) {
+        vaultLog.Printf("Unknown vault digest algorithm: %s", algorithm)
+        return nil
+    }
+
     v := &Vault{
         opts    : opts,
         Root    : opts.VaultRoot,
@@ -87,8 +93,20 @@ func (v *Vault) path(h string) string {
 }
 
 
-func (v *Vault) objectPath(h string) string {
-    return filepath.Join(v.Root, v.path(h))
+//  Get object file path. The sha256 objects are kept in the vault root for
+// the compatibility with the vaults having bare hex ids, other objects are
+// in the algorithm named subdirectories.
+func (v *Vault) objectPath(object string) string {
+    algorithm, digest, ok := storage_ifaces.ParseVaultObject(object)
+    if !ok {
+        panic(fmt.Errorf("Malformed vault object id: %s", object))
+    }
+
+    if algorithm == storage_ifaces.DefaultVaultAlgorithm {
+        return filepath.Join(v.Root, v.path(digest))
+    }
+
+    return filepath.Join(v.Root, algorithm, v.path(digest))
 }
 
 
@@ -96,12 +114,14 @@ func (v *Vault) OpenObject(asset Asset) (*storage_ifaces.VaultFile, error) {
     v.Lock()
     defer v.Unlock()
 
+    asset.Object = storage_ifaces.CanonicalVaultObject(asset.Object)
+
     vaultLog.Printf("Open object '%s' reader.", asset.Object)
 
     objectPath := v.objectPath(asset.Object)
 
     if _, ok := os.Stat(objectPath); os.IsNotExist(ok) {
-        return nil, fmt.Errorf("Attempt to open non existing file: %s", objectPath)
+        return nil, fmt.Errorf("Attempt to open non existing file: %s. Err: %w", objectPath, os.ErrNotExist)
     }
 
     f, err := os.Open(objectPath)
@@ -116,15 +136,33 @@ func (v *Vault) OpenObject(asset Asset) (*storage_ifaces.VaultFile, error) {
 }
 
 
+func (v *Vault) StatObject(asset Asset) (os.FileInfo, error) {
+    v.Lock()
+    defer v.Unlock()
+
+    asset.Object = storage_ifaces.CanonicalVaultObject(asset.Object)
+
+    return os.Stat(v.objectPath(asset.Object))
+}
+
+
 func (v *Vault) Put(s *storage_ifaces.Storage, asset Asset, filePath storage_ifaces.Path) error {
     v.Lock()
     defer v.Unlock()
 
+    asset.Object = storage_ifaces.CanonicalVaultObject(asset.Object)
+
     vaultLog.Printf("Put object '%s' to vault.", asset.Object)
 
     objectPath := v.objectPath(asset.Object)
 
-    if _, ok := os.Stat(objectPath); os.IsNotExist(ok) {
+    _, statErr := os.Stat(objectPath)
+    if statErr != nil && !os.IsNotExist(statErr) {
+        vaultLog.Printf("Stat object error: %s", statErr)
+        return statErr
+    }
+
+    if statErr != nil {
 
         if err := filesystem_utils.EnsureDir(filepath.Dir(objectPath), os.FileMode(v.opts.DirsMode)); err != nil {
             vaultLog.Printf("Ensure vault root error: %s", err)
@@ -135,7 +173,7 @@ func (v *Vault) Put(s *storage_ifaces.Storage, asset Asset, filePath storage_ifa
 
         if err := os.Rename(filePath, objectPath); err != nil {
             vaultLog.Printf("Rename file error: %s", err)
-            return nil
+            return err
         }
 
     } else {
@@ -154,6 +192,53 @@ func (v *Vault) Put(s *storage_ifaces.Storage, asset Asset, filePath storage_ifa
 }
 
 
+//  Add reference to existing object. Returns error wrapping os.ErrNotExist
+// if there is no such object.
+func (v *Vault) Link(s *storage_ifaces.Storage, asset Asset) error {
+    v.Lock()
+    defer v.Unlock()
+
+    asset.Object = storage_ifaces.CanonicalVaultObject(asset.Object)
+
+    vaultLog.Printf("Link object '%s' to: %s", asset.Object, asset.Path)
+
+    if _, err := os.Stat(v.objectPath(asset.Object)); err != nil {
+        vaultLog.Printf("Stat object error: %s", err)
+        return err
+    }
+
+    // Cancel remove for the object if sheduled
+    v.opened.Cancel(asset.Object)
+
+    _, err := v.refs.Add(asset.Object, s.Id, asset.Path)
+    if err == REF_EXIST {
+        err = nil
+    }
+
+    return err
+}
+
+
+func (v *Vault) Rename(s *storage_ifaces.Storage, asset Asset, path storage_ifaces.Path) error {
+    v.Lock()
+    defer v.Unlock()
+
+    asset.Object = storage_ifaces.CanonicalVaultObject(asset.Object)
+
+    vaultLog.Printf("Rename object '%s' reference from: %s to: %s", asset.Object, asset.Path, path)
+
+    err := v.refs.Rename(asset.Object, s.Id, asset.Path, path)
+    if err == REF_NOT_EXIST {
+        // Reference can be lost by previous versions of refs database,
+        // so just register the new one.
+        vaultLog.Printf("No object '%s' reference for: %s. Add new reference.", asset.Object, asset.Path)
+        _, err = v.refs.Add(asset.Object, s.Id, path)
+    }
+
+    return err
+}
+
+
 func (v *Vault) CloseObject(asset *Asset, f *storage_ifaces.VaultFile) {
     v.Lock()
     defer v.Unlock()
@@ -188,8 +273,29 @@ func (v *Vault) Unref(s *storage_ifaces.Storage, asset Asset) {
     v.Lock()
     defer v.Unlock()
 
+    asset.Object = storage_ifaces.CanonicalVaultObject(asset.Object)
+
     if refsCount, _ := v.refs.Remove(asset.Object, s.Id, asset.Path); refsCount == 0 {
         // Remove unreferenced object
         v.removeObject(asset.Object)
     }
 }
+
+
+func (v *Vault) Refs(object string) []storage_ifaces.VaultRef {
+    refs := v.refs.Get(storage_ifaces.CanonicalVaultObject(object))
+
+    result := make([]storage_ifaces.VaultRef, 0, len(refs))
+    for _, ref := range refs {
+        result = append(result, storage_ifaces.VaultRef{StorageId: ref.StorageId, Path: ref.Path})
+    }
+
+    sort.Slice(result, func(i, j int) bool {
+        if result[i].StorageId.Id != result[j].StorageId.Id {
+            return result[i].StorageId.Id < result[j].StorageId.Id
+        }
+        return result[i].Path < result[j].Path
+    })
+
+    return result
+}
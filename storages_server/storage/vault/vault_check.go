@@ -0,0 +1,179 @@
+package vault
+
+import (
+    "os"
+    "sort"
+    "strings"
+    "path/filepath"
+
+    "../ifaces"
+)
+
+
+type objectRefKey struct {
+    object  string
+    sid     string
+    path    storage_ifaces.Path
+}
+
+
+func sortObjectRefs(refs []storage_ifaces.VaultObjectRef) {
+    sort.Slice(refs, func(i, j int) bool {
+        if refs[i].Object != refs[j].Object {
+            return refs[i].Object < refs[j].Object
+        }
+        if refs[i].StorageId.Id != refs[j].StorageId.Id {
+            return refs[i].StorageId.Id < refs[j].StorageId.Id
+        }
+        return refs[i].Path < refs[j].Path
+    })
+}
+
+
+//  Get ids of the object files. Files what are not named as the objects
+// of the known algorithms are skipped. Not thread safe.
+func (v *Vault) listObjects() (map[string]bool, error) {
+    objects := make(map[string]bool)
+
+    err := filepath.Walk(v.Root, func(path string, info os.FileInfo, err error) error {
+        if err != nil {
+            return err
+        }
+
+        if !info.Mode().IsRegular() || path == v.refs.dbpath {
+            return nil
+        }
+
+        rel, err := filepath.Rel(v.Root, path)
+        if err != nil {
+            return err
+        }
+
+        parts := strings.Split(rel, string(filepath.Separator))
+
+        object := strings.Join(parts, "")
+        if len(parts) > 1 && storage_ifaces.IsDigestAlgorithm(parts[0]) {
+            object = storage_ifaces.MakeVaultObject(parts[0], strings.Join(parts[1:], ""))
+        }
+
+        if !storage_ifaces.IsVaultObject(object) || v.objectPath(storage_ifaces.CanonicalVaultObject(object)) != path {
+            vaultLog.Printf("Skip not object file: %s", path)
+            return nil
+        }
+
+        objects[storage_ifaces.CanonicalVaultObject(object)] = true
+
+        return nil
+    })
+
+    return objects, err
+}
+
+
+//  Check the object files and the refs database against the storages
+// assets references. The refs database is rebuilt from the assets
+// references, if opts.Repair or opts.Gc is set. The orphaned objects opened
+// by readers are removed when the last reader is closed.
+func (v *Vault) Check(assetsRefs []storage_ifaces.VaultObjectRef, opts storage_ifaces.VaultCheckOpts) (*storage_ifaces.VaultCheckReport, error) {
+    v.Lock()
+    defer v.Unlock()
+
+    vaultLog.Printf("Check vault: %s repair: %t gc: %t", v.Root, opts.Repair, opts.Gc)
+
+    report := &storage_ifaces.VaultCheckReport{
+        Orphaned:   make([]string, 0),
+        Missing:    make([]storage_ifaces.VaultObjectRef, 0),
+        Dangling:   make([]storage_ifaces.VaultObjectRef, 0),
+        Unindexed:  make([]storage_ifaces.VaultObjectRef, 0),
+        Removed:    make([]string, 0),
+        Deferred:   make([]string, 0),
+    }
+
+    objects, err := v.listObjects()
+    if err != nil {
+        vaultLog.Printf("List vault objects error: %s", err)
+        return nil, err
+    }
+
+    report.Objects = len(objects)
+
+    // Rebuild references from the storages assets.
+    rebuilt := make(map[string]RefsSlice)
+    known := make(map[objectRefKey]bool)
+
+    for _, ref := range assetsRefs {
+        ref.Object = storage_ifaces.CanonicalVaultObject(ref.Object)
+
+        key := objectRefKey{ref.Object, ref.StorageId.Id, ref.Path}
+        if known[key] {
+            continue
+        }
+        known[key] = true
+
+        report.Refs++
+
+        if !objects[ref.Object] {
+            report.Missing = append(report.Missing, ref)
+        }
+
+        rebuilt[ref.Object] = append(rebuilt[ref.Object], &Ref{StorageId: ref.StorageId, Path: ref.Path})
+    }
+
+    for object := range objects {
+        if _, ok := rebuilt[object]; !ok {
+            report.Orphaned = append(report.Orphaned, object)
+        }
+    }
+
+    indexed := make(map[objectRefKey]bool)
+
+    for object, refs := range v.refs.All() {
+        for _, ref := range refs {
+            key := objectRefKey{object, ref.StorageId.Id, ref.Path}
+            indexed[key] = true
+
+            if !known[key] {
+                report.Dangling = append(report.Dangling, storage_ifaces.VaultObjectRef{
+                    Object:     object,
+                    VaultRef:   storage_ifaces.VaultRef{StorageId: ref.StorageId, Path: ref.Path},
+                })
+            }
+        }
+    }
+
+    for _, ref := range assetsRefs {
+        ref.Object = storage_ifaces.CanonicalVaultObject(ref.Object)
+        key := objectRefKey{ref.Object, ref.StorageId.Id, ref.Path}
+        if !indexed[key] {
+            indexed[key] = true
+            report.Unindexed = append(report.Unindexed, ref)
+        }
+    }
+
+    sort.Strings(report.Orphaned)
+    sortObjectRefs(report.Missing)
+    sortObjectRefs(report.Dangling)
+    sortObjectRefs(report.Unindexed)
+
+    if (opts.Repair || opts.Gc) && (len(report.Dangling) > 0 || len(report.Unindexed) > 0) {
+        vaultLog.Printf("Replace refs database, dangling: %d unindexed: %d", len(report.Dangling), len(report.Unindexed))
+        v.refs.Reset(rebuilt)
+        report.Repaired = true
+    }
+
+    if opts.Gc {
+        for _, object := range report.Orphaned {
+            if v.opened.IsOpen(object) {
+                report.Deferred = append(report.Deferred, object)
+            } else {
+                report.Removed = append(report.Removed, object)
+            }
+            v.removeObject(object)
+        }
+    }
+
+    vaultLog.Printf("Check vault: objects: %d refs: %d orphaned: %d missing: %d dangling: %d unindexed: %d",
+        report.Objects, report.Refs, len(report.Orphaned), len(report.Missing), len(report.Dangling), len(report.Unindexed))
+
+    return report, nil
+}
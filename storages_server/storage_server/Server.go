@@ -3,8 +3,13 @@ package storage_server
 import (
     "net/http"
     "net/url"
-    "strings"
     "errors"
+    "fmt"
+    "time"
+    "strconv"
+    "strings"
+    "encoding/base64"
+    "encoding/hex"
 
     "github.com/go-chi/chi"
 
@@ -38,24 +43,48 @@ func InitializeServer(r *chi.Mux, opts storage_ifaces.StoragesManagerOpts, auth
     r.Route("/storage", func(r chi.Router) {
 
         // Pseudo FS level routines
+        r.Get("/", StoragesList)
         r.Get("/create/{type}", StorageCreate)
-        r.Get("/destroy/{sid:[0-f-]+}", StorageDestroy)
-        r.Get("/list/{sid:[0-f-]+}", StorageList)
-        r.Route("/{sid:[0-f-]+}", func(r chi.Router) {
+        r.Get("/destroy/{sid:[0-9A-Za-z._-]+}", StorageDestroy)
+        r.Get("/list/{sid:[0-9A-Za-z._-]+}", StorageList)
+        r.Get("/archive/{sid:[0-9A-Za-z._-]+}", StorageArchive)
+        r.Put("/import/{sid:[0-9A-Za-z._-]+}", StorageImport)
+        r.Get("/move/{sid:[0-9A-Za-z._-]+}/*", StorageMoveElement)
+        r.Get("/stat/{sid:[0-9A-Za-z._-]+}/*", StorageStatElement)
+        r.Get("/alias/{alias}/{sid:[0-9A-Za-z._-]+}", StorageAlias)
+        r.Get("/unalias/{alias}", StorageUnalias)
+        r.Get("/expire/{sid:[0-9A-Za-z._-]+}", StorageExpire)
+        r.Get("/seal/{sid:[0-9A-Za-z._-]+}", StorageSeal)
+        r.Get("/convert/{sid:[0-9A-Za-z._-]+}/{type}", StorageConvert)
+        r.Route("/{sid:[0-9A-Za-z._-]+}", func(r chi.Router) {
             r.Put("/*", StoragePutElement)
             r.Get("/*", StorageGetElement)
+            r.Head("/*", StorageGetElement)
+            r.Delete("/*", StorageDeleteElement)
         })
 
         // Input buffers row
         r.Route("/buffer", func(r chi.Router) {
             r.Get("/create", BufferCreate)
             r.Get("/discard/{bid:[0-f-]+}", BufferDiscard)
-            r.Get("/commit/{sid:[0-f-]+}/{bid:[0-f-]+}/*", BufferCommit)
+            r.Get("/commit/{sid:[0-9A-Za-z._-]+}/{bid:[0-f-]+}/*", BufferCommit)
+            r.Get("/import/{sid:[0-9A-Za-z._-]+}/{bid:[0-f-]+}", BufferImport)
             r.Put("/{bid:[0-f-]+}", BufferAppend)
         })
 
     })
 
+    //  Read only content addressed access to the vault objects and the
+    // vault maintenance
+    r.Route("/vault", func(r chi.Router) {
+        r.Post("/have", VaultHaveObjects)
+        r.Get("/fsck", VaultFsck)
+        r.Get("/gc", VaultGc)
+        r.Get("/{object}", VaultGetObject)
+        r.Head("/{object}", VaultGetObject)
+        r.Get("/{object}/refs", VaultObjectRefs)
+    })
+
     return r
 }
 
@@ -83,11 +112,263 @@ func getProperties(args url.Values) properties {
 }
 
 
-func extractPath(urlPath string, sepa string) (string, bool) {
-    parts := strings.Split(urlPath, "/" + sepa + "/")
-    if len(parts) < 2 {
-        return urlPath, false
+// Query args having special meaning for assets. All other args are
+// user defined asset properties.
+var reservedProperties = []string{"mode", "kind", "target", "mtime", "uid", "gid"}
+
+
+func assetProperties(args url.Values) storage_ifaces.StorageAssetProperties {
+    props := getProperties(args)
+    for _, k := range reservedProperties {
+        delete(props, k)
+    }
+
+    if len(props) == 0 {
+        return nil
+    }
+
+    return props
+}
+
+
+// Parse either RFC3339 time or Unix time in seconds.
+func parseMtime(mtimeStr string) (time.Time, error) {
+    mtime, err := time.Parse(time.RFC3339Nano, mtimeStr)
+    if err != nil {
+        secs, convErr := strconv.ParseInt(mtimeStr, 10, 64)
+        if convErr != nil {
+            return mtime, err
+        }
+        mtime = time.Unix(secs, 0)
+    }
+    return mtime, nil
+}
+
+
+// Get asset options from query args. The 'mode' is an integer (octal
+// with leading zero), the 'mtime' is either RFC3339 time or Unix time in
+// seconds. Malformed values are reported by errors wrapping BAD_ASSET_OPTS.
+func assetOptsArgs(args url.Values) (storage_ifaces.StorageAssetOpts, error) {
+    props := getProperties(args)
+
+    opts := storage_ifaces.StorageAssetOpts{
+        Mode:       0o644,
+        Properties: assetProperties(args),
+        Kind:       props["kind"],
+        Target:     props["target"],
+    }
+
+    if modeStr := props["mode"]; len(modeStr) != 0 {
+        mode, err := strconv.ParseInt(modeStr, 0, 32)
+        if err != nil {
+            return opts, fmt.Errorf("Permission conversion error: %s. Err: %w", err, storage_ifaces.BAD_ASSET_OPTS)
+        }
+        opts.Mode = int(mode)
+    }
+
+    if mtimeStr := props["mtime"]; len(mtimeStr) != 0 {
+        mtime, err := parseMtime(mtimeStr)
+        if err != nil {
+            return opts, fmt.Errorf("Mtime conversion error: %s. Err: %w", err, storage_ifaces.BAD_ASSET_OPTS)
+        }
+        opts.Mtime = &mtime
+    }
+
+    owner := func(name string) (*int, error) {
+        idStr := props[name]
+        if len(idStr) == 0 {
+            return nil, nil
+        }
+        id, err := strconv.Atoi(idStr)
+        if err != nil {
+            return nil, fmt.Errorf("Bad %s: %s. Err: %w", name, idStr, storage_ifaces.BAD_ASSET_OPTS)
+        }
+        return &id, nil
+    }
+
+    var err error
+    if opts.Uid, err = owner("uid"); err != nil {
+        return opts, err
+    }
+    if opts.Gid, err = owner("gid"); err != nil {
+        return opts, err
+    }
+
+    return opts, nil
+}
+
+
+// Digest algorithms names used by the Digest (RFC 3230) and Content-Digest
+// (RFC 9530) headers.
+var digestAlgorithms = map[string]string{
+    "md5":      storage_ifaces.DigestMd5,
+    "sha":      storage_ifaces.DigestSha1,
+    "sha-256":  storage_ifaces.DigestSha256,
+    "sha-512":  storage_ifaces.DigestSha512,
+}
+
+
+// Algorithms of the X-Checksum-* headers with hex encoded digests.
+var checksumAlgorithms = []string{
+    storage_ifaces.DigestMd5,
+    storage_ifaces.DigestSha1,
+    storage_ifaces.DigestSha256,
+    storage_ifaces.DigestSha512,
+}
+
+
+// Get X-Checksum-* header name for the digest algorithm.
+func checksumHeader(algorithm string) string {
+    return http.CanonicalHeaderKey("X-Checksum-" + algorithm)
+}
+
+
+//  Get expected content digests from the request headers: Content-MD5,
+// Digest, Content-Digest and X-Checksum-*. Unknown Digest algorithms are
+// ignored. Malformed values are reported by errors wrapping BAD_DIGEST.
+func digestHeaders(h http.Header) (storage_ifaces.StorageAssetDigests, error) {
+    digests := make(storage_ifaces.StorageAssetDigests)
+
+    addBase64 := func(algorithm string, value string) error {
+        raw, err := base64.StdEncoding.DecodeString(value)
+        if err != nil {
+            return fmt.Errorf("Malformed %s digest: %s! Err: %w", algorithm, value, storage_ifaces.BAD_DIGEST)
+        }
+        return digests.Add(algorithm, hex.EncodeToString(raw))
+    }
+
+    if value := h.Get("Content-MD5"); len(value) > 0 {
+        if err := addBase64(storage_ifaces.DigestMd5, strings.TrimSpace(value)); err != nil {
+            return nil, err
+        }
+    }
+
+    for _, header := range []string{"Digest", "Content-Digest"} {
+        for _, line := range h[header] {
+            for _, item := range strings.Split(line, ",") {
+                kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+                if len(kv) != 2 {
+                    return nil, fmt.Errorf("Malformed %s header: %s! Err: %w", header, line, storage_ifaces.BAD_DIGEST)
+                }
+
+                name, value := kv[0], kv[1]
+
+                algorithm, ok := digestAlgorithms[strings.ToLower(name)]
+                if !ok {
+                    continue
+                }
+
+                // Content-Digest values are byte sequences: ':base64:'
+                if err := addBase64(algorithm, strings.Trim(value, ":")); err != nil {
+                    return nil, err
+                }
+            }
+        }
+    }
+
+    for _, algorithm := range checksumAlgorithms {
+        if value := h.Get(checksumHeader(algorithm)); len(value) > 0 {
+            if err := digests.Add(algorithm, strings.TrimSpace(value)); err != nil {
+                return nil, err
+            }
+        }
+    }
+
+    return digests, nil
+}
+
+
+// Get asset path from the route wildcard.
+func extractPath(r *http.Request) (string, bool) {
+    path := chi.URLParam(r, "*")
+
+    // Wildcard is taken from the raw path if the path was escaped.
+    if len(r.URL.RawPath) > 0 {
+        if unescaped, err := url.PathUnescape(path); err == nil {
+            path = unescaped
+        }
+    }
+
+    return path, len(path) > 0
+}
+
+
+//  Get storage expiration time from 'ttl' (duration, like '36h') or
+// 'expires' (RFC3339 time) query args. Returns zero time if no args
+// provided.
+func expiresArg(args url.Values) (time.Time, error) {
+    props := getProperties(args)
+
+    if ttl, ok := props["ttl"]; ok {
+        d, err := time.ParseDuration(ttl)
+        if err != nil {
+            return time.Time{}, err
+        }
+        if d <= 0 {
+            return time.Time{}, fmt.Errorf("Non positive ttl: %s!", ttl)
+        }
+        return time.Now().Add(d), nil
+    }
+
+    if expires, ok := props["expires"]; ok {
+        return time.Parse(time.RFC3339, expires)
+    }
+
+    return time.Time{}, nil
+}
+
+
+// Get storage quota from 'max_size' and 'max_assets' query args.
+func quotaArg(args url.Values) (storage_ifaces.StorageQuota, error) {
+    var quota storage_ifaces.StorageQuota
+
+    props := getProperties(args)
+
+    for k, v := range map[string]*int64{"max_size": &quota.MaxSize, "max_assets": &quota.MaxAssets} {
+        if s, ok := props[k]; ok {
+            value, err := strconv.ParseInt(s, 10, 64)
+            if err != nil || value < 0 {
+                return quota, fmt.Errorf("Bad %s value: '%s'!", k, s)
+            }
+            *v = value
+        }
+    }
+
+    return quota, nil
+}
+
+
+// Get HTTP status for the typed storage errors.
+func storageErrorStatus(err error) (int, bool) {
+    switch {
+    case errors.Is(err, storage_ifaces.BAD_PATH), errors.Is(err, storage_ifaces.BAD_ASSET_OPTS):
+        return http.StatusBadRequest, true
+    case errors.Is(err, storage_ifaces.BAD_DIGEST), errors.Is(err, storage_ifaces.DIGEST_MISMATCH), errors.Is(err, storage_ifaces.BAD_ARCHIVE):
+        return http.StatusBadRequest, true
+    case errors.Is(err, storage_ifaces.SEALED), errors.Is(err, storage_ifaces.CONVERTING):
+        return http.StatusConflict, true
+    case errors.Is(err, storage_ifaces.ASSET_TOO_LARGE):
+        return http.StatusRequestEntityTooLarge, true
+    case errors.Is(err, storage_ifaces.QUOTA_EXCEEDED):
+        return http.StatusInsufficientStorage, true
+    case errors.Is(err, storage_ifaces.OBJECT_NOT_EXIST):
+        return http.StatusNotFound, true
+    }
+    return 0, false
+}
+
+
+// Storage names what can't be used because of the conflicts with
+// the routes.
+var reservedNames = []string{"create", "destroy", "list", "move", "stat", "alias", "unalias", "expire", "seal", "convert", "archive", "import", "buffer"}
+
+
+func checkStorageName(name string) error {
+    for _, reserved := range reservedNames {
+        if name == reserved {
+            return fmt.Errorf("Reserved storage name: '%s'! Err: %w", name, storage_ifaces.BAD_NAME)
+        }
     }
 
-    return parts[1], true
+    return storage_ifaces.CheckStorageName(name)
 }
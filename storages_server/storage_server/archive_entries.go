@@ -0,0 +1,212 @@
+package storage_server
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+
+    "github.com/go-chi/chi"
+
+    "../storage/ifaces"
+)
+
+
+var archiveContentTypes = map[string]string{
+    storage_ifaces.ArchiveTar:      "application/x-tar",
+    storage_ifaces.ArchiveTarGz:    "application/gzip",
+    storage_ifaces.ArchiveZip:      "application/zip",
+}
+
+
+//  Stream archive of the storage or its subtree. Query args: 'format'
+// (tar by default), 'prefix', zip 'compression' (deflate or store) and
+// 'mtime' used for all entries.
+func StorageArchive(w http.ResponseWriter, r *http.Request) {
+    sid := chi.URLParam(r, "sid")
+    if len(sid) < 1 {
+        http.Error(w, "Empty storage id!", http.StatusNotFound)
+        return
+    }
+
+    id := storage_ifaces.MakeStorageId(sid)
+
+    s := context.storages.Get(id)
+    if s == nil {
+        http.Error(w, "Unknown storage id!", http.StatusNotFound)
+        return
+    }
+
+    opts := storage_ifaces.StorageArchiveOpts{
+        Format:         r.URL.Query().Get("format"),
+        Prefix:         r.URL.Query().Get("prefix"),
+        Compression:    r.URL.Query().Get("compression"),
+    }
+
+    if len(opts.Format) == 0 {
+        opts.Format = storage_ifaces.ArchiveTar
+    }
+
+    if mtimeStr := r.URL.Query().Get("mtime"); len(mtimeStr) != 0 {
+        mtime, err := parseMtime(mtimeStr)
+        if err != nil {
+            http.Error(w, fmt.Sprintf("Mtime conversion error: %s", err), http.StatusBadRequest)
+            return
+        }
+        opts.Mtime = &mtime
+    }
+
+    if err := opts.Validate(); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    if _, err := storage_ifaces.CleanPath(opts.Prefix); len(opts.Prefix) > 0 && err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    w.Header().Set("Content-Type", archiveContentTypes[opts.Format])
+    w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", s.Name(), opts.Format))
+
+    if err := s.WriteArchive(w, opts); err != nil {
+        // The response is already started, so abort it to let the client
+        // know what the archive is incomplete.
+        log.Printf("Archive storage: %s error: %s", s.Name(), err)
+        panic(http.ErrAbortHandler)
+    }
+
+    log.Printf("archive sid: %s prefix: %s format: %s", sid, opts.Prefix, opts.Format)
+}
+
+
+// Archive import response.
+type importResult struct {
+    Entries []storage_ifaces.StorageImportEntry `json:"entries"`
+    Error   string                              `json:"error,omitempty"`
+}
+
+
+func importArchiveOpts(r *http.Request) (storage_ifaces.StorageArchiveOpts, error) {
+    opts := storage_ifaces.StorageArchiveOpts{
+        Format: r.URL.Query().Get("format"),
+        Prefix: r.URL.Query().Get("prefix"),
+    }
+
+    if err := opts.Validate(); err != nil {
+        return opts, err
+    }
+
+    if _, err := storage_ifaces.CleanPath(opts.Prefix); len(opts.Prefix) > 0 && err != nil {
+        return opts, err
+    }
+
+    return opts, nil
+}
+
+
+//  Write import entries results. Failed import is reported with the
+// error status, the entries show how far the import got before the
+// rollback.
+func importResponse(w http.ResponseWriter, entries []storage_ifaces.StorageImportEntry, err error) {
+    result := importResult{Entries: entries}
+    if result.Entries == nil {
+        result.Entries = []storage_ifaces.StorageImportEntry{}
+    }
+
+    status := http.StatusOK
+    if err != nil {
+        result.Error = err.Error()
+
+        var ok bool
+        if status, ok = storageErrorStatus(err); !ok {
+            status = http.StatusInternalServerError
+            if errors.Is(err, storage_ifaces.ASSET_EXIST) {
+                status = http.StatusConflict
+            }
+        }
+    }
+
+    resp, merr := json.Marshal(result)
+    if merr != nil {
+        http.Error(w, merr.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    w.Write(resp)
+}
+
+
+//  Expand archive from the request body into the storage. Query args:
+// 'format' (detected by content if empty) and 'prefix'.
+func StorageImport(w http.ResponseWriter, r *http.Request) {
+    sid := chi.URLParam(r, "sid")
+    if len(sid) < 1 {
+        http.Error(w, "Empty storage id!", http.StatusNotFound)
+        return
+    }
+
+    s := context.storages.Get(storage_ifaces.MakeStorageId(sid))
+    if s == nil {
+        http.Error(w, "Unknown storage id!", http.StatusNotFound)
+        return
+    }
+
+    opts, err := importArchiveOpts(r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    entries, err := s.ImportArchive(r.Body, opts)
+    if err != nil {
+        log.Printf("Import archive into storage: %s error: %s", s.Name(), err)
+    }
+
+    log.Printf("import sid: %s prefix: %s format: %s entries: %d", sid, opts.Prefix, opts.Format, len(entries))
+
+    importResponse(w, entries, err)
+}
+
+
+// Expand archive from the buffer into the storage.
+func BufferImport(w http.ResponseWriter, r *http.Request) {
+    sid := chi.URLParam(r, "sid")
+    if len(sid) < 1 {
+        http.Error(w, "Empty storage id!", http.StatusNotFound)
+        return
+    }
+
+    bid := chi.URLParam(r, "bid")
+    if len(bid) < 1 {
+        http.Error(w, "Empty buffer id!", http.StatusNotFound)
+        return
+    }
+
+    id := storage_ifaces.MakeStorageId(sid)
+
+    if context.storages.Get(id) == nil {
+        http.Error(w, "Unknown storage id!", http.StatusNotFound)
+        return
+    }
+
+    opts, err := importArchiveOpts(r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    entries, err := context.storages.ImportArchiveFromBuffer(id, bid, opts)
+    if err != nil {
+        log.Printf("Import archive buffer: %s error: %s", bid, err)
+        if errors.Is(err, storage_ifaces.BUFFER_NOT_EXIST) {
+            http.Error(w, err.Error(), http.StatusNotFound)
+            return
+        }
+    }
+
+    importResponse(w, entries, err)
+}
@@ -3,7 +3,7 @@ package storage_server
 import (
     "log"
     "fmt"
-    "strconv"
+    "errors"
     "net/http"
 
     "github.com/go-chi/chi"
@@ -57,7 +57,7 @@ func BufferCommit(w http.ResponseWriter, r *http.Request) {
 
     log.Printf("PUT url path: %s", r.URL.Path)
 
-    path, ok := extractPath(r.URL.Path, bid)
+    path, ok := extractPath(r)
     if !ok {
         log.Printf("Empty path!")
         http.Error(w, "Empty path!", http.StatusNotFound)
@@ -73,21 +73,26 @@ func BufferCommit(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    mode := 0o644
-    modeStr := getProperties(r.URL.Query())["mode"]
-    log.Printf("mode str: %s", modeStr)
-    if len(modeStr) != 0 {
-        modeVal, err := strconv.ParseInt(modeStr, 0, 32)
-        if err != nil {
-            log.Printf("Permission conversion error: %w. File: %s", err, path)
-            http.Error(w, fmt.Sprintf("Permission conversion error: %w. File: %s", err, path), http.StatusInternalServerError)
-            return
-        }
-        mode = int(modeVal)
+    opts, err := assetOptsArgs(r.URL.Query())
+    if err != nil {
+        log.Printf("Asset options error: %s. File: %s", err, path)
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    digests, err := digestHeaders(r.Header)
+    if err != nil {
+        log.Printf("Digest headers error: %s. File: %s", err, path)
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
     }
 
-    if err := context.storages.CreateStorageAssetFromBuffer(id, path, bid, storage_ifaces.StorageAssetOpts{Mode: mode}); err != nil {
+    if err := context.storages.CreateVerifiedStorageAssetFromBuffer(id, path, bid, opts, digests); err != nil {
         log.Printf("Create storage asset error: %w. File: %s", err, path)
+        if status, ok := storageErrorStatus(err); ok {
+            http.Error(w, err.Error(), status)
+            return
+        }
         http.Error(w, fmt.Sprintf("Create storage asset error: %w. File: %s", err, path), http.StatusInternalServerError)
         return
     }
@@ -101,8 +106,19 @@ func BufferAppend(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    if _, err := context.storages.Buffers().Append(bid, r.Body); err != nil {
+    digests, err := digestHeaders(r.Header)
+    if err != nil {
+        log.Printf("Digest headers error: %s", err)
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    if _, err := context.storages.Buffers().Append(bid, storage_ifaces.NewDigestReader(r.Body, digests)); err != nil {
         log.Printf("Append buffer error: %w", err)
+        if errors.Is(err, storage_ifaces.DIGEST_MISMATCH) {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
         http.Error(w, fmt.Sprintf("Append buffer error: %w", err), http.StatusInternalServerError)
         return
     }
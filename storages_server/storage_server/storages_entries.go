@@ -5,8 +5,12 @@ import (
     "io"
     "log"
     "net/http"
+    "net/url"
     "strconv"
+    "strings"
     "fmt"
+    "errors"
+    "time"
 
     "github.com/go-chi/chi"
 
@@ -25,9 +29,37 @@ func StorageCreate(w http.ResponseWriter, r *http.Request) {
 
     log.Printf("Create ne '%s' storage.", storage_ifaces.StorageType_toString(st))
 
-    s := context.storages.Create(st)
-    if s == nil {
-        http.Error(w, "Failed to create storage!", http.StatusInternalServerError)
+    name := getProperties(r.URL.Query())["name"]
+    if len(name) > 0 {
+        if err := checkStorageName(name); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+    }
+
+    expires, err := expiresArg(r.URL.Query())
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    quota, err := quotaArg(r.URL.Query())
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    s, err := context.storages.CreateWithOpts(st, storage_ifaces.StorageCreateOpts{Name: name, Expires: expires, Quota: quota})
+    if err != nil {
+        log.Printf("Create storage error: %s", err)
+        switch {
+        case errors.Is(err, storage_ifaces.BAD_NAME):
+            http.Error(w, err.Error(), http.StatusBadRequest)
+        case errors.Is(err, storage_ifaces.NAME_EXIST):
+            http.Error(w, err.Error(), http.StatusConflict)
+        default:
+            http.Error(w, "Failed to create storage!", http.StatusInternalServerError)
+        }
         return
     }
 
@@ -37,21 +69,201 @@ func StorageCreate(w http.ResponseWriter, r *http.Request) {
 }
 
 
-func StorageDestroy(w http.ResponseWriter, r *http.Request) {
+func StoragesList(w http.ResponseWriter, r *http.Request) {
+    resp, err := json.Marshal(context.storages.ListStorages())
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    jsonResponse(w, resp)
+}
+
+
+func StorageAlias(w http.ResponseWriter, r *http.Request) {
+    alias := chi.URLParam(r, "alias")
+    if err := checkStorageName(alias); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
     sid := chi.URLParam(r, "sid")
     if len(sid) < 1 {
         http.Error(w, "Empty storage id!", http.StatusNotFound)
         return
     }
 
-    id := storage_ifaces.MakeStorageId(sid)
+    s := context.storages.Get(storage_ifaces.MakeStorageId(sid))
+    if s == nil {
+        http.Error(w, "Unknown storage id!", http.StatusNotFound)
+        return
+    }
+
+    if err := context.storages.SetAlias(alias, s.Id); err != nil {
+        log.Printf("Set alias error: %s", err)
+        switch {
+        case errors.Is(err, storage_ifaces.BAD_NAME):
+            http.Error(w, err.Error(), http.StatusBadRequest)
+        case errors.Is(err, storage_ifaces.NAME_EXIST):
+            http.Error(w, err.Error(), http.StatusConflict)
+        default:
+            http.Error(w, "Failed to set alias!", http.StatusInternalServerError)
+        }
+        return
+    }
+
+    log.Printf("Alias: %s points to storage with id: %s", alias, s.Id.String())
+
+    jsonResponse(w, s.Id.Json())
+}
+
+
+func StorageUnalias(w http.ResponseWriter, r *http.Request) {
+    alias := chi.URLParam(r, "alias")
+    if len(alias) < 1 {
+        http.Error(w, "Empty alias!", http.StatusNotFound)
+        return
+    }
+
+    if err := context.storages.DeleteAlias(alias); err != nil {
+        log.Printf("Delete alias error: %s", err)
+        if errors.Is(err, storage_ifaces.NAME_NOT_EXIST) {
+            http.Error(w, err.Error(), http.StatusNotFound)
+            return
+        }
+        http.Error(w, "Failed to delete alias!", http.StatusInternalServerError)
+        return
+    }
+
+    log.Printf("Deleted alias: %s", alias)
+}
+
+
+//  Set storage expiration time by 'ttl' or 'expires' query args. Without
+// args the storage expiration is cancelled.
+func StorageExpire(w http.ResponseWriter, r *http.Request) {
+    sid := chi.URLParam(r, "sid")
+    if len(sid) < 1 {
+        http.Error(w, "Empty storage id!", http.StatusNotFound)
+        return
+    }
+
+    s := context.storages.Get(storage_ifaces.MakeStorageId(sid))
+    if s == nil {
+        http.Error(w, "Unknown storage id!", http.StatusNotFound)
+        return
+    }
+
+    expires, err := expiresArg(r.URL.Query())
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    if err := context.storages.SetExpires(s.Id, expires); err != nil {
+        log.Printf("Set storage expiration error: %s", err)
+        http.Error(w, "Failed to set storage expiration!", http.StatusInternalServerError)
+        return
+    }
+
+    jsonResponse(w, s.Id.Json())
+}
+
+
+// Seal storage. Sealed storage is read-only.
+func StorageSeal(w http.ResponseWriter, r *http.Request) {
+    sid := chi.URLParam(r, "sid")
+    if len(sid) < 1 {
+        http.Error(w, "Empty storage id!", http.StatusNotFound)
+        return
+    }
 
-    if s := context.storages.Get(id); s == nil {
+    s := context.storages.Get(storage_ifaces.MakeStorageId(sid))
+    if s == nil {
         http.Error(w, "Unknown storage id!", http.StatusNotFound)
         return
     }
 
+    seal, err := context.storages.Seal(s.Id)
+    if err != nil {
+        log.Printf("Seal storage error: %s", err)
+        if errors.Is(err, storage_ifaces.SEALED) {
+            http.Error(w, err.Error(), http.StatusConflict)
+            return
+        }
+        http.Error(w, "Failed to seal storage!", http.StatusInternalServerError)
+        return
+    }
+
+    resp, err := json.Marshal(seal)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    jsonResponse(w, resp)
+}
+
+
+// Convert storage to another storage type in place.
+func StorageConvert(w http.ResponseWriter, r *http.Request) {
+    sid := chi.URLParam(r, "sid")
+    if len(sid) < 1 {
+        http.Error(w, "Empty storage id!", http.StatusNotFound)
+        return
+    }
+
+    storageTypeString := chi.URLParam(r, "type")
+    if len(storageTypeString) < 1 {
+        http.Error(w, "Empty storage type!", http.StatusNotFound)
+        return
+    }
+
+    s := context.storages.Get(storage_ifaces.MakeStorageId(sid))
+    if s == nil {
+        http.Error(w, "Unknown storage id!", http.StatusNotFound)
+        return
+    }
+
+    st := storage_ifaces.StorageType_fromString(storageTypeString)
+
+    log.Printf("Convert storage: %s to '%s' storage.", s.Id.String(), storage_ifaces.StorageType_toString(st))
+
+    if err := context.storages.Convert(s.Id, st); err != nil {
+        log.Printf("Convert storage error: %s", err)
+        if status, ok := storageErrorStatus(err); ok {
+            http.Error(w, err.Error(), status)
+            return
+        }
+        http.Error(w, "Failed to convert storage!", http.StatusInternalServerError)
+        return
+    }
+
+    jsonResponse(w, s.Id.Json())
+}
+
+
+func StorageDestroy(w http.ResponseWriter, r *http.Request) {
+    sid := chi.URLParam(r, "sid")
+    if len(sid) < 1 {
+        http.Error(w, "Empty storage id!", http.StatusNotFound)
+        return
+    }
+
+    s := context.storages.Get(storage_ifaces.MakeStorageId(sid))
+    if s == nil {
+        http.Error(w, "Unknown storage id!", http.StatusNotFound)
+        return
+    }
+
+    id := s.Id
+
     if err := context.storages.Destroy(id); err != nil {
+        log.Printf("Destroy storage error: %s", err)
+        if status, ok := storageErrorStatus(err); ok {
+            http.Error(w, err.Error(), status)
+            return
+        }
         http.Error(w, "Failed to delete storage!", http.StatusInternalServerError)
         return
     }
@@ -83,7 +295,106 @@ func StorageList(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    resp, err := s.List()
+    args := r.URL.Query()
+
+    opts, err := listOpts(args)
+    if err == nil {
+        err = opts.Validate()
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    switch {
+    case args.Get("format") == "ndjson":
+        listNDJSON(w, s, opts)
+    case len(args.Get("limit")) > 0 || len(opts.Token) > 0 || len(opts.Delimiter) > 0:
+        listPage(w, s, opts)
+    default:
+        w.Header().Set("Content-Type", "application/json")
+        if err := s.ListArray(w, opts); err != nil {
+            log.Printf("List storage error: %s", err)
+        }
+    }
+}
+
+
+// Maximum entries count of the listing page.
+const maxListLimit = 1000
+
+
+// Query args used by listing. All other args are properties filter.
+var listArgs = []string{"prefix", "delimiter", "pattern", "limit", "token", "format"}
+
+
+func listOpts(args url.Values) (storage_ifaces.StorageListOpts, error) {
+    opts := storage_ifaces.StorageListOpts{
+        Prefix:     args.Get("prefix"),
+        Delimiter:  args.Get("delimiter"),
+        Pattern:    args.Get("pattern"),
+        Token:      args.Get("token"),
+    }
+
+    if limitStr := args.Get("limit"); len(limitStr) > 0 {
+        limit, err := strconv.Atoi(limitStr)
+        if err != nil {
+            return opts, fmt.Errorf("Limit conversion error: %s", err)
+        }
+        if limit > maxListLimit {
+            return opts, fmt.Errorf("Limit %d exceeds maximum: %d", limit, maxListLimit)
+        }
+        opts.Limit = limit
+    }
+
+    filter := url.Values{}
+    for k, v := range args {
+        filter[k] = v
+    }
+    for _, k := range listArgs {
+        delete(filter, k)
+    }
+
+    opts.Filter = assetProperties(filter)
+
+    return opts, nil
+}
+
+
+type storageListPage struct {
+    Elements        []storage_ifaces.StorageListEntry   `json:"elements"`
+    CommonPrefixes  []storage_ifaces.Path               `json:"common_prefixes,omitempty"`
+    NextToken       string                              `json:"next_token,omitempty"`
+}
+
+
+// Single JSON object with the listing page. Page size is limited by the
+// 'limit' query arg, or by maxListLimit if the arg is omitted.
+func listPage(w http.ResponseWriter, s *storage_ifaces.Storage, opts storage_ifaces.StorageListOpts) {
+    if opts.Limit == 0 {
+        opts.Limit = maxListLimit
+    }
+
+    page := storageListPage{
+        Elements: make([]storage_ifaces.StorageListEntry, 0, opts.Limit),
+    }
+
+    token, err := s.ListEntries(opts, func(entry storage_ifaces.StorageListEntry) error {
+        if len(entry.Prefix) > 0 {
+            page.CommonPrefixes = append(page.CommonPrefixes, entry.Prefix)
+        } else {
+            page.Elements = append(page.Elements, entry)
+        }
+        return nil
+    })
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    page.NextToken = token
+
+    resp, err := json.Marshal(page)
     if err != nil {
         http.Error(w, err.Error(), http.StatusInternalServerError)
         return
@@ -93,6 +404,59 @@ func StorageList(w http.ResponseWriter, r *http.Request) {
 }
 
 
+// Newline delimited JSON stream. Every line is an asset element or a
+// common prefix, the last line contains continuation token if the
+// listing is truncated.
+func listNDJSON(w http.ResponseWriter, s *storage_ifaces.Storage, opts storage_ifaces.StorageListOpts) {
+    w.Header().Set("Content-Type", "application/x-ndjson")
+
+    encoder := json.NewEncoder(w)
+
+    token, err := s.ListEntries(opts, func(entry storage_ifaces.StorageListEntry) error {
+        return encoder.Encode(entry)
+    })
+    if err != nil {
+        log.Printf("List storage error: %s", err)
+        return
+    }
+
+    if len(token) > 0 {
+        encoder.Encode(struct {
+            NextToken string `json:"next_token"`
+        }{token})
+    }
+}
+
+
+// Headers with the vault object id the new asset have to reference. The
+// X-Link-Sha256 is kept for the clients using bare hex sha256 ids.
+var linkHeaders = []string{"X-Link-Object", "X-Link-Sha256"}
+
+
+func linkObject(h http.Header) (string, string) {
+    for _, header := range linkHeaders {
+        if object := h.Get(header); len(object) > 0 {
+            return header, object
+        }
+    }
+    return "", ""
+}
+
+
+//  Check if the request has non empty body. Chunked bodies have unknown
+// length, so the first byte is read.
+func hasBody(r *http.Request) bool {
+    if r.ContentLength >= 0 {
+        return r.ContentLength > 0
+    }
+
+    var b [1]byte
+    n, _ := io.ReadFull(r.Body, b[:])
+
+    return n > 0
+}
+
+
 func StoragePutElement(w http.ResponseWriter, r *http.Request) {
     sid := chi.URLParam(r, "sid")
     if len(sid) < 1 {
@@ -102,7 +466,7 @@ func StoragePutElement(w http.ResponseWriter, r *http.Request) {
 
     log.Printf("PUT url path: %s", r.URL.Path)
 
-    path, ok := extractPath(r.URL.Path, sid)
+    path, ok := extractPath(r)
     if !ok {
         log.Printf("Empty path!")
         http.Error(w, "Empty path!", http.StatusNotFound)
@@ -118,30 +482,56 @@ func StoragePutElement(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    mode := 0o644
-    modeStr := getProperties(r.URL.Query())["mode"]
-    log.Printf("mode str: %s", modeStr)
-    if len(modeStr) != 0 {
-        modeVal, err := strconv.ParseInt(modeStr, 0, 32)
-        if err != nil {
-            log.Printf("Permission conversion error: %w. File: %s", err, path)
-            http.Error(w, fmt.Sprintf("Permission conversion error: %w. File: %s", err, path), http.StatusInternalServerError)
-            return
-        }
-        mode = int(modeVal)
+    opts, err := assetOptsArgs(r.URL.Query())
+    if err != nil {
+        log.Printf("Asset options error: %s. File: %s", err, path)
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
     }
 
 
-    err := s.CreateAsset(path, &storage_ifaces.StorageAssetReader{
-        Reader: r.Body,
-        Opts: storage_ifaces.StorageAssetOpts{Mode: mode},
-    })
+    cond := storage_ifaces.StorageAssetCond{
+        IfMatch:        r.Header.Get("If-Match"),
+        IfNoneMatch:    r.Header.Get("If-None-Match"),
+    }
+
+    digests, err := digestHeaders(r.Header)
     if err != nil {
-        log.Printf("Error on creating storage element: %w. File: %s", err, path)
+        log.Printf("Digest headers error: %s. File: %s", err, path)
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    var etag string
+
+    // Asset referencing the vault object is created without the body.
+    if header, object := linkObject(r.Header); len(object) > 0 {
+        if hasBody(r) {
+            http.Error(w, fmt.Sprintf("Body is not allowed with %s header!", header), http.StatusBadRequest)
+            return
+        }
+        etag, err = s.LinkAsset(path, object, opts, cond)
+    } else {
+        etag, err = s.WriteAsset(path, &storage_ifaces.StorageAssetReader{
+            Reader: storage_ifaces.NewDigestReader(r.Body, digests),
+            Opts:   opts,
+        }, cond)
+    }
+    if err != nil {
+        log.Printf("Error on creating storage element: %s. File: %s", err, path)
+        if errors.Is(err, storage_ifaces.PRECONDITION_FAILED) {
+            http.Error(w, err.Error(), http.StatusPreconditionFailed)
+            return
+        }
+        if status, ok := storageErrorStatus(err); ok {
+            http.Error(w, err.Error(), status)
+            return
+        }
         http.Error(w, "Error on creating storage element!", http.StatusInternalServerError)
         return
     }
 
+    w.Header().Set("ETag", fmt.Sprintf("\"%s\"", etag))
     w.WriteHeader(http.StatusCreated)
 }
 
@@ -155,7 +545,7 @@ func StorageGetElement(w http.ResponseWriter, r *http.Request) {
 
     log.Printf("GET url path: %s", r.URL.Path)
 
-    path, ok := extractPath(r.URL.Path, sid)
+    path, ok := extractPath(r)
     if !ok {
         http.Error(w, "Empty path!", http.StatusNotFound)
         return
@@ -169,13 +559,37 @@ func StorageGetElement(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    reader, err := s.ReadAsset(path)
+    info, reader, err := s.OpenAsset(path)
     if err != nil {
-        http.Error(w, fmt.Sprintf("%w", err), http.StatusNotFound)
+        if errors.Is(err, storage_ifaces.ASSET_NOT_EXIST) {
+            http.Error(w, err.Error(), http.StatusNotFound)
+            return
+        }
+        if status, ok := storageErrorStatus(err); ok {
+            http.Error(w, err.Error(), status)
+            return
+        }
+        http.Error(w, "Error on reading storage element!", http.StatusInternalServerError)
         return
     }
     defer reader.Close()
 
+    setInfoHeaders(w, info, assetCacheControl(s, sid))
+
+    if notModified(r, info) {
+        w.WriteHeader(http.StatusNotModified)
+        return
+    }
+
+    // Seekable readers are served with Range and If-Range support.
+    if rs, ok := reader.ReadSeeker(); ok {
+        http.ServeContent(w, r, path, info.Modified, rs)
+        log.Printf("get sid: %s path: %s", sid, path)
+        return
+    }
+
+    w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+
     _, err = io.Copy(w, reader)
     if err != nil {
         http.Error(w, "Error on reading storage element!", http.StatusInternalServerError)
@@ -184,3 +598,217 @@ func StorageGetElement(w http.ResponseWriter, r *http.Request) {
 
     log.Printf("get sid: %s path: %s", sid, path)
 }
+
+
+func StorageDeleteElement(w http.ResponseWriter, r *http.Request) {
+    sid := chi.URLParam(r, "sid")
+    if len(sid) < 1 {
+        http.Error(w, "Empty storage id!", http.StatusNotFound)
+        return
+    }
+
+    log.Printf("DELETE url path: %s", r.URL.Path)
+
+    path, ok := extractPath(r)
+    if !ok {
+        http.Error(w, "Empty path!", http.StatusNotFound)
+        return
+    }
+
+    id := storage_ifaces.MakeStorageId(sid)
+
+    s := context.storages.Get(id)
+    if s == nil {
+        http.Error(w, "Unknown storage id!", http.StatusNotFound)
+        return
+    }
+
+    if err := s.DeleteAsset(path); err != nil {
+        log.Printf("Error on deleting storage element: %s. File: %s", err, path)
+        if errors.Is(err, storage_ifaces.ASSET_NOT_EXIST) {
+            http.Error(w, err.Error(), http.StatusNotFound)
+            return
+        }
+        if status, ok := storageErrorStatus(err); ok {
+            http.Error(w, err.Error(), status)
+            return
+        }
+        http.Error(w, "Error on deleting storage element!", http.StatusInternalServerError)
+        return
+    }
+
+    log.Printf("delete sid: %s path: %s", sid, path)
+}
+
+
+func StorageMoveElement(w http.ResponseWriter, r *http.Request) {
+    sid := chi.URLParam(r, "sid")
+    if len(sid) < 1 {
+        http.Error(w, "Empty storage id!", http.StatusNotFound)
+        return
+    }
+
+    log.Printf("MOVE url path: %s", r.URL.Path)
+
+    path, ok := extractPath(r)
+    if !ok {
+        http.Error(w, "Empty path!", http.StatusNotFound)
+        return
+    }
+
+    to := getProperties(r.URL.Query())["to"]
+    if len(to) < 1 {
+        http.Error(w, "Empty destination path!", http.StatusBadRequest)
+        return
+    }
+
+    id := storage_ifaces.MakeStorageId(sid)
+
+    s := context.storages.Get(id)
+    if s == nil {
+        http.Error(w, "Unknown storage id!", http.StatusNotFound)
+        return
+    }
+
+    if err := s.MoveAsset(path, to); err != nil {
+        log.Printf("Error on moving storage element: %s. File: %s", err, path)
+        switch {
+        case errors.Is(err, storage_ifaces.ASSET_NOT_EXIST):
+            http.Error(w, err.Error(), http.StatusNotFound)
+        case errors.Is(err, storage_ifaces.ASSET_EXIST), errors.Is(err, storage_ifaces.SEALED):
+            http.Error(w, err.Error(), http.StatusConflict)
+        case errors.Is(err, storage_ifaces.BAD_PATH):
+            http.Error(w, err.Error(), http.StatusBadRequest)
+        default:
+            http.Error(w, "Error on moving storage element!", http.StatusInternalServerError)
+        }
+        return
+    }
+
+    log.Printf("move sid: %s path: %s to: %s", sid, path, to)
+}
+
+
+//  Sealed storage assets never change. Names and aliases can be moved
+// to another storage, so only assets addressed by the storage id are
+// cached for long.
+const (
+    sealedCacheControl      = vaultCacheControl
+    sealedNameCacheControl  = "public, max-age=300, immutable"
+)
+
+
+//  Get asset Cache-Control value. Assets of the mutable storages can be
+// replaced, so clients have to revalidate cached content.
+func assetCacheControl(s *storage_ifaces.Storage, sid string) string {
+    switch {
+    case !s.IsSealed():
+        return "no-cache"
+    case sid == s.Id.Id:
+        return sealedCacheControl
+    default:
+        return sealedNameCacheControl
+    }
+}
+
+
+//  Check conditional GET request validators against the asset info.
+// If-None-Match takes precedence over If-Modified-Since (RFC 7232).
+func notModified(r *http.Request, info *storage_ifaces.StorageAssetInfo) bool {
+    if r.Method != http.MethodGet && r.Method != http.MethodHead {
+        return false
+    }
+
+    if inm := r.Header.Get("If-None-Match"); len(inm) > 0 {
+        etag := fmt.Sprintf("\"%s\"", info.ETag)
+        for _, tag := range strings.Split(inm, ",") {
+            tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+            if tag == "*" || tag == etag {
+                return true
+            }
+        }
+        return false
+    }
+
+    ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+    if err != nil || info.Modified.IsZero() {
+        return false
+    }
+
+    return !info.Modified.Truncate(time.Second).After(ims)
+}
+
+
+// Set asset validators and information headers.
+func setInfoHeaders(w http.ResponseWriter, info *storage_ifaces.StorageAssetInfo, cacheControl string) {
+    w.Header().Set("ETag", fmt.Sprintf("\"%s\"", info.ETag))
+    w.Header().Set("Cache-Control", cacheControl)
+    w.Header().Set("Last-Modified", info.Modified.UTC().Format(http.TimeFormat))
+    for algorithm, digest := range info.Digests {
+        w.Header().Set(checksumHeader(algorithm), digest)
+    }
+    w.Header().Set("X-Asset-Mode", fmt.Sprintf("0%03o", info.Mode))
+    w.Header().Set("X-Asset-Created", info.Created.UTC().Format(http.TimeFormat))
+    if !info.IsFile() {
+        w.Header().Set("X-Asset-Kind", info.Kind)
+    }
+    if len(info.Target) > 0 {
+        w.Header().Set("X-Asset-Target", info.Target)
+    }
+    if info.Mtime != nil {
+        w.Header().Set("X-Asset-Mtime", info.Mtime.UTC().Format(time.RFC3339Nano))
+    }
+    if info.Uid != nil {
+        w.Header().Set("X-Asset-Uid", strconv.Itoa(*info.Uid))
+    }
+    if info.Gid != nil {
+        w.Header().Set("X-Asset-Gid", strconv.Itoa(*info.Gid))
+    }
+}
+
+
+func StorageStatElement(w http.ResponseWriter, r *http.Request) {
+    sid := chi.URLParam(r, "sid")
+    if len(sid) < 1 {
+        http.Error(w, "Empty storage id!", http.StatusNotFound)
+        return
+    }
+
+    log.Printf("STAT url path: %s", r.URL.Path)
+
+    path, ok := extractPath(r)
+    if !ok {
+        http.Error(w, "Empty path!", http.StatusNotFound)
+        return
+    }
+
+    id := storage_ifaces.MakeStorageId(sid)
+
+    s := context.storages.Get(id)
+    if s == nil {
+        http.Error(w, "Unknown storage id!", http.StatusNotFound)
+        return
+    }
+
+    info, err := s.StatAsset(path)
+    if err != nil {
+        if errors.Is(err, storage_ifaces.ASSET_NOT_EXIST) {
+            http.Error(w, err.Error(), http.StatusNotFound)
+            return
+        }
+        if status, ok := storageErrorStatus(err); ok {
+            http.Error(w, err.Error(), status)
+            return
+        }
+        http.Error(w, "Error on stat storage element!", http.StatusInternalServerError)
+        return
+    }
+
+    resp, err := json.Marshal(info)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    jsonResponse(w, resp)
+}
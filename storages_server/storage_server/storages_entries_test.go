@@ -0,0 +1,73 @@
+package storage_server
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "../storage/ifaces"
+)
+
+
+func TestAssetCacheControl(t *testing.T) {
+
+    s := &storage_ifaces.Storage{Id: storage_ifaces.MakeStorageId("id"), UniqueName: "name"}
+
+    if cc := assetCacheControl(s, "id"); cc != "no-cache" {
+        t.Fatalf("Unexpected Cache-Control: %s", cc)
+    }
+
+    s.Sealed = &storage_ifaces.StorageSeal{Time: time.Now().UTC()}
+
+    if cc := assetCacheControl(s, "id"); cc != sealedCacheControl {
+        t.Fatalf("Unexpected Cache-Control: %s", cc)
+    }
+    if cc := assetCacheControl(s, "name"); cc != sealedNameCacheControl {
+        t.Fatalf("Unexpected Cache-Control: %s", cc)
+    }
+}
+
+
+func TestNotModified(t *testing.T) {
+
+    modified := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+
+    info := &storage_ifaces.StorageAssetInfo{ETag: "abc", Modified: modified}
+
+    cases := []struct {
+        method  string
+        header  string
+        value   string
+        result  bool
+    }{
+        {http.MethodGet, "If-None-Match", `"abc"`, true},
+        {http.MethodHead, "If-None-Match", `"xyz", W/"abc"`, true},
+        {http.MethodGet, "If-None-Match", `*`, true},
+        {http.MethodGet, "If-None-Match", `"xyz"`, false},
+        {http.MethodPut, "If-None-Match", `"abc"`, false},
+        {http.MethodGet, "If-Modified-Since", modified.Format(http.TimeFormat), true},
+        {http.MethodGet, "If-Modified-Since", modified.Add(-time.Second).Format(http.TimeFormat), false},
+        {http.MethodGet, "If-Modified-Since", "garbage", false},
+        {http.MethodGet, "", "", false},
+    }
+
+    for _, c := range cases {
+        r := httptest.NewRequest(c.method, "/api/v1/storage/id/asset", nil)
+        if len(c.header) > 0 {
+            r.Header.Set(c.header, c.value)
+        }
+
+        if result := notModified(r, info); result != c.result {
+            t.Fatalf("Unexpected result: %v for %s %s: %s", result, c.method, c.header, c.value)
+        }
+    }
+
+    // If-None-Match takes precedence.
+    r := httptest.NewRequest(http.MethodGet, "/api/v1/storage/id/asset", nil)
+    r.Header.Set("If-None-Match", `"xyz"`)
+    r.Header.Set("If-Modified-Since", modified.Format(http.TimeFormat))
+    if notModified(r, info) {
+        t.Fatal("Unexpected not modified result!")
+    }
+}
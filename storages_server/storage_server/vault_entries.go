@@ -0,0 +1,210 @@
+package storage_server
+
+import (
+    "encoding/json"
+    "log"
+    "net/http"
+    "os"
+    "fmt"
+    "strconv"
+
+    "github.com/go-chi/chi"
+
+    "../storage/ifaces"
+)
+
+
+// Vault objects are content addressed, so they never change.
+const vaultCacheControl = "public, max-age=31536000, immutable"
+
+
+func vaultObjectParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+    object := chi.URLParam(r, "object")
+    if !storage_ifaces.IsVaultObject(object) {
+        log.Printf("Bad vault object id: %s", object)
+        http.Error(w, fmt.Sprintf("Bad vault object id: %s", object), http.StatusBadRequest)
+        return "", false
+    }
+    return object, true
+}
+
+
+func VaultGetObject(w http.ResponseWriter, r *http.Request) {
+    object, ok := vaultObjectParam(w, r)
+    if !ok {
+        return
+    }
+
+    log.Printf("GET vault object: %s", object)
+
+    vault := context.storages.Vault()
+
+    asset := storage_ifaces.VaultAsset{Object: object}
+
+    fi, err := vault.StatObject(asset)
+    if err != nil {
+        if os.IsNotExist(err) {
+            http.Error(w, "Unknown vault object!", http.StatusNotFound)
+            return
+        }
+        log.Printf("Stat vault object error: %s", err)
+        http.Error(w, "Error on stat vault object!", http.StatusInternalServerError)
+        return
+    }
+
+    f, err := vault.OpenObject(asset)
+    if err != nil {
+        log.Printf("Open vault object error: %s", err)
+        http.Error(w, "Unknown vault object!", http.StatusNotFound)
+        return
+    }
+    defer f.Close()
+
+    w.Header().Set("ETag", fmt.Sprintf("\"%s\"", object))
+    w.Header().Set("Cache-Control", vaultCacheControl)
+    w.Header().Set("Content-Type", "application/octet-stream")
+    algorithm, digest, _ := storage_ifaces.ParseVaultObject(object)
+    w.Header().Set(checksumHeader(algorithm), digest)
+
+    http.ServeContent(w, r, object, fi.ModTime(), f.File)
+}
+
+
+// Vault object reference with the storage name.
+type vaultRefEntry struct {
+    storage_ifaces.VaultRef
+    Name    string  `json:"name,omitempty"`
+}
+
+
+func VaultObjectRefs(w http.ResponseWriter, r *http.Request) {
+    object, ok := vaultObjectParam(w, r)
+    if !ok {
+        return
+    }
+
+    refs := context.storages.Vault().Refs(object)
+    if len(refs) == 0 {
+        http.Error(w, "Unknown vault object!", http.StatusNotFound)
+        return
+    }
+
+    entries := make([]vaultRefEntry, 0, len(refs))
+    for _, ref := range refs {
+        entry := vaultRefEntry{VaultRef: ref}
+        if s := context.storages.Get(ref.StorageId); s != nil {
+            entry.Name = s.UniqueName
+        }
+        entries = append(entries, entry)
+    }
+
+    resp, err := json.Marshal(struct {
+        Object  string              `json:"object"`
+        Refs    []vaultRefEntry     `json:"refs"`
+    }{storage_ifaces.CanonicalVaultObject(object), entries})
+    if err != nil {
+        http.Error(w, "Refs encoding error!", http.StatusInternalServerError)
+        return
+    }
+
+    jsonResponse(w, resp)
+}
+
+
+// Max objects count in the single VaultHaveObjects request.
+const maxHaveObjects = 10000
+
+//  Max JSON encoding overhead per object id in the VaultHaveObjects
+// request: quotes, comma and some whitespace.
+const haveObjectOverhead = 8
+
+
+//  Check which of the requested objects are in the vault. Request body is
+// JSON array of object ids, the ids are returned in the same form.
+func VaultHaveObjects(w http.ResponseWriter, r *http.Request) {
+    var objects []string
+
+    maxBody := int64(maxHaveObjects * (storage_ifaces.MaxVaultObjectLen() + haveObjectOverhead))
+
+    decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBody))
+    if err := decoder.Decode(&objects); err != nil {
+        log.Printf("Objects list decode error: %s", err)
+        http.Error(w, fmt.Sprintf("Objects list decode error: %s", err), http.StatusBadRequest)
+        return
+    }
+
+    if len(objects) > maxHaveObjects {
+        http.Error(w, fmt.Sprintf("Too many objects: %d! Max: %d", len(objects), maxHaveObjects), http.StatusBadRequest)
+        return
+    }
+
+    result := struct {
+        Have    []string    `json:"have"`
+        Missing []string    `json:"missing"`
+    }{make([]string, 0, len(objects)), make([]string, 0)}
+
+    vault := context.storages.Vault()
+
+    for _, object := range objects {
+        if !storage_ifaces.IsVaultObject(object) {
+            http.Error(w, fmt.Sprintf("Bad vault object id: %s", object), http.StatusBadRequest)
+            return
+        }
+
+        if _, err := vault.StatObject(storage_ifaces.VaultAsset{Object: object}); err == nil {
+            result.Have = append(result.Have, object)
+        } else {
+            result.Missing = append(result.Missing, object)
+        }
+    }
+
+    resp, err := json.Marshal(result)
+    if err != nil {
+        http.Error(w, "Result encoding error!", http.StatusInternalServerError)
+        return
+    }
+
+    jsonResponse(w, resp)
+}
+
+
+func vaultCheck(w http.ResponseWriter, opts storage_ifaces.VaultCheckOpts) {
+    report, err := context.storages.CheckVault(opts)
+    if err != nil {
+        log.Printf("Vault check error: %s", err)
+        http.Error(w, fmt.Sprintf("Vault check error: %s", err), http.StatusInternalServerError)
+        return
+    }
+
+    resp, err := json.Marshal(report)
+    if err != nil {
+        http.Error(w, "Result encoding error!", http.StatusInternalServerError)
+        return
+    }
+
+    jsonResponse(w, resp)
+}
+
+
+//  Check the vault consistency. Query arg 'repair' (bool) replaces the
+// refs database by the references rebuilt from the storages assets.
+func VaultFsck(w http.ResponseWriter, r *http.Request) {
+    opts := storage_ifaces.VaultCheckOpts{}
+
+    if repairStr := r.URL.Query().Get("repair"); len(repairStr) != 0 {
+        repair, err := strconv.ParseBool(repairStr)
+        if err != nil {
+            http.Error(w, fmt.Sprintf("Bad repair value: %s", repairStr), http.StatusBadRequest)
+            return
+        }
+        opts.Repair = repair
+    }
+
+    vaultCheck(w, opts)
+}
+
+
+// Remove orphaned vault objects, the refs database is repaired as well.
+func VaultGc(w http.ResponseWriter, r *http.Request) {
+    vaultCheck(w, storage_ifaces.VaultCheckOpts{Repair: true, Gc: true})
+}
@@ -4,14 +4,32 @@ SERVER_BASE_URL="http://127.0.0.1:5555/api/v1"
 # CURL="curl --no-progress-meter"
 CURL="curl"
 
-SID=$(${CURL} "${SERVER_BASE_URL}/storage/create/default" | jq -r '.sid')
+SID=$(${CURL} "${SERVER_BASE_URL}/storage/create/default?name=nightly-$(date +%F-%s)&ttl=1h" | jq -r '.sid')
 
 echo "sid: ${SID}"
 
+${CURL} -X GET "${SERVER_BASE_URL}/storage/alias/latest-nightly/${SID}"
+
 ${CURL} -X PUT -d "test file1 content\n" "${SERVER_BASE_URL}/storage/${SID}/test_file1?mode=0777"
 ${CURL} -X GET "${SERVER_BASE_URL}/storage/${SID}/test_file1"
-${CURL} -X PUT -d "test file2 content\n" "${SERVER_BASE_URL}/storage/${SID}/dir/test_file2?mode=0777"
+${CURL} -X PUT -H "If-None-Match: *" -d "test file1 content\n" "${SERVER_BASE_URL}/storage/${SID}/test_file1?mode=0777"
+${CURL} -X PUT -d "test file1 new content\n" "${SERVER_BASE_URL}/storage/${SID}/test_file1?mode=0777"
+${CURL} -X PUT -H "Content-MD5: $(printf 'test file1 new content\\n' | openssl md5 -binary | base64)" -d "test file1 new content\n" "${SERVER_BASE_URL}/storage/${SID}/test_file1_md5"
+${CURL} -X PUT -H "Content-MD5: 1B2M2Y8AsgTpgAmY7PhCfg==" -d "truncated" "${SERVER_BASE_URL}/storage/${SID}/test_file1_bad"
+${CURL} -X PUT -d "test file2 content\n" "${SERVER_BASE_URL}/storage/${SID}/dir/test_file2?mode=0777&build_id=42&arch=x86_64"
 ${CURL} -X GET "${SERVER_BASE_URL}/storage/${SID}/dir/test_file2"
+${CURL} -I "${SERVER_BASE_URL}/storage/${SID}/dir/test_file2"
+${CURL} -r 5-8 "${SERVER_BASE_URL}/storage/${SID}/dir/test_file2"
+${CURL} -X GET "${SERVER_BASE_URL}/storage/stat/${SID}/dir/test_file2"
+${CURL} -X GET "${SERVER_BASE_URL}/storage/move/${SID}/dir/test_file2?to=release/test_file2"
+${CURL} -X GET "${SERVER_BASE_URL}/storage/${SID}/release/test_file2"
+${CURL} -X DELETE "${SERVER_BASE_URL}/storage/${SID}/release/test_file2"
+${CURL} -X PUT "${SERVER_BASE_URL}/storage/${SID}/dir/test_link?kind=symlink&target=../test_file1"
+${CURL} -I "${SERVER_BASE_URL}/storage/${SID}/dir/test_link"
+${CURL} -X PUT -d "#!/bin/sh\n" "${SERVER_BASE_URL}/storage/${SID}/bin/tool?mode=04755&mtime=2020-03-01T12:30:00Z&uid=$(id -u)&gid=$(id -g)"
+${CURL} -I "${SERVER_BASE_URL}/storage/${SID}/bin/tool"
+${CURL} -X PUT "${SERVER_BASE_URL}/storage/${SID}/empty_dir?kind=dir&mode=0755"
+${CURL} --path-as-is -X PUT -d "escaped\n" "${SERVER_BASE_URL}/storage/${SID}/dir/%2E%2E/%2E%2E/escaped"
 
 
 BID=$(${CURL} "${SERVER_BASE_URL}/storage/buffer/create" | jq -r '.sid')
@@ -21,8 +39,41 @@ ${CURL} -X PUT -d "123\n" "${SERVER_BASE_URL}/storage/buffer/${BID}"
 ${CURL} -X PUT -d "456\n" "${SERVER_BASE_URL}/storage/buffer/${BID}"
 ${CURL} -X PUT -d "789\n" "${SERVER_BASE_URL}/storage/buffer/${BID}"
 ${CURL} -X PUT -d "0\n" "${SERVER_BASE_URL}/storage/buffer/${BID}"
+${CURL} -X PUT -H "X-Checksum-Sha256: 0000000000000000000000000000000000000000000000000000000000000000" -d "garbage\n" "${SERVER_BASE_URL}/storage/buffer/${BID}"
 
 ${CURL} -X GET "${SERVER_BASE_URL}/storage/buffer/commit/${SID}/${BID}/test_file3?mode=0777"
 
 ${CURL} -X GET "${SERVER_BASE_URL}/storage/list/${SID}"
+${CURL} -X GET "${SERVER_BASE_URL}/storage/list/${SID}?arch=x86_64"
+${CURL} -X GET "${SERVER_BASE_URL}/storage/list/${SID}?delimiter=/&limit=2"
+${CURL} -X GET "${SERVER_BASE_URL}/storage/list/${SID}?prefix=dir/&format=ndjson"
+${CURL} -X GET "${SERVER_BASE_URL}/storage/"
+${CURL} -X GET "${SERVER_BASE_URL}/storage/list/latest-nightly"
+${CURL} -X GET "${SERVER_BASE_URL}/storage/archive/${SID}" | tar -tvf -
+${CURL} -X GET "${SERVER_BASE_URL}/storage/archive/${SID}?format=tar.gz&prefix=dir/" | tar -tzvf -
+${CURL} -X GET "${SERVER_BASE_URL}/storage/archive/${SID}?prefix=dir/" | ${CURL} -X PUT -T - "${SERVER_BASE_URL}/storage/import/${SID}?prefix=imported"
+${CURL} -X GET -o /tmp/storage.zip "${SERVER_BASE_URL}/storage/archive/${SID}?format=zip&mtime=2021-01-01T00:00:00Z" && unzip -l /tmp/storage.zip
+${CURL} -X GET "${SERVER_BASE_URL}/storage/unalias/latest-nightly"
+${CURL} -X GET "${SERVER_BASE_URL}/storage/expire/${SID}?ttl=24h"
+${CURL} -X GET "${SERVER_BASE_URL}/storage/expire/${SID}"
+${CURL} -X GET "${SERVER_BASE_URL}/storage/convert/${SID}/filesystem"
+${CURL} -X GET "${SERVER_BASE_URL}/storage/list/${SID}"
+${CURL} -X GET "${SERVER_BASE_URL}/storage/convert/${SID}/hashed"
+OBJ=$(${CURL} -sI "${SERVER_BASE_URL}/storage/${SID}/test_file1" | grep -i '^x-checksum-sha256:' | cut -d' ' -f2 | tr -d '\r')
+${CURL} -X GET "${SERVER_BASE_URL}/vault/${OBJ}"
+${CURL} -X GET "${SERVER_BASE_URL}/vault/${OBJ}/refs"
+${CURL} -I "${SERVER_BASE_URL}/vault/sha256:${OBJ}"
+${CURL} -X POST -d "[\"${OBJ}\"]" "${SERVER_BASE_URL}/vault/have"
+${CURL} -X GET "${SERVER_BASE_URL}/vault/fsck"
+${CURL} -X GET "${SERVER_BASE_URL}/vault/fsck?repair=true"
+${CURL} -X GET "${SERVER_BASE_URL}/vault/gc"
+${CURL} -X PUT -H "X-Link-Sha256: ${OBJ}" "${SERVER_BASE_URL}/storage/${SID}/linked_file1"
+${CURL} -X GET "${SERVER_BASE_URL}/storage/seal/${SID}"
+${CURL} -X PUT -d "test file4 content\n" "${SERVER_BASE_URL}/storage/${SID}/test_file4"
 ${CURL} -X GET "${SERVER_BASE_URL}/storage/destroy/${SID}"
+
+QSID=$(${CURL} "${SERVER_BASE_URL}/storage/create/default?max_size=16&max_assets=1" | jq -r '.sid')
+${CURL} -X PUT -d "quota exceeding file content\n" "${SERVER_BASE_URL}/storage/${QSID}/test_file1"
+${CURL} -X PUT -d "test file1\n" "${SERVER_BASE_URL}/storage/${QSID}/test_file1"
+${CURL} -X PUT -d "test file2\n" "${SERVER_BASE_URL}/storage/${QSID}/test_file2"
+${CURL} -X GET "${SERVER_BASE_URL}/storage/destroy/${QSID}"